@@ -0,0 +1,537 @@
+// Copyright 2012 Daniel Connelly.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rtreego
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// BulkLoad builds a new tree containing objs using Sort-Tile-Recursive
+// packing, which is much faster than inserting the objects one at a time and
+// produces a tree with less overlap between nodes.
+//
+// fillRatio, which must lie in (0, 1], sets the target occupancy of the leaf
+// nodes as a fraction of MaxChildren.  Fully packed leaves give the shortest
+// tree and the fastest queries, but each leaf will split on the next insert
+// that reaches it; a lower ratio makes the tree taller but leaves room for
+// future inserts without immediate splits.  Leaves are never packed below
+// MinChildren.
+//
+// Implemented per "STR: A Simple and Efficient Algorithm for R-Tree Packing"
+// by S. Leutenegger, M. Lopez and J. Edgington, ICDE, pages 497-506, 1997.
+func BulkLoad(MinChildren, MaxChildren int, fillRatio float64, objs []Spatial) (*Rtree, error) {
+	if !(fillRatio > 0 && fillRatio <= 1) {
+		return nil, fmt.Errorf("rtreego: fill ratio %v not in (0, 1]", fillRatio)
+	}
+	if err := checkBranching(MinChildren, MaxChildren); err != nil {
+		return nil, err
+	}
+	tree := NewTree(MinChildren, MaxChildren)
+	entries := make([]entry, len(objs))
+	for i, obj := range objs {
+		entries[i] = entry{bb: obj.Bounds(), obj: obj}
+	}
+	tree.load(entries, fillRatio)
+	return tree, nil
+}
+
+// BulkLoadChecked is like BulkLoad with full leaves, but first validates the
+// bounding box of every object.  Objects with missing bounds, NaN coordinates
+// or inverted dimensions are left out of the tree, and the returned slice
+// holds one error for each of them, identifying it by its index in objs.
+func BulkLoadChecked(MinChildren, MaxChildren int, objs []Spatial) (*Rtree, []error) {
+	var errs []error
+	entries := make([]entry, 0, len(objs))
+	for i, obj := range objs {
+		bb := obj.Bounds()
+		if bb == nil {
+			errs = append(errs, fmt.Errorf("rtreego: object %d: nil bounds", i))
+			continue
+		}
+		if err := bb.check(); err != nil {
+			errs = append(errs, fmt.Errorf("rtreego: object %d: %w", i, err))
+			continue
+		}
+		entries = append(entries, entry{bb: bb, obj: obj})
+	}
+	tree := NewTree(MinChildren, MaxChildren)
+	tree.load(entries, 1)
+	return tree, errs
+}
+
+// Transform returns a new tree, with the same branching factors, holding the
+// objects of tree with their bounding boxes mapped through fn, as after a
+// change of projection.  fn is passed a copy of each box.  The new tree is
+// built with BulkLoad from scratch.
+//
+// fn must return boxes with p[i] <= q[i] in every dimension and no NaN
+// coordinates; if it returns an invalid or nil box, Transform returns the
+// error and no tree.  The new tree indexes the objects by the mapped boxes,
+// so deleting an object from it requires its Bounds method to return the
+// mapped box.
+func (tree *Rtree) Transform(fn func(*Rect) *Rect) (*Rtree, error) {
+	entries := make([]entry, 0, tree.size)
+	var err error
+	tree.root.walk(func(e entry) bool {
+		bb := *e.bb
+		mapped := fn(&bb)
+		if mapped == nil {
+			err = fmt.Errorf("rtreego: nil transformed bounds for %v", e.obj)
+			return false
+		}
+		if err = mapped.check(); err != nil {
+			err = fmt.Errorf("rtreego: transformed bounds %v for %v: %w", mapped, e.obj, err)
+			return false
+		}
+		entries = append(entries, entry{bb: mapped, obj: e.obj})
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	transformed := NewTree(tree.MinChildren, tree.MaxChildren)
+	transformed.load(entries, 1)
+	return transformed, nil
+}
+
+// BulkLoadWeighted builds a new tree containing objs, like BulkLoad, but
+// packs the leaves with regard to how often each object is expected to be
+// queried, as given by weight.
+//
+// Each object is assigned a cost of one plus its weight divided by the
+// average weight, and the leaves are built by Sort-Tile-Recursive tiling in
+// which every tile receives roughly the same total cost rather than the same
+// number of objects, subject to holding between MinChildren and MaxChildren
+// of them.  Regions dense with heavy objects are thus cut into leaves with
+// fewer entries and tighter bounding boxes, which are quicker to reach and
+// scan, while light regions get fuller leaves; on average the leaves are
+// filled halfway between MinChildren and MaxChildren.  The levels above the
+// leaves are packed as by BulkLoad.  Negative weights count as zero.
+func BulkLoadWeighted(MinChildren, MaxChildren int, objs []Spatial, weight func(Spatial) float64) *Rtree {
+	tree := NewTree(MinChildren, MaxChildren)
+	if len(objs) <= MaxChildren {
+		entries := make([]entry, len(objs))
+		for i, obj := range objs {
+			entries[i] = entry{bb: obj.Bounds(), obj: obj}
+		}
+		tree.load(entries, 1)
+		return tree
+	}
+
+	items := make([]weightedEntry, len(objs))
+	total := 0.0
+	for i, obj := range objs {
+		items[i] = weightedEntry{entry: entry{bb: obj.Bounds(), obj: obj}, cost: math.Max(weight(obj), 0)}
+		total += items[i].cost
+	}
+	for i := range items {
+		if total > 0 {
+			items[i].cost /= total / float64(len(items))
+		}
+		items[i].cost++
+	}
+
+	// choose a number of leaves for which the size limits can be met
+	n, lo, hi := len(items), MinChildren, MaxChildren
+	if lo < 1 {
+		lo = 1
+	}
+	groups := int(math.Ceil(2 * float64(n) / float64(lo+hi)))
+	groups = max(min(groups, n/lo), (n+hi-1)/hi)
+	lo = min(lo, n/groups)
+
+	leaves := make([]entry, 0, groups)
+	for _, tile := range strTileWeighted(items, 0, groups, lo, hi, nil) {
+		group := make([]entry, len(tile))
+		for i, item := range tile {
+			group[i] = item.entry
+		}
+		leaf := tree.packNode(group, 1)
+		leaves = append(leaves, entry{bb: leaf.computeBoundingBox(), child: leaf})
+	}
+	tree.size = len(objs)
+	tree.loadLevels(leaves, 2, MaxChildren)
+	return tree
+}
+
+// weightedEntry is a leaf entry with a packing cost.
+type weightedEntry struct {
+	entry
+	cost float64
+}
+
+// strTileWeighted is like strTile, but splits the items so that each of the
+// groups receives about the same total cost, keeping the number of items in
+// each group between lo and hi.  The caller must ensure that the number of
+// items is between groups*lo and groups*hi.
+func strTileWeighted(items []weightedEntry, axis, groups, lo, hi int, tiles [][]weightedEntry) [][]weightedEntry {
+	if groups <= 1 {
+		return append(tiles, items)
+	}
+	sort.Slice(items, func(i, j int) bool {
+		bi, bj := items[i].bb, items[j].bb
+		return bi.p[axis]+bi.q[axis] < bj.p[axis]+bj.q[axis]
+	})
+
+	slices := groups
+	if axis < Dim-1 {
+		slices = int(math.Ceil(math.Pow(float64(groups), 1/float64(Dim-axis))))
+		if slices > groups {
+			slices = groups
+		}
+	}
+
+	total := 0.0
+	for _, item := range items {
+		total += item.cost
+	}
+
+	start, done, sum := 0, 0, 0.0
+	for i := 0; i < slices; i++ {
+		g := groups / slices
+		if i < groups%slices {
+			g++
+		}
+		// advance to the target cost, then respect the size limits of
+		// this slice and of the slices after it
+		target := total * float64(done+g) / float64(groups)
+		end, next := start, sum
+		for end < len(items) && next+items[end].cost/2 < target {
+			next += items[end].cost
+			end++
+		}
+		rest := groups - done - g
+		end = max(end, start+g*lo, len(items)-rest*hi)
+		end = min(end, start+g*hi, len(items)-rest*lo)
+		for _, item := range items[start:end] {
+			sum += item.cost
+		}
+
+		if axis < Dim-1 {
+			tiles = strTileWeighted(items[start:end], axis+1, g, lo, hi, tiles)
+		} else {
+			tiles = append(tiles, items[start:end])
+		}
+		start, done = end, done+g
+	}
+	return tiles
+}
+
+// InsertBatch inserts objs into the tree.  Instead of descending the tree
+// once for each object, it packs them with STR into subtrees and attaches
+// each subtree at the level where its leaves line up with those of the tree,
+// like RebuildRegion.  An empty tree is bulk-loaded outright.
+//
+// This is much faster than inserting the objects one at a time, and the
+// result holds the same objects, but the packed subtrees overlap the existing
+// nodes wherever the batch is spread among them.  The batches that benefit
+// most are those clustered in a part of the space, such as a day's new
+// records of a growing dataset.
+func (tree *Rtree) InsertBatch(objs []Spatial) {
+	entries := make([]entry, len(objs))
+	for i, obj := range objs {
+		entries[i] = entry{bb: obj.Bounds(), obj: obj}
+		if err := tree.checkUniverse(obj, entries[i].bb); err != nil {
+			panic(err)
+		}
+	}
+	for _, obj := range objs {
+		tree.number(obj)
+	}
+	tree.Compact()
+	tree.reinserted = 0
+	if tree.size == 0 {
+		tree.load(entries, 1)
+	} else {
+		tree.insertPacked(entries)
+	}
+	for range entries {
+		tree.changed()
+	}
+}
+
+// InsertAll inserts the objects received from ch until it is closed, and
+// returns once it has been drained.  The objects received by an empty tree
+// are buffered and bulk-loaded together when ch is closed, giving a better
+// tree much faster than inserting them one at a time; in a tree that already
+// holds objects, each is inserted as it arrives, since packing unrelated
+// objects into batches as InsertBatch does would leave overlapping nodes.
+// Either way the objects are all in the tree when InsertAll returns; an
+// empty tree holds none of them before then.
+func (tree *Rtree) InsertAll(ch <-chan Spatial) {
+	if tree.size > 0 {
+		for obj := range ch {
+			tree.Insert(obj)
+		}
+		return
+	}
+	var objs []Spatial
+	for obj := range ch {
+		objs = append(objs, obj)
+	}
+	tree.InsertBatch(objs)
+}
+
+// Merge adds every object stored in other to tree, leaving other holding
+// the same objects; any deletions pending in either tree in lazy mode are
+// condensed first.
+//
+// If the trees have the same MinChildren and MaxChildren, the nodes of
+// other are copied and attached whole, like the subtrees of InsertBatch, at
+// the level where their leaves line up with those of tree; if other is the
+// taller tree, the copy of it becomes the base that the nodes of tree are
+// attached to.  This touches only the nodes near the top of each tree, not
+// every object.  Otherwise each object of other is inserted separately.
+//
+// If tree was created WithUniverse, Merge panics without changing either
+// tree unless every object of other lies within the universe.
+func (tree *Rtree) Merge(other *Rtree) {
+	if other.size > 0 && tree.universe != nil && !tree.universe.ContainsRect(other.root.computeBoundingBox()) {
+		other.root.walk(func(e entry) bool {
+			if err := tree.checkUniverse(e.obj, e.bb); err != nil {
+				panic(err)
+			}
+			return true
+		})
+	}
+	tree.Compact()
+	other.Compact()
+	tree.reinserted = 0
+	walk := other.Walk
+	if other.seqs != nil {
+		walk = func(fn func(obj Spatial) bool) {
+			other.WalkOrdered(func(_ uint64, obj Spatial) bool { return fn(obj) })
+		}
+	}
+	if tree.MinChildren != other.MinChildren || tree.MaxChildren != other.MaxChildren {
+		walk(func(obj Spatial) bool {
+			tree.Insert(obj)
+			return true
+		})
+		return
+	}
+	if other.size == 0 {
+		return
+	}
+	walk(func(obj Spatial) bool {
+		tree.number(obj)
+		return true
+	})
+
+	n := other.root.deepCopy(nil, tree)
+	if other.height > tree.height {
+		tree.root, n = n, tree.root
+		tree.height = other.height
+		n.parent = nil
+	}
+	tree.insertNode(n)
+	tree.size += other.size
+	for range other.size {
+		tree.changed()
+	}
+}
+
+// Optimize rebuilds tree from its objects with the packing of BulkLoad, with
+// full leaves, keeping its branching factors and options.  This undoes the
+// overlap between nodes that builds up under long sequences of insertions
+// and deletions, without changing the objects stored or the results of
+// searches other than their order.  The new shape depends only on the
+// objects, not on the history of the tree.  It visits every object; see
+// WithAutoOptimize to have it called when needed.
+func (tree *Rtree) Optimize() {
+	entries := make([]entry, 0, tree.size)
+	tree.root.walk(func(e entry) bool {
+		entries = append(entries, e)
+		return true
+	})
+	tree.dirty = nil
+	tree.lastSplit = 0
+	tree.changes = 0
+	if tree.moved != nil {
+		for _, e := range entries {
+			*tree.moved = append(*tree.moved, e.obj)
+		}
+	}
+	if len(entries) == 0 {
+		tree.Clear()
+		return
+	}
+	// packing canonically ordered entries gives the same shape for the same
+	// objects, however they were inserted
+	sortCanonical(entries)
+	tree.load(entries, 1)
+}
+
+// autoOptimizeInterval is the smallest number of insertions and deletions
+// after which a tree created with WithAutoOptimize measures itself again.
+const autoOptimizeInterval = 256
+
+// changed records an insertion or deletion, and rebuilds a tree created with
+// WithAutoOptimize if it has become too degraded.  The Overlap is measured
+// after as many changes as the tree holds objects, so that the cost of
+// measuring it, and of rebuilding, is amortized over them.
+func (tree *Rtree) changed() {
+	if tree.optimizeAbove == 0 {
+		return
+	}
+	tree.changes++
+	if tree.changes < max(tree.size, autoOptimizeInterval) {
+		return
+	}
+	tree.changes = 0
+	if tree.Stats().Overlap > tree.optimizeAbove {
+		tree.Optimize()
+	}
+}
+
+// insertPacked adds leaf entries to the tree by packing them into subtrees
+// with STR and attaching each subtree at its own level, so that the leaves
+// stay at the same depth.  Subtrees that are as tall as the tree itself, or
+// whose roots are underfull, are broken up and their children attached
+// instead.
+func (tree *Rtree) insertPacked(entries []entry) {
+	if len(entries) == 0 {
+		return
+	}
+	tree.Compact()
+	sub := &Rtree{MinChildren: tree.MinChildren, MaxChildren: tree.MaxChildren}
+	sub.load(entries, 1)
+	tree.insertNode(sub.root)
+	tree.size += len(entries)
+}
+
+// insertNode attaches the subtree rooted at n to the tree, or inserts its
+// children separately if it can't be attached whole.  It doesn't update the
+// size of the tree.
+func (tree *Rtree) insertNode(n *node) {
+	fits := len(n.entries) > 0 && len(n.entries) >= tree.MinChildren && len(n.entries) <= tree.MaxChildren
+	if fits && n.level < tree.height {
+		tree.insert(entry{bb: n.computeBoundingBox(), child: n}, n.level+1)
+		return
+	}
+	for _, e := range n.entries {
+		if n.leaf {
+			tree.insert(e, 1)
+		} else {
+			tree.insertNode(e.child)
+		}
+	}
+}
+
+// load replaces the contents of tree with a packed tree holding the leaf
+// entries, filling leaves to fillRatio of MaxChildren.
+func (tree *Rtree) load(entries []entry, fillRatio float64) {
+	capacity := int(math.Ceil(fillRatio * float64(tree.MaxChildren)))
+	if capacity < tree.MinChildren {
+		capacity = tree.MinChildren
+	}
+	if capacity > tree.MaxChildren {
+		capacity = tree.MaxChildren
+	}
+
+	tree.size = len(entries)
+	tree.loadLevels(entries, 1, capacity)
+}
+
+// loadLevels packs entries at the specified level, filling nodes to capacity
+// at that level and fully above it, and makes the result the root of tree.
+func (tree *Rtree) loadLevels(entries []entry, level, capacity int) {
+	for len(entries) > tree.MaxChildren {
+		groups := tree.packGroups(entries, capacity)
+		parents := make([]entry, len(groups))
+		for i, group := range groups {
+			n := tree.packNode(group, level)
+			parents[i] = entry{bb: n.computeBoundingBox(), child: n}
+		}
+		entries = parents
+		level++
+		// only the first level is filled to the requested capacity
+		capacity = tree.MaxChildren
+	}
+
+	tree.root = tree.packNode(entries, level)
+	tree.height = level
+}
+
+// packNode creates a node at the specified level holding a copy of entries.
+func (tree *Rtree) packNode(entries []entry, level int) *node {
+	n := &node{
+		leaf:    level == 1,
+		level:   level,
+		entries: make([]entry, len(entries), tree.MaxChildren+1),
+	}
+	copy(n.entries, entries)
+	for _, e := range n.entries {
+		if e.child != nil {
+			e.child.parent = n
+		}
+	}
+	return n
+}
+
+// packGroups partitions entries into spatially clustered groups of at most
+// capacity entries.  The groups are as even in size as possible, and there
+// are never so many that they would hold fewer than MinChildren entries; the
+// entries left over are then spread among them, which may fill them beyond
+// capacity but never beyond MaxChildren.
+func (tree *Rtree) packGroups(entries []entry, capacity int) [][]entry {
+	groups := (len(entries) + capacity - 1) / capacity
+	groups = max(1, min(groups, len(entries)/tree.MinChildren))
+	return strTile(entries, 0, groups, nil)
+}
+
+// strTile sorts entries into vertical slices along axis, recursively tiles
+// each slice along the remaining axes, and appends the resulting groups to
+// tiles.
+func strTile(entries []entry, axis, groups int, tiles [][]entry) [][]entry {
+	if groups <= 1 {
+		return append(tiles, entries)
+	}
+	sort.Sort(entriesByCenter{entries, axis})
+
+	slices := groups
+	if axis < Dim-1 {
+		slices = int(math.Ceil(math.Pow(float64(groups), 1/float64(Dim-axis))))
+		if slices > groups {
+			slices = groups
+		}
+	}
+
+	start, done := 0, 0
+	for i := 0; i < slices; i++ {
+		g := groups / slices
+		if i < groups%slices {
+			g++
+		}
+		end := len(entries) * (done + g) / groups
+		if axis < Dim-1 {
+			tiles = strTile(entries[start:end], axis+1, g, tiles)
+		} else {
+			tiles = append(tiles, entries[start:end])
+		}
+		start, done = end, done+g
+	}
+	return tiles
+}
+
+// entriesByCenter sorts entries by the center of their bounding boxes along
+// a single axis.
+type entriesByCenter struct {
+	entries []entry
+	axis    int
+}
+
+func (s entriesByCenter) Len() int { return len(s.entries) }
+
+func (s entriesByCenter) Swap(i, j int) {
+	s.entries[i], s.entries[j] = s.entries[j], s.entries[i]
+}
+
+func (s entriesByCenter) Less(i, j int) bool {
+	bi, bj := s.entries[i].bb, s.entries[j].bb
+	return bi.p[s.axis]+bi.q[s.axis] < bj.p[s.axis]+bj.q[s.axis]
+}
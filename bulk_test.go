@@ -0,0 +1,699 @@
+// Copyright 2012 Daniel Connelly.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rtreego
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func randomRects(n int, seed int64) []Spatial {
+	rnd := rand.New(rand.NewSource(seed))
+	objs := make([]Spatial, n)
+	for i := range objs {
+		p := Point{rnd.Float64() * 100, rnd.Float64() * 100, rnd.Float64() * 100}
+		objs[i] = mustRect(p, [Dim]float64{1 + rnd.Float64()*5, 1 + rnd.Float64()*5, 1 + rnd.Float64()*5})
+	}
+	return objs
+}
+
+func leafSizes(n *node) []int {
+	if n.leaf {
+		return []int{len(n.entries)}
+	}
+	var sizes []int
+	for _, e := range n.entries {
+		sizes = append(sizes, leafSizes(e.child)...)
+	}
+	return sizes
+}
+
+func TestBulkLoad(t *testing.T) {
+	objs := randomRects(500, 1)
+	rt, err := BulkLoad(5, 10, 1, objs)
+	if err != nil {
+		t.Fatalf("BulkLoad failed: %v", err)
+	}
+	verify(t, rt.root)
+	if rt.Size() != len(objs) {
+		t.Errorf("BulkLoad tree has size %d; expected %d", rt.Size(), len(objs))
+	}
+	if rt.Depth() != 3 {
+		t.Errorf("BulkLoad tree has depth %d; expected 3", rt.Depth())
+	}
+	for _, size := range leafSizes(rt.root) {
+		if size < 5 || size > 10 {
+			t.Errorf("BulkLoad produced a leaf with %d entries", size)
+		}
+	}
+
+	inserted := NewTree(5, 10)
+	for _, obj := range objs {
+		inserted.Insert(obj)
+	}
+	bb := mustRect(Point{20, 30, 10}, [Dim]float64{30, 20, 50})
+	expected := inserted.SearchIntersect(bb)
+	q := rt.SearchIntersect(bb)
+	if len(q) != len(expected) {
+		t.Errorf("SearchIntersect on a bulk loaded tree found %d objects; expected %d", len(q), len(expected))
+	}
+	for _, obj := range expected {
+		if indexOf(q, obj) < 0 {
+			t.Errorf("SearchIntersect on a bulk loaded tree failed to find %v", obj)
+		}
+	}
+
+	for i, obj := range objs {
+		if !rt.Delete(obj) {
+			t.Fatalf("failed to delete %v from a bulk loaded tree", obj)
+		}
+		if rt.Size() != len(objs)-i-1 {
+			t.Fatalf("Delete left size %d; expected %d", rt.Size(), len(objs)-i-1)
+		}
+	}
+}
+
+func TestBulkLoadFillRatio(t *testing.T) {
+	objs := randomRects(500, 2)
+	rt, err := BulkLoad(2, 10, 0.7, objs)
+	if err != nil {
+		t.Fatalf("BulkLoad failed: %v", err)
+	}
+	verify(t, rt.root)
+	for _, size := range leafSizes(rt.root) {
+		if size > 7 {
+			t.Errorf("BulkLoad with fill ratio 0.7 produced a leaf with %d entries", size)
+		}
+	}
+
+	for _, obj := range randomRects(100, 3) {
+		rt.Insert(obj)
+	}
+	verify(t, rt.root)
+	if rt.Size() != 600 {
+		t.Errorf("tree has size %d after inserts; expected 600", rt.Size())
+	}
+}
+
+func TestBulkLoadMinChildren(t *testing.T) {
+	objs := randomRects(300, 5)
+	for _, params := range []struct {
+		min, max  int
+		fillRatio float64
+	}{{5, 10, 0.5}, {4, 10, 0.5}, {3, 6, 0.3}, {4, 8, 0.6}} {
+		for n := 0; n <= len(objs); n++ {
+			rt, err := BulkLoad(params.min, params.max, params.fillRatio, objs[:n])
+			if err != nil {
+				t.Fatalf("BulkLoad failed: %v", err)
+			}
+			if err := rt.Validate(); err != nil {
+				t.Fatalf("BulkLoad(%d, %d, %v) of %d objects is invalid: %v", params.min, params.max, params.fillRatio, n, err)
+			}
+		}
+	}
+}
+
+func TestBulkLoadSmall(t *testing.T) {
+	rt, err := BulkLoad(3, 5, 1, randomRects(4, 4))
+	if err != nil {
+		t.Fatalf("BulkLoad failed: %v", err)
+	}
+	if !rt.root.leaf || len(rt.root.entries) != 4 || rt.Depth() != 1 {
+		t.Errorf("BulkLoad failed to store a small input in the root")
+	}
+
+	rt, err = BulkLoad(3, 5, 1, nil)
+	if err != nil || rt.Size() != 0 {
+		t.Errorf("BulkLoad of no objects = %v, %v; expected an empty tree", rt, err)
+	}
+}
+
+func TestBulkLoadInvalidFillRatio(t *testing.T) {
+	for _, ratio := range []float64{0, -0.5, 1.5} {
+		if _, err := BulkLoad(3, 5, ratio, randomRects(10, 5)); err == nil {
+			t.Errorf("BulkLoad accepted fill ratio %v", ratio)
+		}
+	}
+}
+
+type nilBounds struct{}
+
+func (nilBounds) Bounds() *Rect { return nil }
+
+func TestBulkLoadChecked(t *testing.T) {
+	objs := randomRects(100, 6)
+	objs[10] = &Rect{Point{0, 0, 0}, Point{1, -1, 1}}
+	objs[20] = &Rect{Point{0, 0, math.NaN()}, Point{1, 1, 1}}
+	objs[30] = nilBounds{}
+
+	rt, errs := BulkLoadChecked(3, 6, objs)
+	if len(errs) != 3 {
+		t.Fatalf("BulkLoadChecked returned errors %v; expected 3", errs)
+	}
+	for i, idx := range []string{"10", "20", "30"} {
+		if !strings.Contains(errs[i].Error(), "object "+idx+":") {
+			t.Errorf("error %q does not identify object %s", errs[i], idx)
+		}
+	}
+	if _, ok := errors.Unwrap(errs[0]).(DistError); !ok {
+		t.Errorf("error %v does not wrap a DistError", errs[0])
+	}
+	if !errors.Is(errs[0], ErrZeroLength) || !errors.Is(errs[1], ErrNaNCoordinate) {
+		t.Errorf("errors %v do not match ErrZeroLength and ErrNaNCoordinate", errs[:2])
+	}
+
+	verify(t, rt.root)
+	if rt.Size() != 97 {
+		t.Errorf("BulkLoadChecked tree has size %d; expected 97", rt.Size())
+	}
+	for i, obj := range objs {
+		if i == 10 || i == 20 || i == 30 {
+			continue
+		}
+		if rt.findLeaf(rt.root, obj, defaultComparator) == nil {
+			t.Errorf("BulkLoadChecked failed to index valid object %d", i)
+		}
+	}
+}
+
+// hotRects returns n random rects and a weight function that makes those in
+// a corner of the space a hundred times heavier than the rest.
+func hotRects(n int, seed int64) ([]Spatial, func(Spatial) float64) {
+	hot := mustRect(Point{0, 0, 0}, [Dim]float64{25, 25, 25})
+	weight := func(obj Spatial) float64 {
+		if hot.containsRect(obj.Bounds()) {
+			return 100
+		}
+		return 1
+	}
+	return randomRects(n, seed), weight
+}
+
+func TestBulkLoadWeighted(t *testing.T) {
+	objs, weight := hotRects(2000, 14)
+	rt := BulkLoadWeighted(4, 16, objs, weight)
+	verify(t, rt.root)
+	if rt.Size() != len(objs) {
+		t.Errorf("BulkLoadWeighted tree has size %d; expected %d", rt.Size(), len(objs))
+	}
+	for i, obj := range objs {
+		if rt.findLeaf(rt.root, obj, defaultComparator) == nil {
+			t.Errorf("BulkLoadWeighted failed to index object %d", i)
+		}
+	}
+
+	var hotEntries, hotLeaves, coldEntries, coldLeaves int
+	rt.root.walkNodes(func(n *node) {
+		if !n.leaf {
+			return
+		}
+		if len(n.entries) < 4 || len(n.entries) > 16 {
+			t.Errorf("BulkLoadWeighted produced a leaf with %d entries", len(n.entries))
+		}
+		if weight(n.entries[0].obj) > 1 {
+			hotEntries, hotLeaves = hotEntries+len(n.entries), hotLeaves+1
+		} else {
+			coldEntries, coldLeaves = coldEntries+len(n.entries), coldLeaves+1
+		}
+	})
+	if hotLeaves == 0 || hotEntries*coldLeaves >= coldEntries*hotLeaves {
+		t.Errorf("hot leaves hold %d/%d entries and cold leaves %d/%d; expected fewer per hot leaf",
+			hotEntries, hotLeaves, coldEntries, coldLeaves)
+	}
+
+	small := BulkLoadWeighted(4, 16, objs[:10], weight)
+	if !small.root.leaf || small.Size() != 10 {
+		t.Errorf("BulkLoadWeighted failed to store a small input in the root")
+	}
+}
+
+func BenchmarkBulkLoadWeightedHotQueries(b *testing.B) {
+	objs, weight := hotRects(20000, 15)
+	var queries []*Rect
+	for _, obj := range objs {
+		if weight(obj) > 1 {
+			queries = append(queries, obj.Bounds())
+		}
+	}
+	plain, err := BulkLoad(4, 16, 1, objs)
+	if err != nil {
+		b.Fatal(err)
+	}
+	trees := []struct {
+		name string
+		tree *Rtree
+	}{
+		{"BulkLoad", plain},
+		{"BulkLoadWeighted", BulkLoadWeighted(4, 16, objs, weight)},
+	}
+	for _, tc := range trees {
+		b.Run(tc.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				tc.tree.SearchIntersect(queries[i%len(queries)])
+			}
+		})
+	}
+}
+
+// movable is an object whose bounds can be changed after insertion.
+type movable struct {
+	bb *Rect
+}
+
+func (m *movable) Bounds() *Rect { return m.bb }
+
+func TestTransform(t *testing.T) {
+	rt := NewTree(3, 6)
+	var objs []*movable
+	for _, obj := range randomRects(200, 20) {
+		m := &movable{obj.Bounds()}
+		objs = append(objs, m)
+		rt.Insert(m)
+	}
+	shift := func(r *Rect) *Rect {
+		for i := range r.p {
+			r.p[i] = 2*r.p[i] + 1000
+			r.q[i] = 2*r.q[i] + 1000
+		}
+		return r
+	}
+
+	moved, err := rt.Transform(shift)
+	if err != nil {
+		t.Fatalf("Transform failed: %v", err)
+	}
+	verify(t, moved.root)
+	if moved.Size() != rt.Size() {
+		t.Errorf("Transform produced size %d; expected %d", moved.Size(), rt.Size())
+	}
+	bb := mustRect(Point{20, 20, 20}, [Dim]float64{40, 40, 40})
+	mappedBB := shift(mustRect(Point{20, 20, 20}, [Dim]float64{40, 40, 40}))
+	expected := rt.SearchIntersect(bb)
+	q := moved.SearchIntersect(mappedBB)
+	if len(q) != len(expected) {
+		t.Errorf("SearchIntersect on the transformed tree found %d objects; expected %d", len(q), len(expected))
+	}
+	for _, obj := range expected {
+		if indexOf(q, obj) < 0 {
+			t.Errorf("SearchIntersect on the transformed tree failed to find %v", obj)
+		}
+	}
+	if len(rt.SearchIntersect(bb)) != len(expected) {
+		t.Errorf("Transform modified the original tree")
+	}
+
+	for _, m := range objs {
+		bb := *m.bb
+		m.bb = shift(&bb)
+	}
+	for _, m := range objs {
+		if !moved.Delete(m) {
+			t.Fatalf("failed to delete %v from the transformed tree", m.bb)
+		}
+	}
+
+	invert := func(r *Rect) *Rect {
+		r.p[0], r.q[0] = r.q[0], r.p[0]
+		return r
+	}
+	if tr, err := rt.Transform(invert); tr != nil || !errors.Is(err, ErrZeroLength) {
+		t.Errorf("Transform with an inverting function = %v, %v; expected ErrZeroLength", tr, err)
+	}
+	if _, err := rt.Transform(func(*Rect) *Rect { return nil }); err == nil {
+		t.Errorf("Transform accepted nil bounds")
+	}
+}
+
+func TestUpdateAll(t *testing.T) {
+	rnd := rand.New(rand.NewSource(26))
+	rt := NewTree(3, 6)
+	var objs []*movable
+	for _, obj := range randomRects(300, 27) {
+		m := &movable{obj.Bounds()}
+		objs = append(objs, m)
+		rt.Insert(m)
+	}
+
+	var updates []UpdateOp
+	for i, m := range objs {
+		if i%3 != 0 {
+			continue
+		}
+		updates = append(updates, UpdateOp{m, m.bb})
+		m.bb = mustRect(Point{rnd.Float64() * 100, rnd.Float64() * 100, rnd.Float64() * 100}, [Dim]float64{1, 2, 3})
+	}
+	stray := &movable{mustRect(Point{1, 1, 1}, [Dim]float64{1, 1, 1})}
+	updates = append(updates, UpdateOp{stray, stray.bb}, UpdateOp{objs[1], objs[2].bb})
+
+	if moved := rt.UpdateAll(updates); moved != 100 {
+		t.Errorf("UpdateAll moved %d objects; expected 100", moved)
+	}
+	verify(t, rt.root)
+	verifyTight(t, rt.root)
+	if rt.Size() != len(objs) {
+		t.Errorf("UpdateAll left size %d; expected %d", rt.Size(), len(objs))
+	}
+	for i, m := range objs {
+		if !rt.Contains(m, nil) {
+			t.Errorf("object %d not found at its current bounds", i)
+		}
+	}
+	bb := mustRect(Point{30, 30, 30}, [Dim]float64{40, 40, 40})
+	q := rt.SearchIntersect(bb)
+	expected := 0
+	for _, m := range objs {
+		if intersect(m.bb, bb) {
+			expected++
+		}
+	}
+	if len(q) != expected {
+		t.Errorf("SearchIntersect after UpdateAll found %d objects; expected %d", len(q), expected)
+	}
+
+	// Comparable objects are matched by Equal
+	rt = NewTree(3, 6)
+	var comparables []tagged
+	for i, obj := range randomRects(50, 28) {
+		c := tagged{obj.Bounds(), []string{fmt.Sprint(i)}}
+		comparables = append(comparables, c)
+		rt.Insert(c)
+	}
+	moved := tagged{mustRect(Point{300, 300, 300}, [Dim]float64{1, 1, 1}), []string{"7"}}
+	if n := rt.UpdateAll([]UpdateOp{{moved, comparables[7].bb}}); n != 1 {
+		t.Errorf("UpdateAll moved %d Comparable objects; expected 1", n)
+	}
+	if rt.Size() != len(comparables) || len(rt.SearchIntersect(moved.bb)) != 1 || rt.Contains(comparables[7], nil) {
+		t.Errorf("UpdateAll didn't move the Comparable object")
+	}
+
+	// the moves count towards automatic optimization
+	rt = NewTree(3, 6, WithAutoOptimize(1e9))
+	for _, m := range objs {
+		rt.Insert(m)
+	}
+	before := rt.changes
+	old := objs[0].bb
+	objs[0].bb = mustRect(Point{1, 1, 1}, [Dim]float64{1, 1, 1})
+	rt.UpdateAll([]UpdateOp{{objs[0], old}})
+	if rt.changes != before+1 {
+		t.Errorf("UpdateAll counted %d changes; expected 1", rt.changes-before)
+	}
+
+	// a new box outside the universe is rejected before anything changes
+	rt = NewTree(3, 6, WithUniverse(mustRect(Point{0, 0, 0}, [Dim]float64{200, 200, 200})))
+	for _, m := range objs {
+		rt.Insert(m)
+	}
+	inBounds, outOfBounds := objs[1], objs[2]
+	updates = []UpdateOp{{inBounds, inBounds.bb}, {outOfBounds, outOfBounds.bb}}
+	inBounds.bb = mustRect(Point{5, 5, 5}, [Dim]float64{1, 1, 1})
+	outOfBounds.bb = mustRect(Point{500, 5, 5}, [Dim]float64{1, 1, 1})
+	func() {
+		defer func() {
+			if err, _ := recover().(error); !errors.Is(err, ErrOutsideUniverse) {
+				t.Errorf("UpdateAll outside the universe panicked with %v; expected ErrOutsideUniverse", err)
+			}
+		}()
+		rt.UpdateAll(updates)
+	}()
+	if rt.Size() != len(objs) || rt.Contains(inBounds, nil) {
+		t.Errorf("UpdateAll changed the tree before rejecting a box outside the universe")
+	}
+}
+
+func TestInsertBatch(t *testing.T) {
+	objs := randomRects(2000, 47)
+	rt := NewTree(3, 8)
+	rt.InsertBatch(objs[:500])
+	verify(t, rt.root)
+	// a clustered batch, and one spread over the whole tree
+	var clustered []Spatial
+	for _, obj := range objs[500:] {
+		if obj.Bounds().p[0] < 30 {
+			clustered = append(clustered, obj)
+		}
+	}
+	rt.InsertBatch(clustered)
+	verify(t, rt.root)
+	verifyTight(t, rt.root)
+	var rest []Spatial
+	for _, obj := range objs[500:] {
+		if obj.Bounds().p[0] >= 30 {
+			rest = append(rest, obj)
+		}
+	}
+	rt.InsertBatch(rest)
+	rt.InsertBatch(nil)
+	verify(t, rt.root)
+	verifyTight(t, rt.root)
+
+	if rt.Size() != len(objs) {
+		t.Errorf("tree has size %d; expected %d", rt.Size(), len(objs))
+	}
+	for _, obj := range objs {
+		if !rt.Contains(obj, nil) {
+			t.Errorf("InsertBatch failed to insert %v", obj)
+		}
+	}
+	bb := mustRect(Point{20, 20, 20}, [Dim]float64{40, 40, 40})
+	expected := 0
+	for _, obj := range objs {
+		if intersect(obj.Bounds(), bb) {
+			expected++
+		}
+	}
+	if q := rt.SearchIntersect(bb); len(q) != expected {
+		t.Errorf("SearchIntersect found %d objects; expected %d", len(q), expected)
+	}
+}
+
+func TestInsertAll(t *testing.T) {
+	objs := randomRects(1000, 48)
+	feed := func(objs []Spatial) <-chan Spatial {
+		ch := make(chan Spatial)
+		go func() {
+			for _, obj := range objs {
+				ch <- obj
+			}
+			close(ch)
+		}()
+		return ch
+	}
+
+	rt := NewTree(3, 8)
+	rt.InsertAll(feed(objs[:600]))
+	if err := rt.Validate(); err != nil {
+		t.Errorf("tree invalid after draining into an empty tree: %v", err)
+	}
+	rt.InsertAll(feed(objs[600:]))
+	if err := rt.Validate(); err != nil {
+		t.Errorf("tree invalid after draining into a full tree: %v", err)
+	}
+	if rt.Size() != len(objs) {
+		t.Errorf("tree has size %d; expected %d", rt.Size(), len(objs))
+	}
+	for _, obj := range objs {
+		if !rt.Contains(obj, nil) {
+			t.Errorf("InsertAll lost %v", obj)
+		}
+	}
+
+	ch := make(chan Spatial)
+	close(ch)
+	rt = NewTree(3, 8)
+	rt.InsertAll(ch)
+	if rt.Size() != 0 {
+		t.Errorf("InsertAll of a closed channel left size %d", rt.Size())
+	}
+}
+
+func BenchmarkInsertBatch(b *testing.B) {
+	objs := randomRects(50000, 48)
+	for _, batch := range []bool{false, true} {
+		name := "OneByOne"
+		if batch {
+			name = "Batch"
+		}
+		b.Run(name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				rt, _ := BulkLoad(25, 50, 1, objs[:25000])
+				if batch {
+					rt.InsertBatch(objs[25000:])
+					continue
+				}
+				for _, obj := range objs[25000:] {
+					rt.Insert(obj)
+				}
+			}
+		})
+	}
+}
+
+func TestMerge(t *testing.T) {
+	objs := randomRects(1200, 53)
+	for _, tt := range []struct {
+		name        string
+		left, right int // the numbers of objects in each tree
+		min, max    int // the branching factors of other
+	}{
+		{"even", 600, 600, 3, 8},
+		{"taller other", 20, 1000, 3, 8},
+		{"shorter other", 1000, 20, 3, 8},
+		{"empty other", 500, 0, 3, 8},
+		{"empty tree", 0, 500, 3, 8},
+		{"different parameters", 400, 400, 2, 5},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			left, right := objs[:tt.left], objs[tt.left:tt.left+tt.right]
+			rt, other := NewTree(3, 8), NewTree(tt.min, tt.max)
+			for _, obj := range left {
+				rt.Insert(obj)
+			}
+			for _, obj := range right {
+				other.Insert(obj)
+			}
+
+			rt.Merge(other)
+			verify(t, rt.root)
+			verify(t, other.root)
+			if rt.Size() != len(left)+len(right) || other.Size() != len(right) {
+				t.Errorf("merged tree has size %d and other %d; expected %d and %d", rt.Size(), other.Size(), len(left)+len(right), len(right))
+			}
+			for _, obj := range objs[:tt.left+tt.right] {
+				if !rt.Contains(obj, nil) {
+					t.Errorf("merged tree is missing %v", obj)
+				}
+			}
+
+			// the trees share no nodes
+			for _, obj := range right {
+				other.Delete(obj)
+			}
+			verify(t, rt.root)
+			if rt.Size() != len(left)+len(right) || len(rt.All()) != rt.Size() {
+				t.Errorf("emptying other changed the merged tree")
+			}
+		})
+	}
+}
+
+func TestOptimize(t *testing.T) {
+	objs := randomRects(1000, 47)
+	rt := NewTree(3, 8)
+	for _, obj := range objs {
+		rt.Insert(obj)
+	}
+	for _, obj := range objs[:600] {
+		rt.Delete(obj)
+	}
+	for _, obj := range objs[:300] {
+		rt.Insert(obj)
+	}
+	windows := []*Rect{
+		mustRect(Point{0, 0, 0}, [Dim]float64{30, 30, 30}),
+		mustRect(Point{40, 10, 60}, [Dim]float64{50, 20, 20}),
+	}
+	var before [][]Spatial
+	for _, bb := range windows {
+		before = append(before, rt.SearchIntersect(bb))
+	}
+	overlap, size := rt.Stats().Overlap, rt.Size()
+
+	rt.Optimize()
+	if err := rt.Validate(); err != nil {
+		t.Fatalf("optimized tree is invalid: %v", err)
+	}
+	if rt.Size() != size {
+		t.Errorf("optimized tree has size %d; expected %d", rt.Size(), size)
+	}
+	if got := rt.Stats().Overlap; got >= overlap {
+		t.Errorf("optimized tree has overlap %v; expected less than %v", got, overlap)
+	}
+	for i, bb := range windows {
+		after := rt.SearchIntersect(bb)
+		if len(after) != len(before[i]) {
+			t.Errorf("SearchIntersect(%v) found %d objects after Optimize; expected %d", bb, len(after), len(before[i]))
+		}
+		for _, obj := range before[i] {
+			if indexOf(after, obj) < 0 {
+				t.Errorf("SearchIntersect(%v) no longer finds %v", bb, obj)
+			}
+		}
+	}
+
+	empty := NewTree(3, 8)
+	empty.Optimize()
+	if err := empty.Validate(); err != nil || empty.Size() != 0 {
+		t.Errorf("optimized empty tree has size %d and error %v", empty.Size(), err)
+	}
+}
+
+func TestAutoOptimize(t *testing.T) {
+	objs := randomRects(2000, 48)
+	optimized := NewTree(3, 8, WithAutoOptimize(1e-9))
+	plain := NewTree(3, 8, WithAutoOptimize(1e9))
+	rebuilt := false
+	for _, obj := range objs {
+		plain.Insert(obj)
+		optimized.Insert(obj)
+		if optimized.changes == 0 {
+			// the tree has just measured itself and been rebuilt
+			rebuilt = true
+			if fill := optimized.Stats().Fill[0]; fill < 0.95 {
+				t.Errorf("automatically optimized tree has leaves %v full; expected packed leaves", fill)
+			}
+		}
+	}
+	if !rebuilt {
+		t.Errorf("tree with a tiny threshold was never optimized")
+	}
+	if err := optimized.Validate(); err != nil {
+		t.Errorf("automatically optimized tree is invalid: %v", err)
+	}
+	if fill := plain.Stats().Fill[0]; fill > 0.9 {
+		t.Errorf("tree with a huge threshold has leaves %v full; expected it not to be rebuilt", fill)
+	}
+	if optimized.Size() != len(objs) || len(optimized.All()) != len(objs) {
+		t.Errorf("automatically optimized tree has size %d; expected %d", optimized.Size(), len(objs))
+	}
+
+	// every mutator counts the objects it changes
+	counted := NewTree(3, 8, WithAutoOptimize(1e9))
+	steps := []struct {
+		name    string
+		mutate  func()
+		changes int
+	}{
+		{"InsertBatch", func() { counted.InsertBatch(objs[:50]) }, 50},
+		{"InsertBatch", func() { counted.InsertBatch(objs[50:80]) }, 30},
+		{"Merge", func() {
+			other := NewTree(3, 8)
+			other.InsertBatch(objs[80:100])
+			counted.Merge(other)
+		}, 20},
+		{"DeleteWithFunc", func() {
+			counted.DeleteWithFunc(func(obj Spatial) bool { return indexOf(objs[:10], obj) >= 0 })
+		}, 10},
+		{"Update", func() { counted.Update(objs[10], mustRect(Point{1, 1, 1}, [Dim]float64{1, 1, 1})) }, 1},
+	}
+	for _, step := range steps {
+		before := counted.changes
+		step.mutate()
+		if got := counted.changes - before; got != step.changes {
+			t.Errorf("%s counted %d changes; expected %d", step.name, got, step.changes)
+		}
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("WithAutoOptimize(0) did not panic")
+		}
+	}()
+	WithAutoOptimize(0)
+}
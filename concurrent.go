@@ -0,0 +1,78 @@
+// Copyright 2012 Daniel Connelly.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rtreego
+
+import "sync"
+
+// ConcurrentRtree is an R-tree that is safe for concurrent use.  Queries
+// share a read lock, so any number of them can run at once, while Insert and
+// Delete take the write lock and wait for the queries in progress.
+//
+// The Bounds method of every object in the tree, and of every object passed
+// to it, is called while a lock is held; it must not change its result while
+// the object is in the tree, nor call back into the tree.
+type ConcurrentRtree struct {
+	mu   sync.RWMutex
+	tree *Rtree
+}
+
+var _ SpatialIndex = (*ConcurrentRtree)(nil)
+
+// NewConcurrentTree creates a new concurrency-safe R-tree with the specified
+// minimum and maximum branching factors and options.
+func NewConcurrentTree(MinChildren, MaxChildren int, opts ...Option) *ConcurrentRtree {
+	return &ConcurrentRtree{tree: NewTree(MinChildren, MaxChildren, opts...)}
+}
+
+// Insert inserts a spatial object into the tree.
+func (t *ConcurrentRtree) Insert(obj Spatial) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.tree.Insert(obj)
+}
+
+// Delete removes an object from the tree.  If the object is not found, ok
+// is false; otherwise ok is true.
+func (t *ConcurrentRtree) Delete(obj Spatial) (ok bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.tree.Delete(obj)
+}
+
+// Size returns the number of objects currently stored in the tree.
+func (t *ConcurrentRtree) Size() int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.tree.Size()
+}
+
+// Depth returns the maximum depth of the tree.
+func (t *ConcurrentRtree) Depth() int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.tree.Depth()
+}
+
+// SearchIntersect returns all objects that intersect the specified
+// rectangle and are accepted by filters, as for Rtree.SearchIntersect.
+func (t *ConcurrentRtree) SearchIntersect(bb *Rect, filters ...Filter) []Spatial {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.tree.SearchIntersect(bb, filters...)
+}
+
+// NearestNeighbor returns the object in the tree closest to p.
+func (t *ConcurrentRtree) NearestNeighbor(p Point) Spatial {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.tree.NearestNeighbor(p)
+}
+
+// NearestNeighbors returns the k objects in the tree closest to p.
+func (t *ConcurrentRtree) NearestNeighbors(k int, p Point) []Spatial {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.tree.NearestNeighbors(k, p)
+}
@@ -0,0 +1,58 @@
+// Copyright 2012 Daniel Connelly.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rtreego
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestConcurrentRtree(t *testing.T) {
+	objs := randomRects(500, 32)
+	ct := NewConcurrentTree(3, 6)
+	bb := mustRect(Point{20, 20, 20}, [Dim]float64{40, 40, 40})
+
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				ct.SearchIntersect(bb)
+				ct.NearestNeighbor(Point{50, 50, 50})
+				ct.NearestNeighbors(3, Point{50, 50, 50})
+			}
+		}()
+	}
+	var writers sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		writers.Add(1)
+		go func(objs []Spatial) {
+			defer writers.Done()
+			for _, obj := range objs {
+				ct.Insert(obj)
+			}
+			for _, obj := range objs[:len(objs)/2] {
+				if !ct.Delete(obj) {
+					t.Errorf("ConcurrentRtree failed to delete %v", obj)
+				}
+			}
+		}(objs[i*250 : (i+1)*250])
+	}
+	writers.Wait()
+	close(done)
+	wg.Wait()
+
+	verify(t, ct.tree.root)
+	if ct.Size() != 250 {
+		t.Errorf("ConcurrentRtree has size %d; expected 250", ct.Size())
+	}
+}
@@ -0,0 +1,185 @@
+// Copyright 2012 Daniel Connelly.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rtreego
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// COWTree is an R-tree that lets any number of readers query it without
+// locking while writes are in progress.
+//
+// Writes never modify a node that a reader might see.  Instead, Insert and
+// Delete copy every node on the path from the root to the leaves they change,
+// build the new version of the tree out of the copies and the untouched
+// subtrees of the old one, and then atomically publish its root.  Readers
+// that obtained a Snapshot earlier keep seeing the old version, which stays
+// consistent for as long as they hold it.
+//
+// The price is memory: each write allocates Depth() new nodes, plus any
+// created by splits or by reinsertions after an underflow, each with room for
+// MaxChildren+1 entries, and the nodes they replace can only be reclaimed
+// once no snapshot refers to them.  Writes are serialized by a mutex.
+type COWTree struct {
+	mu      sync.Mutex
+	current atomic.Pointer[Rtree]
+}
+
+// NewCOWTree creates a new copy-on-write R-tree with the specified minimum
+// and maximum branching factors.
+func NewCOWTree(MinChildren, MaxChildren int) *COWTree {
+	t := &COWTree{}
+	t.current.Store(NewTree(MinChildren, MaxChildren))
+	return t
+}
+
+// Insert inserts a spatial object into the tree.
+func (t *COWTree) Insert(obj Spatial) {
+	t.write(func(tree *Rtree) { tree.Insert(obj) })
+}
+
+// Delete removes an object from the tree.  If the object is not found, ok
+// is false; otherwise ok is true.
+func (t *COWTree) Delete(obj Spatial) (ok bool) {
+	t.write(func(tree *Rtree) { ok = tree.Delete(obj) })
+	return ok
+}
+
+// write applies fn to a new version of the tree and publishes it.
+func (t *COWTree) write(fn func(tree *Rtree)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	next := *t.current.Load()
+	next.gen++
+	fn(&next)
+	t.current.Store(&next)
+}
+
+// Reader returns a snapshot of the current version of the tree.
+func (t *COWTree) Reader() *Snapshot {
+	return &Snapshot{tree: t.current.Load()}
+}
+
+// Snapshot is a read-only view of a COWTree as it was when the snapshot was
+// taken.  It is unaffected by later writes and safe for concurrent use.
+type Snapshot struct {
+	tree *Rtree
+}
+
+// Size returns the number of objects in the snapshot.
+func (s *Snapshot) Size() int {
+	return s.tree.Size()
+}
+
+// Depth returns the maximum depth of the snapshot.
+func (s *Snapshot) Depth() int {
+	return s.tree.Depth()
+}
+
+// SearchIntersect returns all objects in the snapshot that intersect the
+// specified rectangle and are accepted by filters, as for
+// Rtree.SearchIntersect.
+func (s *Snapshot) SearchIntersect(bb *Rect, filters ...Filter) []Spatial {
+	return s.tree.SearchIntersect(bb, filters...)
+}
+
+// NearestNeighbor returns the object in the snapshot closest to p.
+func (s *Snapshot) NearestNeighbor(p Point) Spatial {
+	return s.tree.NearestNeighbor(p)
+}
+
+// NearestNeighbors returns the k objects in the snapshot closest to p.
+func (s *Snapshot) NearestNeighbors(k int, p Point) []Spatial {
+	return s.tree.NearestNeighbors(k, p)
+}
+
+// Copy-on-write support.  Every write to a copy-on-write tree runs with a
+// new generation number, and a node may only be modified by the write of the
+// generation that created it; any other node is copied first.  Parent
+// pointers always describe the latest version of the tree, so older versions
+// must only be traversed downwards.
+
+// clone returns a copy of n, with its own entries, for generation gen, and
+// makes it the parent of the children of n.
+func (n *node) clone(gen uint64, maxChildren int) *node {
+	c := &node{
+		parent:  n.parent,
+		leaf:    n.leaf,
+		level:   n.level,
+		gen:     gen,
+		entries: make([]entry, len(n.entries), maxChildren+1),
+	}
+	copy(c.entries, n.entries)
+	for _, e := range c.entries {
+		if e.child != nil {
+			e.child.parent = c
+		}
+	}
+	return c
+}
+
+// mutableRoot returns a version of the root that the current write may
+// modify.
+func (tree *Rtree) mutableRoot() *node {
+	if tree.gen == 0 || tree.root.gen == tree.gen {
+		return tree.root
+	}
+	return tree.root.clone(tree.gen, tree.MaxChildren)
+}
+
+// mutableChild returns a version of the i-th child of n that the current
+// write may modify, replacing the child in n if necessary.  n itself must be
+// modifiable.
+func (tree *Rtree) mutableChild(n *node, i int) *node {
+	child := n.entries[i].child
+	if tree.gen == 0 || child.gen == tree.gen {
+		return child
+	}
+	c := child.clone(tree.gen, tree.MaxChildren)
+	c.parent = n
+	n.entries[i].child = c
+	return c
+}
+
+// findMutableLeaf is like findLeaf, but returns a leaf that the current
+// write may modify, copying the path to it if necessary.
+func (tree *Rtree) findMutableLeaf(obj Spatial, cmp Comparator) *node {
+	if tree.gen == 0 {
+		return tree.findLeaf(tree.root, obj, cmp)
+	}
+	path, ok := tree.findPath(tree.root, obj, cmp, nil)
+	if !ok {
+		return nil
+	}
+	tree.root = tree.mutableRoot()
+	n := tree.root
+	for _, i := range path {
+		n = tree.mutableChild(n, i)
+	}
+	return n
+}
+
+// findPath finds the leaf containing an object equal to obj according to
+// cmp, and appends to path the indices of the entries leading to it from n.
+func (tree *Rtree) findPath(n *node, obj Spatial, cmp Comparator, path []int) ([]int, bool) {
+	if n.leaf {
+		for _, e := range n.entries {
+			if cmp(obj, e.obj) {
+				return path, true
+			}
+		}
+		return nil, false
+	}
+	bb := obj.Bounds()
+	for i, e := range n.entries {
+		if e.bb.containsRect(bb) {
+			if found, ok := tree.findPath(e.child, obj, cmp, append(path, i)); ok {
+				return found, true
+			}
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,100 @@
+// Copyright 2012 Daniel Connelly.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rtreego
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestCOWTreeSnapshots(t *testing.T) {
+	objs := randomRects(300, 9)
+	cow := NewCOWTree(3, 6)
+	for _, obj := range objs[:200] {
+		cow.Insert(obj)
+	}
+	bb := mustRect(Point{0, 0, 0}, [Dim]float64{60, 60, 60})
+	before := cow.Reader()
+	expected := before.SearchIntersect(bb)
+
+	for _, obj := range objs[200:] {
+		cow.Insert(obj)
+	}
+	for _, obj := range objs[:100] {
+		if !cow.Delete(obj) {
+			t.Fatalf("COWTree failed to delete %v", obj)
+		}
+	}
+	if cow.Delete(objs[0]) {
+		t.Errorf("COWTree deleted an object twice")
+	}
+
+	if before.Size() != 200 {
+		t.Errorf("snapshot size changed to %d; expected 200", before.Size())
+	}
+	q := before.SearchIntersect(bb)
+	if len(q) != len(expected) {
+		t.Errorf("snapshot SearchIntersect found %d objects; expected %d", len(q), len(expected))
+	}
+	for _, obj := range expected {
+		if indexOf(q, obj) < 0 {
+			t.Errorf("snapshot SearchIntersect failed to find %v", obj)
+		}
+	}
+
+	after := cow.Reader()
+	verify(t, after.tree.root)
+	if after.Size() != 200 {
+		t.Errorf("COWTree has size %d; expected 200", after.Size())
+	}
+	for i, obj := range objs {
+		found := after.tree.findLeaf(after.tree.root, obj, defaultComparator) != nil
+		if found != (i >= 100) {
+			t.Errorf("object %d found = %v in the current version", i, found)
+		}
+	}
+}
+
+func TestCOWTreeConcurrentReads(t *testing.T) {
+	objs := randomRects(500, 10)
+	cow := NewCOWTree(3, 6)
+	bb := mustRect(Point{20, 20, 20}, [Dim]float64{40, 40, 40})
+
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				s := cow.Reader()
+				n := 0
+				s.tree.root.walk(func(e entry) bool {
+					n++
+					return true
+				})
+				if n != s.Size() {
+					t.Errorf("snapshot holds %d objects; expected %d", n, s.Size())
+					return
+				}
+				s.SearchIntersect(bb)
+				s.NearestNeighbors(3, Point{50, 50, 50})
+			}
+		}()
+	}
+	for _, obj := range objs {
+		cow.Insert(obj)
+	}
+	for _, obj := range objs[:250] {
+		cow.Delete(obj)
+	}
+	close(done)
+	wg.Wait()
+}
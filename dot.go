@@ -0,0 +1,57 @@
+// Copyright 2012 Daniel Connelly.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rtreego
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+)
+
+// WriteDOT writes the structure of tree to w in the DOT language of
+// GraphViz, for rendering with a command such as dot -Tsvg.  Each node of
+// the tree becomes a box labeled with its level, its bounding box and the
+// number of its entries out of MaxChildren, with edges to its children.
+// The objects in the leaves become ellipses labeled with their stored
+// bounding boxes, and their IDs if they are Identifiable.  The tree isn't
+// modified, so WriteDOT may run concurrently with searches.
+func (tree *Rtree) WriteDOT(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "digraph rtree {")
+	fmt.Fprintln(bw, "\tnode [shape=box];")
+	ids := 0
+	tree.writeDOTNode(bw, tree.root, &ids)
+	fmt.Fprintln(bw, "}")
+	return bw.Flush()
+}
+
+// writeDOTNode writes n and its subtree, numbering the graph nodes from
+// *ids, and returns the name of the graph node for n.
+func (tree *Rtree) writeDOTNode(w io.Writer, n *node, ids *int) string {
+	name := fmt.Sprintf("n%d", *ids)
+	*ids++
+	label := fmt.Sprintf("level %d\n%d/%d entries", n.level, len(n.entries), tree.MaxChildren)
+	if len(n.entries) > 0 {
+		label = fmt.Sprintf("level %d\n%v\n%d/%d entries", n.level, n.computeBoundingBox(), len(n.entries), tree.MaxChildren)
+	}
+	fmt.Fprintf(w, "\t%s [label=%q];\n", name, label)
+
+	for _, e := range n.entries {
+		var child string
+		if n.leaf {
+			child = fmt.Sprintf("n%d", *ids)
+			*ids++
+			label := e.bb.String()
+			if obj, ok := e.obj.(Identifiable); ok {
+				label = obj.ID() + "\n" + label
+			}
+			fmt.Fprintf(w, "\t%s [shape=ellipse, label=%q];\n", child, label)
+		} else {
+			child = tree.writeDOTNode(w, e.child, ids)
+		}
+		fmt.Fprintf(w, "\t%s -> %s;\n", name, child)
+	}
+	return name
+}
@@ -0,0 +1,70 @@
+// Copyright 2012 Daniel Connelly.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rtreego
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+type failWriter struct{}
+
+func (failWriter) Write(p []byte) (int, error) { return 0, errors.New("write failed") }
+
+func TestWriteDOT(t *testing.T) {
+	rt := NewTree(3, 6)
+	objs := randomRects(50, 47)
+	for _, obj := range objs {
+		rt.Insert(obj)
+	}
+	rt.Insert(namedRect{mustRect(Point{1, 2, 3}, [Dim]float64{1, 1, 1}), "needle"})
+
+	var sb strings.Builder
+	if err := rt.WriteDOT(&sb); err != nil {
+		t.Fatalf("WriteDOT failed: %v", err)
+	}
+	out := sb.String()
+	if !strings.HasPrefix(out, "digraph rtree {\n") || !strings.HasSuffix(out, "}\n") {
+		t.Errorf("WriteDOT output isn't a digraph:\n%s", out)
+	}
+
+	nodes := 0
+	var count func(n *node)
+	count = func(n *node) {
+		nodes++
+		if !n.leaf {
+			for _, e := range n.entries {
+				count(e.child)
+			}
+		}
+	}
+	count(rt.root)
+	// every graph node but the root has exactly one incoming edge
+	if got, want := strings.Count(out, " -> "), nodes+rt.Size()-1; got != want {
+		t.Errorf("WriteDOT wrote %d edges, want %d", got, want)
+	}
+	if got, want := strings.Count(out, "[shape=ellipse"), rt.Size(); got != want {
+		t.Errorf("WriteDOT wrote %d objects, want %d", got, want)
+	}
+	if !strings.Contains(out, rt.root.computeBoundingBox().String()) {
+		t.Errorf("WriteDOT output doesn't contain the root's bounding box")
+	}
+	if !strings.Contains(out, `label="needle\n`) {
+		t.Errorf("WriteDOT output doesn't label an Identifiable object with its ID")
+	}
+
+	sb.Reset()
+	if err := NewTree(3, 6).WriteDOT(&sb); err != nil {
+		t.Fatalf("WriteDOT failed on an empty tree: %v", err)
+	}
+	if strings.Count(sb.String(), "label=") != 1 || strings.Contains(sb.String(), "->") {
+		t.Errorf("WriteDOT of an empty tree wrote more than its root:\n%s", sb.String())
+	}
+
+	if err := rt.WriteDOT(failWriter{}); err == nil {
+		t.Errorf("WriteDOT didn't report a failing writer")
+	}
+}
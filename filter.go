@@ -0,0 +1,76 @@
+// Copyright 2012 Daniel Connelly.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rtreego
+
+// Filter decides which objects a search returns.  It is called for every
+// object the search finds, with the results collected so far, which must be
+// treated as read-only.  If refuse is true, object is left out of the
+// results.  If abort is true, the search stops after object, which is still
+// added to the results unless it is also refused.
+type Filter func(results []Spatial, object Spatial) (refuse, abort bool)
+
+// AndFilters returns a filter that accepts an object only if all of fs
+// accept it.
+//
+// The filters are called in order until one of them refuses the object, and
+// the filters after it are not called for this object.  The object is
+// refused if any filter refused it, and the search is aborted if any of the
+// filters that were called aborted it, whether or not it refused the object.
+// With no filters, every object is accepted.
+func AndFilters(fs ...Filter) Filter {
+	return func(results []Spatial, object Spatial) (refuse, abort bool) {
+		for _, f := range fs {
+			r, a := f(results, object)
+			abort = abort || a
+			if r {
+				return true, abort
+			}
+		}
+		return false, abort
+	}
+}
+
+// OrFilters returns a filter that accepts an object if any of fs accepts it.
+//
+// The filters are called in order until one of them accepts the object, and
+// the filters after it are not called for this object.  The object is
+// refused only if every filter refused it, and the search is aborted if any
+// of the filters that were called aborted it, whether or not it refused the
+// object.  With no filters, every object is refused.
+func OrFilters(fs ...Filter) Filter {
+	return func(results []Spatial, object Spatial) (refuse, abort bool) {
+		for _, f := range fs {
+			r, a := f(results, object)
+			abort = abort || a
+			if !r {
+				return false, abort
+			}
+		}
+		return true, abort
+	}
+}
+
+// FilterLimit returns a filter that accepts objects until the results hold k
+// of them, and then aborts the search on the next object found, refusing it,
+// so that the search returns at most k objects.  Waiting for the results to
+// fill up, rather than aborting on the k-th object, lets filters after it
+// refuse objects without cutting the results short.  For k <= 0 the search
+// aborts on the first object found.
+func FilterLimit(k int) Filter {
+	return func(results []Spatial, object Spatial) (refuse, abort bool) {
+		if len(results) >= k {
+			return true, true
+		}
+		return false, false
+	}
+}
+
+// FilterFunc returns a filter that accepts exactly the objects for which
+// pred returns true.
+func FilterFunc(pred func(obj Spatial) bool) Filter {
+	return func(results []Spatial, object Spatial) (refuse, abort bool) {
+		return !pred(object), false
+	}
+}
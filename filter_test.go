@@ -0,0 +1,130 @@
+// Copyright 2012 Daniel Connelly.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rtreego
+
+import "testing"
+
+// constFilter returns a filter with a fixed verdict that counts its calls.
+func constFilter(refuse, abort bool, calls *int) Filter {
+	return func(results []Spatial, object Spatial) (bool, bool) {
+		*calls++
+		return refuse, abort
+	}
+}
+
+func TestAndFilters(t *testing.T) {
+	tests := []struct {
+		verdicts      [][2]bool
+		refuse, abort bool
+		calls         int
+	}{
+		{nil, false, false, 0},
+		{[][2]bool{{false, false}, {false, false}}, false, false, 2},
+		{[][2]bool{{false, false}, {true, false}, {false, true}}, true, false, 2},
+		{[][2]bool{{false, true}, {true, false}}, true, true, 2},
+		{[][2]bool{{false, true}, {false, false}}, false, true, 2},
+		{[][2]bool{{false, false}, {true, true}}, true, true, 2},
+	}
+	for i, test := range tests {
+		calls := 0
+		var fs []Filter
+		for _, v := range test.verdicts {
+			fs = append(fs, constFilter(v[0], v[1], &calls))
+		}
+		refuse, abort := AndFilters(fs...)(nil, nil)
+		if refuse != test.refuse || abort != test.abort || calls != test.calls {
+			t.Errorf("test %d: AndFilters = (%v, %v) after %d calls; expected (%v, %v) after %d",
+				i, refuse, abort, calls, test.refuse, test.abort, test.calls)
+		}
+	}
+}
+
+func TestOrFilters(t *testing.T) {
+	tests := []struct {
+		verdicts      [][2]bool
+		refuse, abort bool
+		calls         int
+	}{
+		{nil, true, false, 0},
+		{[][2]bool{{true, false}, {true, false}}, true, false, 2},
+		{[][2]bool{{true, false}, {false, false}, {true, true}}, false, false, 2},
+		{[][2]bool{{true, true}, {false, false}}, false, true, 2},
+		{[][2]bool{{false, true}, {true, false}}, false, true, 1},
+		{[][2]bool{{true, false}, {true, true}}, true, true, 2},
+	}
+	for i, test := range tests {
+		calls := 0
+		var fs []Filter
+		for _, v := range test.verdicts {
+			fs = append(fs, constFilter(v[0], v[1], &calls))
+		}
+		refuse, abort := OrFilters(fs...)(nil, nil)
+		if refuse != test.refuse || abort != test.abort || calls != test.calls {
+			t.Errorf("test %d: OrFilters = (%v, %v) after %d calls; expected (%v, %v) after %d",
+				i, refuse, abort, calls, test.refuse, test.abort, test.calls)
+		}
+	}
+}
+
+func TestSearchIntersectFilters(t *testing.T) {
+	objs := randomRects(300, 38)
+	rt := NewTree(3, 6)
+	for _, obj := range objs {
+		rt.Insert(obj)
+	}
+	bb := mustRect(Point{0, 0, 0}, [Dim]float64{70, 70, 70})
+	all := rt.SearchIntersect(bb)
+	if len(all) < 20 {
+		t.Fatalf("SearchIntersect found only %d objects", len(all))
+	}
+
+	calls := 0
+	counting := func(results []Spatial, object Spatial) (bool, bool) {
+		calls++
+		return false, false
+	}
+	// the search stops at the object after the tenth
+	q := rt.SearchIntersect(bb, counting, FilterLimit(10))
+	if len(q) != 10 || calls != 11 {
+		t.Errorf("SearchIntersect with FilterLimit(10) returned %d objects after %d calls", len(q), calls)
+	}
+	for i := range q {
+		if q[i] != all[i] {
+			t.Errorf("SearchIntersect with FilterLimit(10) returned %v at %d; expected %v", q[i], i, all[i])
+		}
+	}
+	if q := rt.SearchIntersect(bb, FilterLimit(0)); len(q) != 0 {
+		t.Errorf("SearchIntersect with FilterLimit(0) returned %d objects", len(q))
+	}
+
+	small := FilterFunc(func(obj Spatial) bool { return obj.Bounds().size() < 20 })
+	expected := 0
+	for _, obj := range all {
+		if obj.Bounds().size() < 20 {
+			expected++
+		}
+	}
+	if q := rt.SearchIntersect(bb, small); len(q) != expected {
+		t.Errorf("SearchIntersect with FilterFunc returned %d objects; expected %d", len(q), expected)
+	}
+	if q := rt.SearchIntersect(bb, small, FilterLimit(3)); len(q) != min(3, expected) {
+		t.Errorf("SearchIntersect with FilterFunc and FilterLimit(3) returned %d objects", len(q))
+	}
+	// a limit given before a predicate must not let refused objects through,
+	// nor stop the search when the predicate refuses an object
+	q = rt.SearchIntersect(bb, FilterLimit(3), small)
+	if len(q) != min(3, expected) {
+		t.Errorf("SearchIntersect with FilterLimit(3) and FilterFunc returned %d objects; expected %d", len(q), min(3, expected))
+	}
+	for _, obj := range q {
+		if obj.Bounds().size() >= 20 {
+			t.Errorf("SearchIntersect with FilterLimit(3) and FilterFunc returned refused object %v", obj)
+		}
+	}
+	refuseAll := FilterFunc(func(obj Spatial) bool { return false })
+	if q := rt.SearchIntersect(bb, FilterLimit(1), refuseAll); len(q) != 0 {
+		t.Errorf("SearchIntersect with FilterLimit(1) and a refusing filter returned %d objects", len(q))
+	}
+}
@@ -0,0 +1,70 @@
+// Copyright 2012 Daniel Connelly.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rtreego
+
+import "math"
+
+// EarthRadius is the mean radius of the Earth in meters.
+const EarthRadius = 6371008.8
+
+// GeoMetric is the great-circle distance on a sphere of the given radius,
+// for points and rectangles whose first coordinate is a longitude and whose
+// second is a latitude, both in degrees; any other coordinates are ignored.
+// Distances are in the units of Radius, so GeoMetric{EarthRadius} measures
+// meters on the Earth.
+//
+// Rectangles are taken to span longitudes from their minimum to their maximum
+// coordinate, so a stored rectangle can't cross the antimeridian, but
+// distances wrap around it: a point at longitude 179 is close to one at -179.
+type GeoMetric struct {
+	Radius float64
+}
+
+// MinDist returns the great-circle distance from p to the nearest point of r.
+//
+// If the longitude of p lies within r, the nearest point is on the meridian
+// through p, at the latitude of p clamped to r.  Otherwise it lies on the
+// edge of r whose longitude is nearer, around either side of the globe, as
+// the distance to a point grows with the difference in longitude.  Along the
+// great circle of that edge's meridian the distance is smallest at the
+// latitude atan2(sin φ, cos φ cos Δλ), where φ is the latitude of p and Δλ
+// the difference in longitude, and grows away from it in both directions, so
+// the nearest point of the edge is there or at one of its ends.
+func (m GeoMetric) MinDist(p Point, r *Rect) float64 {
+	lon, lat := p[0], p[1]
+	if r.p[0] <= lon && lon <= r.q[0] {
+		return m.haversine(lon, lat, lon, clamp(lat, r.p[1], r.q[1]))
+	}
+
+	edge := r.p[0]
+	if math.Abs(wrapLongitude(r.q[0]-lon)) < math.Abs(wrapLongitude(r.p[0]-lon)) {
+		edge = r.q[0]
+	}
+	phi, dLambda := lat*math.Pi/180, (edge-lon)*math.Pi/180
+	d := math.Min(m.haversine(lon, lat, edge, r.p[1]), m.haversine(lon, lat, edge, r.q[1]))
+	nearest := math.Atan2(math.Sin(phi), math.Cos(phi)*math.Cos(dLambda)) * 180 / math.Pi
+	if r.p[1] < nearest && nearest < r.q[1] {
+		d = math.Min(d, m.haversine(lon, lat, edge, nearest))
+	}
+	return d
+}
+
+// haversine computes the great-circle distance between two points given by
+// their longitudes and latitudes in degrees.
+func (m GeoMetric) haversine(lon1, lat1, lon2, lat2 float64) float64 {
+	phi1, phi2 := lat1*math.Pi/180, lat2*math.Pi/180
+	dPhi, dLambda := phi2-phi1, (lon2-lon1)*math.Pi/180
+	h := math.Pow(math.Sin(dPhi/2), 2) + math.Cos(phi1)*math.Cos(phi2)*math.Pow(math.Sin(dLambda/2), 2)
+	return 2 * m.Radius * math.Asin(math.Sqrt(math.Min(h, 1)))
+}
+
+// wrapLongitude maps a difference in longitude to [-180, 180].
+func wrapLongitude(d float64) float64 {
+	return math.Remainder(d, 360)
+}
+
+func clamp(x, lo, hi float64) float64 {
+	return math.Max(lo, math.Min(x, hi))
+}
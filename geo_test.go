@@ -0,0 +1,73 @@
+// Copyright 2012 Daniel Connelly.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rtreego
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestGeoMetricMinDist(t *testing.T) {
+	m := GeoMetric{EarthRadius}
+	// one degree of latitude is about 111.2 km
+	r := mustRect(Point{10, 10, 0}, [Dim]float64{5, 5, 1})
+	if d := m.MinDist(Point{12, 5, 0}, r); math.Abs(d-5*111195) > 100 {
+		t.Errorf("MinDist to a rectangle 5 degrees north = %v", d)
+	}
+	if d := m.MinDist(Point{12, 12, 0}, r); d != 0 {
+		t.Errorf("MinDist to a containing rectangle = %v", d)
+	}
+	// across the antimeridian
+	east := mustRect(Point{-180, -1, 0}, [Dim]float64{1, 2, 1})
+	if d := m.MinDist(Point{179, 0, 0}, east); math.Abs(d-111195) > 100 {
+		t.Errorf("MinDist across the antimeridian = %v", d)
+	}
+
+	// MinDist is the smallest distance to any point of the rectangle
+	rnd := rand.New(rand.NewSource(44))
+	for i := 0; i < 200; i++ {
+		lon, lat := rnd.Float64()*340-170, rnd.Float64()*160-80
+		r := mustRect(Point{lon, lat, 0}, [Dim]float64{rnd.Float64() * 10, rnd.Float64() * 10, 1})
+		p := Point{rnd.Float64()*360 - 180, rnd.Float64()*180 - 90, 0}
+		d := m.MinDist(p, r)
+		sampled := math.Inf(1)
+		for j := 0; j <= 50; j++ {
+			for k := 0; k <= 50; k++ {
+				x := r.p[0] + (r.q[0]-r.p[0])*float64(j)/50
+				y := r.p[1] + (r.q[1]-r.p[1])*float64(k)/50
+				sampled = math.Min(sampled, m.haversine(p[0], p[1], x, y))
+			}
+		}
+		if d > sampled+1e-6 || sampled-d > 0.01*sampled+1000 {
+			t.Errorf("MinDist(%v, %v) = %v; sampling found %v", p, r, d, sampled)
+		}
+	}
+}
+
+func TestGeoNearestNeighbors(t *testing.T) {
+	m := GeoMetric{EarthRadius}
+	rnd := rand.New(rand.NewSource(45))
+	rt := NewTree(3, 6)
+	var objs []Spatial
+	for i := 0; i < 500; i++ {
+		r, _ := NewRect(Point{rnd.Float64()*360 - 180, rnd.Float64()*180 - 90, 0}, [Dim]float64{})
+		objs = append(objs, &r)
+		rt.Insert(&r)
+	}
+
+	for _, q := range []Point{{179.5, 0, 0}, {-179.5, 10, 0}, {0, 89, 0}, {30, -85, 0}, {12, 45, 0}} {
+		expected := nearestByScan(objs, 5, q, m)
+		if obj := rt.NearestNeighborMetric(q, m); obj != expected[0] {
+			t.Errorf("NearestNeighborMetric(%v) = %v; expected %v", q, obj, expected[0])
+		}
+		nearest := rt.NearestNeighborsMetric(5, q, m)
+		for i := range expected {
+			if nearest[i] != expected[i] {
+				t.Errorf("NearestNeighborsMetric(%v)[%d] = %v; expected %v", q, i, nearest[i], expected[i])
+			}
+		}
+	}
+}
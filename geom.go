@@ -5,17 +5,54 @@
 package rtreego
 
 import (
+	"cmp"
+	"errors"
 	"fmt"
 	"math"
+	"slices"
 	"strings"
 )
 
+// Errors describing invalid geometry.  They can be tested for with
+// errors.Is, including on the DistError values returned by NewRect.
+var (
+	// ErrZeroLength means that a rectangle has a side of negative length.
+	// Despite the name, sides of zero length are allowed.
+	ErrZeroLength = errors.New("rtreego: negative length")
+	// ErrNaNCoordinate means that a point or rectangle has a NaN
+	// coordinate.
+	ErrNaNCoordinate = errors.New("rtreego: NaN coordinate")
+	// ErrDimMismatch means that a point or rectangle doesn't have Dim
+	// coordinates.
+	ErrDimMismatch = errors.New("rtreego: dimension mismatch")
+	// ErrOutsideUniverse means that an object inserted into a tree created
+	// WithUniverse lies partly or wholly outside the universe.
+	ErrOutsideUniverse = errors.New("rtreego: bounds outside the universe")
+)
+
 // DistError is an improper distance measurement.  It implements the error
-// and is generated when a distance-related assertion fails.
-type DistError float64
+// and is generated when a distance-related assertion fails, such as a
+// rectangle with a negative length.  A DistError whose Length is NaN, as for
+// a NaN coordinate, is an ErrNaNCoordinate; any other is an ErrZeroLength.
+type DistError struct {
+	Dimension int     // the index of the offending axis
+	Length    float64 // the offending length along it, or NaN
+}
 
 func (err DistError) Error() string {
-	return "rtreego: improper distance"
+	if math.IsNaN(err.Length) {
+		return fmt.Sprintf("rtreego: NaN coordinate in dimension %d", err.Dimension)
+	}
+	return fmt.Sprintf("rtreego: negative length %g in dimension %d", err.Length, err.Dimension)
+}
+
+// Is reports whether err is an instance of target, which lets errors.Is
+// match a DistError against ErrZeroLength and ErrNaNCoordinate.
+func (err DistError) Is(target error) bool {
+	if math.IsNaN(err.Length) {
+		return target == ErrNaNCoordinate
+	}
+	return target == ErrZeroLength
 }
 
 // Point represents a point in 3-dimensional Euclidean space.
@@ -32,13 +69,17 @@ func (p Point) dist(q Point) float64 {
 }
 
 // minDist computes the square of the distance from a point to a rectangle.
-// If the point is contained in the rectangle then the distance is zero.
+// If the point is contained in the rectangle then the distance is zero.  If
+// any coordinate of p or r is NaN then so is the distance.
 //
 // Implemented per Definition 2 of "Nearest Neighbor Queries" by
 // N. Roussopoulos, S. Kelley and F. Vincent, ACM SIGMOD, pages 71-79, 1995.
 func (p Point) minDist(r *Rect) float64 {
 	sum := 0.0
 	for i, pi := range p {
+		if isNaN(pi) || isNaN(r.p[i]) || isNaN(r.q[i]) {
+			return math.NaN()
+		}
 		if pi < r.p[i] {
 			d := pi - r.p[i]
 			sum += d * d
@@ -52,9 +93,46 @@ func (p Point) minDist(r *Rect) float64 {
 	return sum
 }
 
+// SortRectsByDist sorts rects in place by increasing distance from p, the
+// order in which nearest-neighbor searches consider bounding boxes.  It
+// doesn't allocate; distances are recomputed for each comparison.
+func SortRectsByDist(p Point, rects []*Rect) {
+	slices.SortFunc(rects, func(r1, r2 *Rect) int {
+		return cmp.Compare(p.minDist(r1), p.minDist(r2))
+	})
+}
+
+// maxDist computes the square of the distance from a point to the farthest
+// point of a rectangle.
+func (p Point) maxDist(r *Rect) float64 {
+	sum := 0.0
+	for i, pi := range p {
+		d := math.Max(math.Abs(pi-r.p[i]), math.Abs(pi-r.q[i]))
+		sum += d * d
+	}
+	return sum
+}
+
+// rectDist computes the square of the distance between the closest points
+// of two rectangles.  If the rectangles intersect then the distance is zero.
+func rectDist(r1, r2 *Rect) float64 {
+	sum := 0.0
+	for i := range r1.p {
+		if r1.q[i] < r2.p[i] {
+			d := r2.p[i] - r1.q[i]
+			sum += d * d
+		} else if r2.q[i] < r1.p[i] {
+			d := r1.p[i] - r2.q[i]
+			sum += d * d
+		}
+	}
+	return sum
+}
+
 // minMaxDist computes the minimum of the maximum distances from p to points
 // on r.  If r is the bounding box of some geometric objects, then there is
-// at least one object contained in r within minMaxDist(p, r) of p.
+// at least one object contained in r within minMaxDist(p, r) of p.  If any
+// coordinate of p or r is NaN then so is the result.
 //
 // Implemented per Definition 4 of "Nearest Neighbor Queries" by
 // N. Roussopoulos, S. Kelley and F. Vincent, ACM SIGMOD, pages 71-79, 1995.
@@ -63,6 +141,12 @@ func (p Point) minMaxDist(r *Rect) float64 {
 	// min{1<=k<=n}(|pk - rmk|^2 + sum{1<=i<=n, i != k}(|pi - rMi|^2))
 	// where rmk and rMk are defined as follows:
 
+	for i, pi := range p {
+		if isNaN(pi) || isNaN(r.p[i]) || isNaN(r.q[i]) {
+			return math.NaN()
+		}
+	}
+
 	rm := func(k int) float64 {
 		if p[k] <= (r.p[k]+r.q[k])/2 {
 			return r.p[k]
@@ -101,7 +185,7 @@ func (p Point) minMaxDist(r *Rect) float64 {
 }
 
 // Rect represents a subset of 3-dimensional Euclidean space of the form
-// [a1, b1] x [a2, b2] x ... x [an, bn], where ai < bi for all 1 <= i <= n.
+// [a1, b1] x [a2, b2] x ... x [an, bn], where ai <= bi for all 1 <= i <= n.
 type Rect struct {
 	p, q Point // Enforced by NewRect: p[i] <= q[i] for all i.
 }
@@ -116,6 +200,13 @@ func (r *Rect) LengthsCoord(i int) float64 {
 	return r.q[i] - r.p[i]
 }
 
+// Bounds returns r itself, so that a *Rect is a Spatial and bare rectangles
+// can be stored in a tree.  They are then matched by pointer, so Delete
+// removes the same *Rect that was inserted, not an equal one.
+func (r *Rect) Bounds() *Rect {
+	return r
+}
+
 // Equal returns true if the two rectangles are equal
 func (r *Rect) Equal(other *Rect) bool {
 	for i, e := range r.p {
@@ -131,6 +222,35 @@ func (r *Rect) Equal(other *Rect) bool {
 	return true
 }
 
+// EqualWithin reports whether every corner coordinate of r is within epsilon
+// of that of other, as for rectangles that have been through arithmetic or
+// serialization that may perturb them slightly.  A NaN coordinate is never
+// within epsilon of anything.
+func (r *Rect) EqualWithin(other *Rect, epsilon float64) bool {
+	for i := range r.p {
+		if !(math.Abs(r.p[i]-other.p[i]) <= epsilon && math.Abs(r.q[i]-other.q[i]) <= epsilon) {
+			return false
+		}
+	}
+	return true
+}
+
+// lessCorners orders rectangles lexicographically by their most-negative
+// corners, then by their most-positive corners.
+func lessCorners(r1, r2 *Rect) bool {
+	for i := range r1.p {
+		if r1.p[i] != r2.p[i] {
+			return r1.p[i] < r2.p[i]
+		}
+	}
+	for i := range r1.q {
+		if r1.q[i] != r2.q[i] {
+			return r1.q[i] < r2.q[i]
+		}
+	}
+	return false
+}
+
 func (r *Rect) String() string {
 	var s [Dim]string
 	for i, a := range r.p {
@@ -142,19 +262,186 @@ func (r *Rect) String() string {
 
 // NewRect constructs and returns a pointer to a Rect given a corner point and
 // the lengths of each dimension.  The point p should be the most-negative point
-// on the rectangle (in every dimension) and every length should be positive.
+// on the rectangle (in every dimension) and every length should be
+// non-negative; a rectangle with zero lengths is flat along those axes, and
+// one with all lengths zero is a point.  Otherwise, or if any coordinate is
+// NaN, the returned error is a DistError.  An infinite length gives a
+// rectangle that is unbounded above along that axis; for one that is
+// unbounded below, use NewRectFromPoints or UnboundedRect instead, since the
+// opposite corner of a point at -Inf is NaN.
 func NewRect(p Point, lengths [Dim]float64) (r Rect, err error) {
 	r.p = p
 	r.q = lengths
 	for i, l := range r.q {
-		if l <= 0 {
-			return r, DistError(l)
+		if math.IsNaN(r.p[i]) {
+			return r, DistError{i, math.NaN()}
+		}
+		if !(l >= 0) {
+			return r, DistError{i, l}
+		}
+		if r.q[i] += r.p[i]; math.IsNaN(r.q[i]) {
+			return r, DistError{i, math.NaN()}
+		}
+	}
+	return r, nil
+}
+
+// NewRectClamped is like NewRect, but silently raises every length below
+// minLen, including negative and NaN ones, to minLen instead of returning an
+// error.  It is meant for generated data whose lengths may come out zero or
+// slightly negative through rounding; the adjusted lengths are not
+// reported.  It panics if minLen is negative or NaN, or if a coordinate of p
+// is NaN, since no length can repair that.
+func NewRectClamped(p Point, lengths [Dim]float64, minLen float64) Rect {
+	if !(minLen >= 0) {
+		panic(fmt.Errorf("rtreego: minimum length %v is negative", minLen))
+	}
+	for i, l := range lengths {
+		if math.IsNaN(p[i]) {
+			panic(DistError{i, math.NaN()})
+		}
+		if !(l >= minLen) {
+			lengths[i] = minLen
+		}
+	}
+	r, _ := NewRect(p, lengths)
+	return r
+}
+
+// NewRectFromPoints constructs a Rect given two opposite corners, in either
+// order along each axis.  Corners that coincide along an axis give a rectangle
+// that is flat along it.  If any coordinate is NaN, the returned error is a
+// DistError.
+func NewRectFromPoints(minCorner, maxCorner Point) (r Rect, err error) {
+	for i := range minCorner {
+		a, b := minCorner[i], maxCorner[i]
+		if math.IsNaN(a) || math.IsNaN(b) {
+			return r, DistError{i, math.NaN()}
 		}
-		r.q[i] += r.p[i]
+		r.p[i], r.q[i] = math.Min(a, b), math.Max(a, b)
 	}
 	return r, nil
 }
 
+// UnboundedRect returns the rectangle covering all of space, with corners at
+// -Inf and +Inf along every axis, which intersects and contains every
+// rectangle without NaN coordinates.  Together with WithBounds it builds
+// open-ended queries, such as for everything with x > 500:
+//
+//	rt.SearchIntersect(rtreego.UnboundedRect().WithBounds(0, 500, math.Inf(1)))
+func UnboundedRect() *Rect {
+	r := new(Rect)
+	for i := range r.p {
+		r.p[i], r.q[i] = math.Inf(-1), math.Inf(1)
+	}
+	return r
+}
+
+// WithBounds returns a copy of r spanning [lo, hi] along the given axis,
+// either of which may be infinite.  It panics if lo > hi or either is NaN.
+func (r *Rect) WithBounds(axis int, lo, hi float64) *Rect {
+	if !(lo <= hi) {
+		panic(fmt.Errorf("rtreego: bounds [%v, %v] for dimension %d are inverted or NaN", lo, hi, axis))
+	}
+	bounded := *r
+	bounded.p[axis], bounded.q[axis] = lo, hi
+	return &bounded
+}
+
+// Snap returns a copy of r whose corners have been moved outward to the
+// nearest multiples of cellSize, so that the result is the smallest
+// grid-aligned rectangle containing r.  It panics if cellSize is not
+// positive.
+func (r *Rect) Snap(cellSize float64) *Rect {
+	if !(cellSize > 0) {
+		panic(fmt.Errorf("rtreego: cell size %v is not positive", cellSize))
+	}
+	snapped := new(Rect)
+	for i := range r.p {
+		snapped.p[i] = math.Floor(r.p[i]/cellSize) * cellSize
+		snapped.q[i] = math.Ceil(r.q[i]/cellSize) * cellSize
+	}
+	return snapped
+}
+
+// Expand returns a copy of r grown by margin on both sides along every axis,
+// as for a query buffered by a fixed distance.  A negative margin shrinks r;
+// an axis that would be inverted collapses to its midpoint instead.  It
+// panics if margin is NaN.
+func (r *Rect) Expand(margin float64) *Rect {
+	var margins [Dim]float64
+	for i := range margins {
+		margins[i] = margin
+	}
+	return r.ExpandBy(margins)
+}
+
+// ExpandBy is like Expand, but grows each axis by its own margin.
+func (r *Rect) ExpandBy(margins [Dim]float64) *Rect {
+	expanded := new(Rect)
+	for i, m := range margins {
+		if math.IsNaN(m) {
+			panic(fmt.Errorf("rtreego: margin %v is NaN", m))
+		}
+		a, b := r.p[i]-m, r.q[i]+m
+		if a > b {
+			a = (r.p[i] + r.q[i]) / 2
+			b = a
+		}
+		expanded.p[i], expanded.q[i] = a, b
+	}
+	return expanded
+}
+
+// Translate returns a copy of r moved by offset.
+func (r *Rect) Translate(offset Point) *Rect {
+	moved := new(Rect)
+	for i, d := range offset {
+		moved.p[i], moved.q[i] = r.p[i]+d, r.q[i]+d
+	}
+	return moved
+}
+
+// Scale returns a copy of r with each coordinate multiplied by the factor
+// for its axis, so that the rectangle is scaled about the origin.  An axis
+// with a negative factor is mirrored, and its corners are swapped to keep
+// the result valid.  It panics if a factor is NaN.
+func (r *Rect) Scale(factors [Dim]float64) *Rect {
+	scaled := new(Rect)
+	for i, f := range factors {
+		if math.IsNaN(f) {
+			panic(fmt.Errorf("rtreego: scale factor %v is NaN", f))
+		}
+		a, b := r.p[i]*f, r.q[i]*f
+		if f < 0 {
+			a, b = b, a
+		}
+		scaled.p[i], scaled.q[i] = a, b
+	}
+	return scaled
+}
+
+// center computes the point at the center of a rectangle.
+func (r *Rect) center() Point {
+	var c Point
+	for i := range r.p {
+		c[i] = (r.p[i] + r.q[i]) / 2
+	}
+	return c
+}
+
+// check verifies that r satisfies the invariant p[i] <= q[i] for all i and
+// has no NaN coordinates, returning a DistError for the first offending
+// dimension.
+func (r *Rect) check() error {
+	for i, a := range r.p {
+		if l := r.q[i] - a; !(l >= 0) {
+			return DistError{i, l}
+		}
+	}
+	return nil
+}
+
 // size computes the measure of a rectangle (the product of its side lengths).
 func (r *Rect) size() float64 {
 	size := 1.0
@@ -182,6 +469,52 @@ func (r *Rect) margin() float64 {
 	return 4.0 * sum
 }
 
+// Size returns the measure of r: its area in two dimensions, its volume in
+// three, and in general the product of its side lengths.
+func (r *Rect) Size() float64 {
+	return r.size()
+}
+
+// Margin returns the sum of the lengths of the edges of r.
+func (r *Rect) Margin() float64 {
+	return r.margin()
+}
+
+// Center returns the point at the center of r.
+func (r *Rect) Center() Point {
+	return r.center()
+}
+
+// Corners returns the most-negative and most-positive corners of r.  Points
+// are arrays, so these are copies: changing them doesn't change r.
+func (r *Rect) Corners() (min, max Point) {
+	return r.p, r.q
+}
+
+// ContainsPoint reports whether p lies inside r or on its boundary: unlike
+// Intersects, it compares closed intervals, so a point on a face of r is
+// contained in it.  A NaN coordinate of p or r rules out containment.
+func (r *Rect) ContainsPoint(p Point) bool {
+	for i, a := range p {
+		if !(r.p[i] <= a && a <= r.q[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// ContainsRect reports whether other lies inside r, including when they
+// share faces or are equal: it compares closed intervals, as ContainsPoint
+// does.  A NaN coordinate of either rectangle rules out containment.
+func (r *Rect) ContainsRect(other *Rect) bool {
+	for i := range r.p {
+		if !(r.p[i] <= other.p[i] && other.q[i] <= r.q[i]) {
+			return false
+		}
+	}
+	return true
+}
+
 // containsPoint tests whether p is located inside or on the boundary of r.
 func (r *Rect) containsPoint(p Point) bool {
 	for i, a := range p {
@@ -195,7 +528,10 @@ func (r *Rect) containsPoint(p Point) bool {
 	return true
 }
 
-// containsRect tests whether r2 is is located inside r1.
+// containsRect tests whether r2 is is located inside r1.  A NaN coordinate
+// of either rectangle doesn't rule out containment, so that searches guided
+// by containment, like the one for the leaf holding an object, still reach
+// objects with NaN bounds.
 func (r1 *Rect) containsRect(r2 *Rect) bool {
 	for i, a1 := range r1.p {
 		b1, a2, b2 := r1.q[i], r2.p[i], r2.q[i]
@@ -210,19 +546,52 @@ func (r1 *Rect) containsRect(r2 *Rect) bool {
 	return true
 }
 
+// enlarge grows r1 to contain r2.  NaN coordinates are ignored in favor of
+// those of the other rectangle, so that the bounding box of a set of
+// rectangles doesn't depend on their order.
 func (r1 *Rect) enlarge(r2 *Rect) {
 	for i := 0; i < Dim; i++ {
-		if r1.p[i] > r2.p[i] {
+		if r1.p[i] > r2.p[i] || isNaN(r1.p[i]) {
 			r1.p[i] = r2.p[i]
 		}
-		if r1.q[i] < r2.q[i] {
+		if r1.q[i] < r2.q[i] || isNaN(r1.q[i]) {
 			r1.q[i] = r2.q[i]
 		}
 	}
 }
 
-// intersect computes the intersection of two rectangles.  If no intersection
-// exists, the intersection is nil.
+// OverlapVolume computes the measure of the intersection of two rectangles,
+// which is zero if they don't intersect.
+func OverlapVolume(r1, r2 *Rect) float64 {
+	volume := 1.0
+	for i := range r1.p {
+		d := math.Min(r1.q[i], r2.q[i]) - math.Max(r1.p[i], r2.p[i])
+		if !(d > 0) {
+			return 0
+		}
+		volume *= d
+	}
+	return volume
+}
+
+// overlapFraction estimates the fraction of r1 that lies within r2, taking
+// it axis by axis so that an axis on which r1 has zero length counts as fully
+// covered if r2 spans it.
+func overlapFraction(r1, r2 *Rect) float64 {
+	f := 1.0
+	for i := range r1.p {
+		d := math.Min(r1.q[i], r2.q[i]) - math.Max(r1.p[i], r2.p[i])
+		if l := r1.q[i] - r1.p[i]; l > 0 {
+			f *= math.Max(d, 0) / l
+		} else if d < 0 {
+			return 0
+		}
+	}
+	return f
+}
+
+// intersect reports whether two rectangles overlap, as described for
+// Rect.Intersects.  A rectangle with a NaN coordinate intersects nothing.
 func intersect(r1, r2 *Rect) bool {
 	// There are four cases of overlap:
 	//
@@ -252,15 +621,246 @@ func intersect(r1, r2 *Rect) bool {
 	//
 	// Enforced by constructor: a1 <= b1 and a2 <= b2.  So we can just
 	// check the endpoints.
+	//
+	// Intervals that merely share an endpoint don't overlap, so boxes with
+	// a common face don't intersect, unless one of them has zero length
+	// along that axis: a point or a flat rectangle lying on the face of
+	// another rectangle, or on the same point, intersects it.
+
+	for i := 0; i < Dim; i++ {
+		if r1.p[i] < r2.q[i] && r2.p[i] < r1.q[i] {
+			continue
+		}
+		flat := r1.p[i] == r1.q[i] || r2.p[i] == r2.q[i]
+		if !(flat && r1.p[i] <= r2.q[i] && r2.p[i] <= r1.q[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// sphereInCone reports whether the sphere circumscribing r intersects the
+// infinite cone with the specified apex, axis direction and half-angle.
+// The sphere with center c and radius rho meets the cone if it contains the
+// apex or if the angle between dir and c-apex is at most halfAngle plus the
+// angle asin(rho/|c-apex|) under which the sphere is seen from the apex.
+func (r *Rect) sphereInCone(apex, dir Point, halfAngle float64) bool {
+	c := r.center()
+	var v Point
+	for i := range v {
+		v[i] = c[i] - apex[i]
+	}
+	rho := r.p.dist(r.q) / 2
+	l := v.dist(Point{})
+	if l <= rho {
+		return true
+	}
+	dot := 0.0
+	for i := range v {
+		dot += v[i] * dir[i]
+	}
+	cos := math.Max(-1, math.Min(1, dot/(l*dir.dist(Point{}))))
+	return math.Acos(cos) <= halfAngle+math.Asin(rho/l)
+}
+
+// inCone reports whether r intersects the infinite cone with the specified
+// apex, axis direction and half-angle, which holds the points whose offset w
+// from the apex makes an angle of at most halfAngle with dir.
+//
+// For halfAngle >= pi/2 the complement of the cone is convex, so r meets the
+// cone unless all of its corners lie outside it.  For a narrower cone, r
+// meets it if the convex function h(w) = |w|cos(halfAngle) - w.dir, which is
+// positive exactly outside the cone, is not positive somewhere in r.  Its
+// minimum over r lies inside one of the faces of r, of any dimension, where
+// its gradient along the face vanishes; setting the free coordinates to
+// w[i] = dir[i]|w|/cos(halfAngle) gives that point for each face, if it
+// exists, and r meets the cone if one of them lies in its face and in the
+// cone.
+func (r *Rect) inCone(apex, dir Point, halfAngle float64) bool {
+	var d, lo, hi Point
+	norm := dir.dist(Point{})
+	for i := range d {
+		d[i] = dir[i] / norm
+		lo[i], hi[i] = r.p[i]-apex[i], r.q[i]-apex[i]
+	}
+	c := math.Cos(halfAngle)
+	inside := func(w Point) bool {
+		dot := 0.0
+		for i := range w {
+			dot += w[i] * d[i]
+		}
+		return dot >= w.dist(Point{})*c
+	}
+
+	// each face fixes every coordinate at lo or hi, or leaves it free; only
+	// the corners are needed for a wide cone
+	sides := 3
+	if halfAngle >= math.Pi/2 {
+		sides = 2
+	} else if r.containsPoint(apex) {
+		return true
+	}
+	faces := 1
+	for range Dim {
+		faces *= sides
+	}
+	for face := range faces {
+		var w Point
+		var isFree [Dim]bool
+		var fixed, free float64
+		corner := true
+		for i, f := 0, face; i < Dim; i, f = i+1, f/sides {
+			switch f % sides {
+			case 0:
+				w[i] = lo[i]
+				fixed += w[i] * w[i]
+			case 1:
+				w[i] = hi[i]
+				fixed += w[i] * w[i]
+			default:
+				isFree[i], corner = true, false
+				free += d[i] * d[i]
+			}
+		}
+		if corner {
+			if inside(w) {
+				return true
+			}
+			continue
+		}
+		// without a fixed offset the only candidate is the apex, which
+		// isn't in r
+		if c*c <= free || fixed == 0 {
+			continue
+		}
+		l := math.Sqrt(fixed / (1 - free/(c*c)))
+		ok := true
+		for i := range w {
+			if isFree[i] {
+				w[i] = d[i] * l / c
+				ok = ok && lo[i] <= w[i] && w[i] <= hi[i]
+			}
+		}
+		if ok && inside(w) {
+			return true
+		}
+	}
+	return false
+}
+
+// rayEntry reports whether the ray from origin in direction dir hits r, and
+// if so the smallest t >= 0 for which origin + t*dir lies in r.  It uses the
+// slab method: along each axis the ray lies between the two faces of r for
+// an interval of t, and it hits r if the intervals of all the axes overlap.
+// An axis along which dir is zero doesn't limit t, so long as the origin lies
+// between the faces.
+func (r *Rect) rayEntry(origin, dir Point) (float64, bool) {
+	tmin, tmax := 0.0, math.Inf(1)
+	for i := range r.p {
+		if dir[i] == 0 {
+			if origin[i] < r.p[i] || origin[i] > r.q[i] {
+				return 0, false
+			}
+			continue
+		}
+		t1, t2 := (r.p[i]-origin[i])/dir[i], (r.q[i]-origin[i])/dir[i]
+		if t1 > t2 {
+			t1, t2 = t2, t1
+		}
+		tmin, tmax = math.Max(tmin, t1), math.Min(tmax, t2)
+		if !(tmin <= tmax) {
+			return 0, false
+		}
+	}
+	return tmin, true
+}
+
+// Intersects reports whether r and other overlap, by the same test that
+// SearchIntersect applies to stored objects.  Rectangles must overlap in a
+// region of positive length along every axis, so ones that only share a
+// face, an edge or a corner, like [0, 1] and [1, 2] along some axis, don't
+// intersect.  The exception is an axis along which either rectangle has zero
+// length: a point, or a rectangle that is flat along that axis, intersects
+// the rectangles whose closed intervals contain it there, including at their
+// boundary.  Use Intersection to treat all shared boundary points as
+// overlap.  A rectangle with a NaN coordinate intersects nothing.
+func (r *Rect) Intersects(other *Rect) bool {
+	return intersect(r, other)
+}
+
+// Intersection returns the rectangle where r and other overlap, and whether
+// they overlap at all.  Rectangles that only share boundary points, such as
+// two boxes with a common face, overlap in a degenerate rectangle with zero
+// length along the axes where they meet, which is returned with true.
+// Rectangles with a NaN coordinate overlap nothing.
+func (r *Rect) Intersection(other *Rect) (*Rect, bool) {
+	if !touch(r, other) {
+		return nil, false
+	}
+	var in Rect
+	for i := range r.p {
+		in.p[i] = math.Max(r.p[i], other.p[i])
+		in.q[i] = math.Min(r.q[i], other.q[i])
+	}
+	return &in, true
+}
 
+// touch is like intersect, but also holds for rectangles that only share
+// boundary points.
+func touch(r1, r2 *Rect) bool {
 	for i := 0; i < Dim; i++ {
-		if r2.q[i] <= r1.p[i] || r1.q[i] <= r2.p[i] {
+		if !(r1.p[i] <= r2.q[i] && r2.p[i] <= r1.q[i]) {
 			return false
 		}
 	}
 	return true
 }
 
+// sweptInterval computes the range [lo, hi] of the times t in [0, 1] at
+// which lerpRect(start, end, t) touches r, and reports whether there are
+// any.  If the box and r overlap at all, they do so at the middle of the
+// range, unless they only ever meet on a face.
+func sweptInterval(start, end, r *Rect) (lo, hi float64, ok bool) {
+	lo, hi = 0, 1
+	for i := range r.p {
+		// the lower face must stay below the upper face of r
+		lo, hi = constrainBelow(lo, hi, start.p[i], end.p[i], r.q[i])
+		// and the upper face above the lower face of r
+		lo, hi = constrainBelow(lo, hi, -start.q[i], -end.q[i], -r.p[i])
+	}
+	return lo, hi, lo <= hi
+}
+
+// constrainBelow narrows [lo, hi] to the times t at which a coordinate
+// moving from v0 at t = 0 to v1 at t = 1 is at most bound.
+func constrainBelow(lo, hi, v0, v1, bound float64) (float64, float64) {
+	switch dv := v1 - v0; {
+	case dv > 0:
+		hi = min(hi, (bound-v0)/dv)
+	case dv < 0:
+		lo = max(lo, (bound-v0)/dv)
+	case !(v0 <= bound):
+		return 1, 0
+	}
+	return lo, hi
+}
+
+// lerpRect returns the rectangle whose corners lie the fraction t of the way
+// from those of r1 to those of r2.
+func lerpRect(r1, r2 *Rect, t float64) Rect {
+	var r Rect
+	for i := range r.p {
+		r.p[i] = (1-t)*r1.p[i] + t*r2.p[i]
+		r.q[i] = (1-t)*r1.q[i] + t*r2.q[i]
+	}
+	return r
+}
+
+// isNaN is a cheaper math.IsNaN for the comparisons above.
+func isNaN(f float64) bool {
+	return f != f
+}
+
 // ToRect constructs a rectangle containing p with side lengths 2*tol.
 func (p Point) ToRect(tol float64) *Rect {
 	var r Rect
@@ -271,6 +871,32 @@ func (p Point) ToRect(tol float64) *Rect {
 	return &r
 }
 
+// transformedBoundingBox computes the smallest rectangle containing the
+// images of the corners of r under fn.
+func transformedBoundingBox(r *Rect, fn func(Point) Point) *Rect {
+	var bb Rect
+	for c := 0; c < 1<<Dim; c++ {
+		var corner Point
+		for i := range corner {
+			if c&(1<<uint(i)) == 0 {
+				corner[i] = r.p[i]
+			} else {
+				corner[i] = r.q[i]
+			}
+		}
+		image := fn(corner)
+		if c == 0 {
+			bb.p, bb.q = image, image
+			continue
+		}
+		for i, a := range image {
+			bb.p[i] = math.Min(bb.p[i], a)
+			bb.q[i] = math.Max(bb.q[i], a)
+		}
+	}
+	return &bb
+}
+
 func initBoundingBox(r, r1, r2 *Rect) {
 	*r = *r1
 	r.enlarge(r2)
@@ -283,6 +909,14 @@ func boundingBox(r1, r2 *Rect) *Rect {
 	return &r
 }
 
+// unionSize computes the size of the smallest rectangle containing both r1
+// and r2 without allocating it.
+func unionSize(r1, r2 *Rect) float64 {
+	var r Rect
+	initBoundingBox(&r, r1, r2)
+	return r.size()
+}
+
 // boundingBoxN constructs the smallest rectangle containing all of r...
 func boundingBoxN(rects ...*Rect) (bb *Rect) {
 	if len(rects) == 1 {
@@ -291,7 +925,26 @@ func boundingBoxN(rects ...*Rect) (bb *Rect) {
 	}
 	bb = boundingBox(rects[0], rects[1])
 	for _, rect := range rects[2:] {
-		bb = boundingBox(bb, rect)
+		bb.enlarge(rect)
 	}
 	return
 }
+
+// Union returns the smallest rectangle containing both r and other.
+func (r *Rect) Union(other *Rect) *Rect {
+	return boundingBox(r, other)
+}
+
+// Union returns the smallest rectangle containing all of rects, or nil if
+// there are none.  The result is always a new rectangle, even for a single
+// argument.
+func Union(rects ...*Rect) *Rect {
+	if len(rects) == 0 {
+		return nil
+	}
+	bb := *rects[0]
+	for _, rect := range rects[1:] {
+		bb.enlarge(rect)
+	}
+	return &bb
+}
@@ -5,7 +5,9 @@
 package rtreego
 
 import (
+	"errors"
 	"math"
+	"math/rand"
 	"testing"
 )
 
@@ -44,6 +46,47 @@ func TestNewRectDistError(t *testing.T) {
 	if _, ok := err.(DistError); !ok {
 		t.Errorf("Expected distError on NewRect(%v, %v)", p, lengths)
 	}
+	if expected := (DistError{1, -8}); err != expected {
+		t.Errorf("NewRect(%v, %v) returned %#v; expected %#v", p, lengths, err, expected)
+	}
+	if msg := err.Error(); msg != "rtreego: negative length -8 in dimension 1" {
+		t.Errorf("DistError message is %q", msg)
+	}
+
+	_, err = NewRectFromPoints(Point{0, 0, 0}, Point{1, 1, math.NaN()})
+	if expected := (DistError{2, math.NaN()}); err == nil || err.(DistError).Dimension != expected.Dimension || !math.IsNaN(err.(DistError).Length) {
+		t.Errorf("NewRectFromPoints with a NaN coordinate returned %#v; expected %#v", err, expected)
+	}
+	if msg := err.Error(); msg != "rtreego: NaN coordinate in dimension 2" {
+		t.Errorf("DistError message is %q", msg)
+	}
+}
+
+func TestNewRectErrorKinds(t *testing.T) {
+	tests := []struct {
+		p       Point
+		lengths [Dim]float64
+		err     error
+	}{
+		{Point{1, 2, 3}, [Dim]float64{1, -0.5, 1}, ErrZeroLength},
+		{Point{1, 2, 3}, [Dim]float64{1, 1, -2}, ErrZeroLength},
+		{Point{1, 2, 3}, [Dim]float64{math.NaN(), 1, 1}, ErrNaNCoordinate},
+		{Point{1, math.NaN(), 3}, [Dim]float64{1, 1, 1}, ErrNaNCoordinate},
+	}
+	for _, test := range tests {
+		_, err := NewRect(test.p, test.lengths)
+		if _, ok := err.(DistError); !ok {
+			t.Errorf("NewRect(%v, %v) returned %v; expected a DistError", test.p, test.lengths, err)
+		}
+		if !errors.Is(err, test.err) {
+			t.Errorf("NewRect(%v, %v) returned %v; expected %v", test.p, test.lengths, err, test.err)
+		}
+		for _, other := range []error{ErrZeroLength, ErrNaNCoordinate, ErrDimMismatch} {
+			if other != test.err && errors.Is(err, other) {
+				t.Errorf("NewRect(%v, %v) returned an error matching %v", test.p, test.lengths, other)
+			}
+		}
+	}
 }
 
 func TestRectPointCoord(t *testing.T) {
@@ -90,6 +133,21 @@ func TestRectEqual(t *testing.T) {
 	}
 }
 
+func TestRectEqualWithin(t *testing.T) {
+	a := mustRect(Point{0.1, -2.5, 3}, [Dim]float64{0.2, 8, 1.5})
+	// 0.1+0.2 isn't exactly 0.3
+	b := &Rect{Point{0.1, -2.5, 3}, Point{0.3, 5.5, 4.5}}
+	if a.Equal(b) || !a.EqualWithin(b, 1e-12) {
+		t.Errorf("%v and %v differ by rounding only", a, b)
+	}
+	if c := a.Translate(Point{0, 0, 1e-3}); a.EqualWithin(c, 1e-4) || !a.EqualWithin(c, 1e-2) {
+		t.Errorf("EqualWithin of %v and %v disregards epsilon", a, c)
+	}
+	if nan := (&Rect{Point{math.NaN(), -2.5, 3}, b.q}); a.EqualWithin(nan, 1) || nan.EqualWithin(nan, 1) {
+		t.Errorf("EqualWithin accepted %v", nan)
+	}
+}
+
 func TestRectSize(t *testing.T) {
 	p := Point{1.0, -2.5, 3.0}
 	lengths := [Dim]float64{2.5, 8.0, 1.5}
@@ -99,6 +157,9 @@ func TestRectSize(t *testing.T) {
 	if size != actual {
 		t.Errorf("Expected %v.size() == %v, got %v", rect, size, actual)
 	}
+	if rect.Size() != actual {
+		t.Errorf("Expected %v.Size() == %v, got %v", rect, actual, rect.Size())
+	}
 }
 
 func TestRectMargin(t *testing.T) {
@@ -110,6 +171,9 @@ func TestRectMargin(t *testing.T) {
 	if size != actual {
 		t.Errorf("Expected %v.margin() == %v, got %v", rect, size, actual)
 	}
+	if rect.Margin() != actual {
+		t.Errorf("Expected %v.Margin() == %v, got %v", rect, actual, rect.Margin())
+	}
 }
 
 func TestContainsPoint(t *testing.T) {
@@ -176,6 +240,43 @@ func TestDoesNotContainRectDisjoint(t *testing.T) {
 	}
 }
 
+func TestContainsPublic(t *testing.T) {
+	r := mustRect(Point{0, 0, 0}, [Dim]float64{2, 2, 2})
+	for _, tc := range []struct {
+		p        Point
+		expected bool
+	}{
+		{Point{1, 1, 1}, true},
+		{Point{0, 2, 1}, true}, // on the boundary
+		{Point{2.5, 1, 1}, false},
+		{Point{math.NaN(), 1, 1}, false},
+	} {
+		if got := r.ContainsPoint(tc.p); got != tc.expected {
+			t.Errorf("%v.ContainsPoint(%v) = %v; expected %v", r, tc.p, got, tc.expected)
+		}
+	}
+	for _, tc := range []struct {
+		other    *Rect
+		expected bool
+	}{
+		{mustRect(Point{0.5, 0.5, 0.5}, [Dim]float64{1, 1, 1}), true},
+		{r, true},
+		{&Rect{Point{2, 0, 0}, Point{2, 1, 1}}, true}, // on a face
+		{mustRect(Point{1, 1, 1}, [Dim]float64{2, 1, 1}), false},
+		{&Rect{Point{1, math.NaN(), 1}, Point{1, 1, 1}}, false},
+	} {
+		if got := r.ContainsRect(tc.other); got != tc.expected {
+			t.Errorf("%v.ContainsRect(%v) = %v; expected %v", r, tc.other, got, tc.expected)
+		}
+	}
+	// a rectangle sharing only a face with r contains points of r, but
+	// doesn't intersect it
+	face := mustRect(Point{2, 0, 0}, [Dim]float64{1, 2, 2})
+	if !face.ContainsPoint(Point{2, 1, 1}) || face.Intersects(r) {
+		t.Errorf("containment and intersection agree on %v and %v", face, r)
+	}
+}
+
 func TestNoIntersection(t *testing.T) {
 	p := Point{1, 2, 3}
 	lengths1 := [Dim]float64{1, 1, 1}
@@ -208,6 +309,32 @@ func TestNoIntersectionJustTouches(t *testing.T) {
 	}
 }
 
+func TestDegenerateIntersection(t *testing.T) {
+	box := mustRect(Point{0, 0, 0}, [Dim]float64{4, 4, 4})
+	point, _ := NewRect(Point{2, 2, 2}, [Dim]float64{})
+	onFace, _ := NewRect(Point{4, 1, 1}, [Dim]float64{0, 2, 2})
+	onEdge, _ := NewRect(Point{4, 4, 1}, [Dim]float64{})
+	outside, _ := NewRect(Point{4.5, 1, 1}, [Dim]float64{0, 2, 2})
+
+	for _, r := range []*Rect{&point, &onFace, &onEdge} {
+		if !intersect(box, r) || !intersect(r, box) {
+			t.Errorf("Expected %v to intersect %v", r, box)
+		}
+		if !box.containsRect(r) {
+			t.Errorf("Expected %v to contain %v", box, r)
+		}
+	}
+	if !intersect(&point, &point) {
+		t.Errorf("Expected point %v to intersect itself", &point)
+	}
+	if intersect(box, &outside) {
+		t.Errorf("Expected intersect(%v, %v) == false", box, &outside)
+	}
+	if !box.containsPoint(Point{4, 2, 0}) {
+		t.Errorf("Expected %v to contain a point on its boundary", box)
+	}
+}
+
 func TestContainmentIntersection(t *testing.T) {
 	p := Point{1, 2, 3}
 	lengths1 := [Dim]float64{1, 1, 1}
@@ -329,3 +456,491 @@ func TestMinMaxdist(t *testing.T) {
 		t.Errorf("Expected %v.minMaxDist(%v) == %v, got %v", p, r, expected, d)
 	}
 }
+
+func TestMaxDist(t *testing.T) {
+	p := Point{-1, 2, 3}
+	r := mustRect(Point{0, 0, 0}, [Dim]float64{2, 1, 1})
+	if d := p.maxDist(r); math.Abs(d-22) > EPS {
+		t.Errorf("maxDist(%v, %v) = %v; expected 22", p, r, d)
+	}
+	inside := Point{1, 0.5, 0.5}
+	if d := inside.maxDist(r); math.Abs(d-1.5) > EPS {
+		t.Errorf("maxDist(%v, %v) = %v; expected 1.5", inside, r, d)
+	}
+}
+
+func TestTransformedBoundingBox(t *testing.T) {
+	r := mustRect(Point{1, 2, 3}, [Dim]float64{1, 2, 3})
+	swapXY := func(p Point) Point { return Point{p[1], -p[0], p[2]} }
+	bb := transformedBoundingBox(r, swapXY)
+	expected := mustRect(Point{2, -2, 3}, [Dim]float64{2, 1, 3})
+	if !bb.Equal(expected) {
+		t.Errorf("transformedBoundingBox(%v) = %v; expected %v", r, bb, expected)
+	}
+}
+
+func TestRectCheck(t *testing.T) {
+	if err := mustRect(Point{1, 2, 3}, [Dim]float64{1, 2, 3}).check(); err != nil {
+		t.Errorf("check() rejected a valid rect: %v", err)
+	}
+	if err := (Point{1, 2, 3}).ToRect(0).check(); err != nil {
+		t.Errorf("check() rejected a degenerate rect: %v", err)
+	}
+	inverted := Rect{Point{0, 0, 0}, Point{1, -1, 1}}
+	if _, ok := inverted.check().(DistError); !ok {
+		t.Errorf("check() accepted inverted rect %v", &inverted)
+	}
+	nan := Rect{Point{0, 0, math.NaN()}, Point{1, 1, 1}}
+	if _, ok := nan.check().(DistError); !ok {
+		t.Errorf("check() accepted rect with a NaN coordinate")
+	}
+}
+
+func TestOverlapVolume(t *testing.T) {
+	r1 := mustRect(Point{0, 0, 0}, [Dim]float64{4, 4, 4})
+	tests := []struct {
+		r2       *Rect
+		expected float64
+	}{
+		{mustRect(Point{2, 2, 2}, [Dim]float64{4, 4, 4}), 8},
+		{mustRect(Point{1, 1, 1}, [Dim]float64{1, 2, 3}), 6},
+		{mustRect(Point{-1, -1, -1}, [Dim]float64{6, 6, 6}), 64},
+		{mustRect(Point{4, 0, 0}, [Dim]float64{1, 1, 1}), 0},
+		{mustRect(Point{5, 5, 5}, [Dim]float64{1, 1, 1}), 0},
+	}
+	for _, test := range tests {
+		if v := OverlapVolume(r1, test.r2); v != test.expected {
+			t.Errorf("OverlapVolume(%v, %v) = %v; expected %v", r1, test.r2, v, test.expected)
+		}
+		if v := OverlapVolume(test.r2, r1); v != test.expected {
+			t.Errorf("OverlapVolume(%v, %v) = %v; expected %v", test.r2, r1, v, test.expected)
+		}
+	}
+}
+
+func TestRectSnap(t *testing.T) {
+	r := mustRect(Point{0.3, -1.2, 2}, [Dim]float64{1.4, 0.1, 0.5})
+	snapped := r.Snap(0.5)
+	expected := &Rect{Point{0, -1.5, 2}, Point{2, -1, 2.5}}
+	if !snapped.Equal(expected) {
+		t.Errorf("Snap(0.5) of %v = %v; expected %v", r, snapped, expected)
+	}
+	if !snapped.containsRect(r) {
+		t.Errorf("Snap(0.5) of %v = %v does not contain it", r, snapped)
+	}
+	if !r.Equal(mustRect(Point{0.3, -1.2, 2}, [Dim]float64{1.4, 0.1, 0.5})) {
+		t.Errorf("Snap modified its receiver")
+	}
+
+	noisy := mustRect(Point{0.30000000001, -1.2, 2}, [Dim]float64{1.4, 0.1, 0.49999999})
+	if !noisy.Snap(0.5).Equal(snapped) {
+		t.Errorf("Snap(0.5) of nearly equal rects differs: %v and %v", noisy.Snap(0.5), snapped)
+	}
+
+	for _, cellSize := range []float64{0, -1, math.NaN()} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Snap(%v) did not panic", cellSize)
+				}
+			}()
+			r.Snap(cellSize)
+		}()
+	}
+}
+
+func TestRectExpand(t *testing.T) {
+	r := mustRect(Point{0, -2, 5}, [Dim]float64{4, 1, 2})
+	if got, expected := r.Expand(1.5), (&Rect{Point{-1.5, -3.5, 3.5}, Point{5.5, 0.5, 8.5}}); !got.Equal(expected) {
+		t.Errorf("Expand(1.5) of %v = %v; expected %v", r, got, expected)
+	}
+	if got, expected := r.ExpandBy([Dim]float64{1, 0, -0.5}), (&Rect{Point{-1, -2, 5.5}, Point{5, -1, 6.5}}); !got.Equal(expected) {
+		t.Errorf("ExpandBy of %v = %v; expected %v", r, got, expected)
+	}
+	// axes that would be inverted collapse to their midpoints
+	if got, expected := r.Expand(-1), (&Rect{Point{1, -1.5, 6}, Point{3, -1.5, 6}}); !got.Equal(expected) {
+		t.Errorf("Expand(-1) of %v = %v; expected %v", r, got, expected)
+	}
+	if !r.Equal(mustRect(Point{0, -2, 5}, [Dim]float64{4, 1, 2})) {
+		t.Errorf("Expand modified its receiver")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Expand(NaN) did not panic")
+		}
+	}()
+	r.Expand(math.NaN())
+}
+
+func TestRectAsSpatial(t *testing.T) {
+	// equal rectangles are distinct objects
+	r1 := mustRect(Point{1, 2, 3}, [Dim]float64{1, 1, 1})
+	r2 := mustRect(Point{1, 2, 3}, [Dim]float64{1, 1, 1})
+	if r1.Bounds() != r1 {
+		t.Errorf("Bounds of %v returned another rectangle", r1)
+	}
+	rt := NewTree(3, 6)
+	rt.Insert(r1)
+	rt.Insert(r2)
+	if !rt.Delete(r2) || rt.Delete(r2) {
+		t.Errorf("Delete didn't remove %v exactly once", r2)
+	}
+	if found := rt.SearchIntersect(r1); len(found) != 1 || found[0] != Spatial(r1) {
+		t.Errorf("SearchIntersect found %v; expected only the other rectangle", found)
+	}
+}
+
+func TestUnboundedRect(t *testing.T) {
+	all := UnboundedRect()
+	r := mustRect(Point{-1e300, 2, 3}, [Dim]float64{1e300, 1, 1})
+	flat := &Rect{Point{500, 0, 0}, Point{500, 1, 1}}
+	if !intersect(all, r) || !intersect(r, all) || !all.containsRect(r) || !all.ContainsPoint(Point{1, 2, 3}) {
+		t.Errorf("%v doesn't cover %v", all, r)
+	}
+
+	above := all.WithBounds(0, 500, math.Inf(1))
+	for _, tc := range []struct {
+		r        *Rect
+		expected bool
+	}{
+		{mustRect(Point{600, -1e10, 0}, [Dim]float64{1, 1, 1}), true},
+		{mustRect(Point{499, 0, 0}, [Dim]float64{2, 1, 1}), true},
+		{flat, true},
+		{mustRect(Point{499, 0, 0}, [Dim]float64{1, 1, 1}), false}, // shares a face
+		{r, false},
+		{above, true},
+	} {
+		if got := intersect(above, tc.r); got != tc.expected {
+			t.Errorf("intersect(%v, %v) = %v; expected %v", above, tc.r, got, tc.expected)
+		}
+	}
+	if !above.containsRect(flat) || above.containsRect(all) || !all.containsRect(above) {
+		t.Errorf("containment of half-infinite rectangles is wrong")
+	}
+
+	// NewRect can make rectangles unbounded above, but not below
+	if up, err := NewRect(Point{500, 0, 0}, [Dim]float64{math.Inf(1), 1, 1}); err != nil || !up.containsRect(flat) {
+		t.Errorf("NewRect of an infinite length = %v, %v", &up, err)
+	}
+	if _, err := NewRect(Point{math.Inf(-1), 0, 0}, [Dim]float64{math.Inf(1), 1, 1}); !errors.Is(err, ErrNaNCoordinate) {
+		t.Errorf("NewRect with a NaN corner returned %v; expected ErrNaNCoordinate", err)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("WithBounds with inverted bounds didn't panic")
+		}
+	}()
+	all.WithBounds(1, 2, 1)
+}
+
+func TestRectTranslateScale(t *testing.T) {
+	r := mustRect(Point{0, -2, 5}, [Dim]float64{4, 1, 2})
+	if got, expected := r.Translate(Point{1, 2, -5}), (&Rect{Point{1, 0, 0}, Point{5, 1, 2}}); !got.Equal(expected) {
+		t.Errorf("Translate of %v = %v; expected %v", r, got, expected)
+	}
+	if got, expected := r.Scale([Dim]float64{2, 1, 0.5}), (&Rect{Point{0, -2, 2.5}, Point{8, -1, 3.5}}); !got.Equal(expected) {
+		t.Errorf("Scale of %v = %v; expected %v", r, got, expected)
+	}
+	// a negative factor mirrors the axis
+	if got, expected := r.Scale([Dim]float64{-1, -2, 0}), (&Rect{Point{-4, 2, 0}, Point{0, 4, 0}}); !got.Equal(expected) {
+		t.Errorf("Scale of %v = %v; expected %v", r, got, expected)
+	}
+	if !r.Equal(mustRect(Point{0, -2, 5}, [Dim]float64{4, 1, 2})) {
+		t.Errorf("Translate or Scale modified its receiver")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Scale by NaN did not panic")
+		}
+	}()
+	r.Scale([Dim]float64{1, math.NaN(), 1})
+}
+
+func TestNaNGeometry(t *testing.T) {
+	nan := math.NaN()
+	r := mustRect(Point{0, 0, 0}, [Dim]float64{2, 2, 2})
+	bad := &Rect{Point{0, nan, 0}, Point{1, 1, 1}}
+	p := Point{1, 1, 1}
+
+	if intersect(r, bad) || intersect(bad, r) || intersect(bad, bad) {
+		t.Errorf("intersect reported an intersection with %v", bad)
+	}
+	if d := p.minDist(bad); !math.IsNaN(d) {
+		t.Errorf("minDist to %v = %v; expected NaN", bad, d)
+	}
+	if d := (Point{nan, 0, 0}).minDist(r); !math.IsNaN(d) {
+		t.Errorf("minDist from a NaN point = %v; expected NaN", d)
+	}
+	if d := p.minMaxDist(bad); !math.IsNaN(d) {
+		t.Errorf("minMaxDist to %v = %v; expected NaN", bad, d)
+	}
+	if d := (Point{nan, 0, 0}).minMaxDist(r); !math.IsNaN(d) {
+		t.Errorf("minMaxDist from a NaN point = %v; expected NaN", d)
+	}
+
+	other := mustRect(Point{3, -1, 1}, [Dim]float64{1, 1, 1})
+	expected := &Rect{Point{0, -1, 0}, Point{4, 2, 2}}
+	for _, rects := range [][]*Rect{{r, bad, other}, {bad, r, other}, {r, other, bad}} {
+		if bb := boundingBoxN(rects...); !bb.Equal(expected) {
+			t.Errorf("boundingBoxN(%v) = %v; expected %v", rects, bb, expected)
+		}
+	}
+}
+
+func TestSortRectsByDist(t *testing.T) {
+	p := Point{50, 50, 50}
+	var rects []*Rect
+	for _, obj := range randomRects(100, 24) {
+		rects = append(rects, obj.Bounds())
+	}
+	SortRectsByDist(p, rects)
+	for i := 1; i < len(rects); i++ {
+		if p.minDist(rects[i-1]) > p.minDist(rects[i]) {
+			t.Fatalf("SortRectsByDist left %v before %v", rects[i-1], rects[i])
+		}
+	}
+	if allocs := testing.AllocsPerRun(10, func() { SortRectsByDist(Point{1, 2, 3}, rects) }); allocs != 0 {
+		t.Errorf("SortRectsByDist made %v allocations; expected none", allocs)
+	}
+}
+
+func TestSphereInCone(t *testing.T) {
+	apex, dir := Point{0, 0, 0}, Point{2, 0, 0}
+	tests := []struct {
+		r        *Rect
+		expected bool
+	}{
+		{mustRect(Point{5, -0.5, -0.5}, [Dim]float64{1, 1, 1}), true},
+		{mustRect(Point{-6, -0.5, -0.5}, [Dim]float64{1, 1, 1}), false},
+		{mustRect(Point{5, 10, -0.5}, [Dim]float64{1, 1, 1}), false},
+		{mustRect(Point{10, 4, -0.5}, [Dim]float64{1, 1, 1}), true},
+		{mustRect(Point{-1, -1, -1}, [Dim]float64{2, 2, 2}), true},
+		{mustRect(Point{0.5, 30, 0}, [Dim]float64{1, 1, 1}), false},
+	}
+	for _, test := range tests {
+		if got := test.r.sphereInCone(apex, dir, math.Pi/8); got != test.expected {
+			t.Errorf("sphereInCone(%v) = %v; expected %v", test.r, got, test.expected)
+		}
+	}
+	behind := mustRect(Point{-6, -0.5, -0.5}, [Dim]float64{1, 1, 1})
+	if !behind.sphereInCone(apex, dir, math.Pi) {
+		t.Errorf("a cone with half-angle pi missed %v", behind)
+	}
+}
+
+func TestRectInCone(t *testing.T) {
+	apex, dir := Point{0, 0, 0}, Point{2, 0, 0}
+	tests := []struct {
+		r         *Rect
+		halfAngle float64
+		expected  bool
+	}{
+		{mustRect(Point{5, -0.5, -0.5}, [Dim]float64{1, 1, 1}), math.Pi / 8, true},
+		{mustRect(Point{-6, -0.5, -0.5}, [Dim]float64{1, 1, 1}), math.Pi / 8, false},
+		{mustRect(Point{10, 4, -0.5}, [Dim]float64{1, 1, 1}), math.Pi / 8, true},
+		{mustRect(Point{-1, -1, -1}, [Dim]float64{2, 2, 2}), math.Pi / 8, true},
+		// the cone passes through a face without touching its edges
+		{mustRect(Point{10, -5, -5}, [Dim]float64{1, 10, 10}), math.Pi / 8, true},
+		// crossing the cone's axis is enough
+		{mustRect(Point{3, -0.1, -0.1}, [Dim]float64{0, 0.2, 0.2}), 0.01, true},
+		// the circumscribing sphere reaches into the cone, but the box doesn't
+		{mustRect(Point{10, 5, -10}, [Dim]float64{1, 0.1, 20}), math.Pi / 8, false},
+		{mustRect(Point{-6, -0.5, -0.5}, [Dim]float64{1, 1, 1}), math.Pi / 2, false},
+		{mustRect(Point{-6, 5, -0.5}, [Dim]float64{1, 1, 1}), 3 * math.Pi / 4, true},
+		{mustRect(Point{-6, -0.5, -0.5}, [Dim]float64{1, 1, 1}), math.Pi, true},
+	}
+	for _, test := range tests {
+		if got := test.r.inCone(apex, dir, test.halfAngle); got != test.expected {
+			t.Errorf("inCone(%v, %v) = %v; expected %v", test.r, test.halfAngle, got, test.expected)
+		}
+	}
+
+	// every box with a sampled point in the cone is found, and no box
+	// outside the circumscribing sphere test
+	rng := rand.New(rand.NewSource(49))
+	apex, dir = Point{50, 50, 50}, Point{1, 2, -1}
+	for _, bb := range randomRects(300, 50) {
+		r := bb.Bounds()
+		for _, halfAngle := range []float64{0.1, 0.5, 2} {
+			got := r.inCone(apex, dir, halfAngle)
+			if got && !r.sphereInCone(apex, dir, halfAngle) {
+				t.Errorf("inCone(%v, %v) is true outside the circumscribing sphere", r, halfAngle)
+			}
+			for range 200 {
+				var p Point
+				for i := range p {
+					p[i] = r.p[i] + rng.Float64()*(r.q[i]-r.p[i])
+				}
+				if mustRect(p, [Dim]float64{}).inCone(apex, dir, halfAngle) && !got {
+					t.Errorf("inCone(%v, %v) = false; expected true for %v inside it", r, halfAngle, p)
+					break
+				}
+			}
+		}
+	}
+}
+
+func TestRayEntry(t *testing.T) {
+	origin, dir := Point{0, 0, 0}, Point{2, 1, 0}
+	tests := []struct {
+		r     *Rect
+		hit   bool
+		entry float64
+	}{
+		{mustRect(Point{4, 0, -1}, [Dim]float64{2, 4, 2}), true, 2},
+		{mustRect(Point{2, 3, -1}, [Dim]float64{2, 2, 2}), false, 0},
+		{mustRect(Point{-6, -3, -1}, [Dim]float64{2, 2, 2}), false, 0},
+		{mustRect(Point{-1, -1, -1}, [Dim]float64{2, 2, 2}), true, 0},
+		{mustRect(Point{4, 2, 0}, [Dim]float64{2, 2, 2}), true, 2},
+		{mustRect(Point{4, 0, 1}, [Dim]float64{2, 4, 2}), false, 0},
+	}
+	for _, test := range tests {
+		entry, hit := test.r.rayEntry(origin, dir)
+		if hit != test.hit || hit && entry != test.entry {
+			t.Errorf("rayEntry(%v) = %v, %v; expected %v, %v", test.r, entry, hit, test.entry, test.hit)
+		}
+	}
+}
+
+func TestRectIntersects(t *testing.T) {
+	box := mustRect(Point{0, 0, 0}, [Dim]float64{2, 2, 2})
+	tests := []struct {
+		r        *Rect
+		expected bool
+	}{
+		{mustRect(Point{1, 1, 1}, [Dim]float64{3, 3, 3}), true},
+		{mustRect(Point{0.5, 0.5, 0.5}, [Dim]float64{1, 1, 1}), true},
+		{mustRect(Point{2, 0, 0}, [Dim]float64{1, 2, 2}), false},
+		{mustRect(Point{2, 2, 2}, [Dim]float64{1, 1, 1}), false},
+		{mustRect(Point{3, 0, 0}, [Dim]float64{1, 1, 1}), false},
+		{Point{2, 1, 1}.ToRect(0), true},
+		{&Rect{Point{2, 0, 0}, Point{2, 2, 2}}, true},
+	}
+	for _, test := range tests {
+		if got := box.Intersects(test.r); got != test.expected {
+			t.Errorf("%v.Intersects(%v) = %v; expected %v", box, test.r, got, test.expected)
+		}
+		if got := test.r.Intersects(box); got != test.expected {
+			t.Errorf("%v.Intersects(%v) = %v; expected %v", test.r, box, got, test.expected)
+		}
+	}
+}
+
+func TestIntersection(t *testing.T) {
+	r := mustRect(Point{0, 0, 0}, [Dim]float64{4, 4, 4})
+	tests := []struct {
+		other    *Rect
+		expected *Rect
+	}{
+		{mustRect(Point{2, 1, -1}, [Dim]float64{4, 2, 2}), &Rect{Point{2, 1, 0}, Point{4, 3, 1}}},
+		{mustRect(Point{1, 1, 1}, [Dim]float64{1, 1, 1}), mustRect(Point{1, 1, 1}, [Dim]float64{1, 1, 1})},
+		{mustRect(Point{4, 0, 0}, [Dim]float64{1, 1, 1}), &Rect{Point{4, 0, 0}, Point{4, 1, 1}}},
+		{mustRect(Point{4, 4, 4}, [Dim]float64{1, 1, 1}), &Rect{Point{4, 4, 4}, Point{4, 4, 4}}},
+		{mustRect(Point{5, 0, 0}, [Dim]float64{1, 1, 1}), nil},
+		{&Rect{Point{1, math.NaN(), 1}, Point{2, 2, 2}}, nil},
+	}
+	for _, test := range tests {
+		in, ok := r.Intersection(test.other)
+		if ok != (test.expected != nil) {
+			t.Errorf("Intersection(%v) reported overlap %v", test.other, ok)
+			continue
+		}
+		if ok && !in.Equal(test.expected) {
+			t.Errorf("Intersection(%v) = %v; expected %v", test.other, in, test.expected)
+		}
+	}
+
+	in, _ := r.Intersection(mustRect(Point{2, 2, 2}, [Dim]float64{4, 4, 4}))
+	if in.size() != OverlapVolume(r, mustRect(Point{2, 2, 2}, [Dim]float64{4, 4, 4})) {
+		t.Errorf("Intersection has volume %v; expected %v", in.size(), 8.0)
+	}
+}
+
+func TestNewRectClamped(t *testing.T) {
+	p := Point{1, 2, 3}
+	r := NewRectClamped(p, [Dim]float64{2, -1e-12, math.NaN()}, 0.5)
+	if expected := (&Rect{p, Point{3, 2.5, 3.5}}); !r.Equal(expected) {
+		t.Errorf("NewRectClamped returned %v; expected %v", &r, expected)
+	}
+	if err := r.check(); err != nil {
+		t.Errorf("NewRectClamped returned an invalid rect: %v", err)
+	}
+	if r := NewRectClamped(p, [Dim]float64{0, -3, 1}, 0); !r.Equal(&Rect{p, Point{1, 2, 4}}) {
+		t.Errorf("NewRectClamped with minLen 0 returned %v; expected a flat rect", &r)
+	}
+
+	for _, test := range []struct {
+		p      Point
+		minLen float64
+	}{
+		{Point{math.NaN(), 0, 0}, 1},
+		{p, -1},
+		{p, math.NaN()},
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("NewRectClamped(%v, minLen %v) did not panic", test.p, test.minLen)
+				}
+			}()
+			NewRectClamped(test.p, [Dim]float64{1, 1, 1}, test.minLen)
+		}()
+	}
+}
+
+func TestNewRectFromPoints(t *testing.T) {
+	r, err := NewRectFromPoints(Point{3, -1, 2}, Point{1, 4, 2.5})
+	if err != nil {
+		t.Fatalf("NewRectFromPoints failed: %v", err)
+	}
+	if expected := (Rect{Point{1, -1, 2}, Point{3, 4, 2.5}}); r != expected {
+		t.Errorf("NewRectFromPoints = %v; expected %v", &r, &expected)
+	}
+	if flat, err := NewRectFromPoints(Point{1, 2, 3}, Point{4, 2, 6}); err != nil || flat.LengthsCoord(1) != 0 {
+		t.Errorf("NewRectFromPoints of coinciding corners = %v, %v", &flat, err)
+	}
+	if _, err := NewRectFromPoints(Point{1, 2, 3}, Point{4, 5, math.NaN()}); !errors.Is(err, ErrNaNCoordinate) {
+		t.Errorf("NewRectFromPoints of a NaN corner returned %v", err)
+	}
+}
+
+func TestRectCenterAndCorners(t *testing.T) {
+	r := mustRect(Point{1, -2, 3}, [Dim]float64{4, 2, 1})
+	if c := r.Center(); c != (Point{3, -1, 3.5}) {
+		t.Errorf("Center of %v = %v", r, c)
+	}
+	p, q := r.Corners()
+	if p != (Point{1, -2, 3}) || q != (Point{5, 0, 4}) {
+		t.Errorf("Corners of %v = %v, %v", r, p, q)
+	}
+	p[0], q[0] = 100, 200
+	if r.PointCoord(0) != 1 || r.LengthsCoord(0) != 4 {
+		t.Errorf("changing the corners returned by Corners changed the rectangle to %v", r)
+	}
+}
+
+func TestUnion(t *testing.T) {
+	r1 := mustRect(Point{0, 0, 0}, [Dim]float64{2, 2, 2})
+	r2 := mustRect(Point{1, -3, 1}, [Dim]float64{4, 1, 1})
+	r3 := mustRect(Point{-1, 5, 0}, [Dim]float64{1, 1, 6})
+	before1, before2 := *r1, *r2
+
+	if u := r1.Union(r2); *u != (Rect{Point{0, -3, 0}, Point{5, 2, 2}}) {
+		t.Errorf("%v.Union(%v) = %v", r1, r2, u)
+	}
+	if *r1 != before1 || *r2 != before2 {
+		t.Errorf("Union changed its arguments to %v and %v", r1, r2)
+	}
+	if u := Union(r1, r2, r3); *u != (Rect{Point{-1, -3, 0}, Point{5, 6, 6}}) {
+		t.Errorf("Union(%v, %v, %v) = %v", r1, r2, r3, u)
+	}
+	if u := Union(r1); u == r1 || *u != *r1 {
+		t.Errorf("Union(%v) = %p, %v; expected a copy", r1, u, u)
+	}
+	if u := Union(); u != nil {
+		t.Errorf("Union() = %v; expected nil", u)
+	}
+}
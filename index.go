@@ -0,0 +1,98 @@
+// Copyright 2012 Daniel Connelly.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rtreego
+
+// IndexTree is an R-tree of integer keys, such as indices into a slice that
+// holds the actual objects.  Searches return the keys directly, without the
+// type assertions needed to unwrap them from Spatial results.
+type IndexTree struct {
+	tree *Rtree
+}
+
+// indexItem is the object stored in an IndexTree for each key.
+type indexItem struct {
+	bb    Rect
+	index int
+}
+
+func (item *indexItem) Bounds() *Rect {
+	return &item.bb
+}
+
+// NewIndexTree creates a new IndexTree with the specified branching factors.
+func NewIndexTree(MinChildren, MaxChildren int) *IndexTree {
+	return &IndexTree{NewTree(MinChildren, MaxChildren)}
+}
+
+// Size returns the number of keys in the tree.
+func (t *IndexTree) Size() int {
+	return t.tree.Size()
+}
+
+// Insert adds index to the tree with bounding box bb, which is copied.
+// The same key may be inserted several times, with the same or different
+// bounding boxes.
+func (t *IndexTree) Insert(index int, bb *Rect) {
+	t.tree.Insert(&indexItem{*bb, index})
+}
+
+// Delete removes index, inserted with bounding box bb, from the tree.  If it
+// is not found, ok is false; otherwise ok is true.  If the key was inserted
+// more than once with bb, only one copy is removed.
+func (t *IndexTree) Delete(index int, bb *Rect) (ok bool) {
+	probe := &indexItem{*bb, index}
+	sameIndex := func(obj1, obj2 Spatial) bool {
+		return obj2.(*indexItem).index == index && obj2.Bounds().Equal(bb)
+	}
+	leaf := t.tree.findLeaf(t.tree.root, probe, sameIndex)
+	if leaf == nil {
+		return false
+	}
+	for _, e := range leaf.entries {
+		if sameIndex(probe, e.obj) {
+			return t.tree.Delete(e.obj)
+		}
+	}
+	return false
+}
+
+// SearchIntersect returns the keys whose bounding boxes intersect bb.
+func (t *IndexTree) SearchIntersect(bb *Rect) []int {
+	return searchIntersectIndex(t.tree.root, bb, []int{})
+}
+
+func searchIntersectIndex(n *node, bb *Rect, results []int) []int {
+	for _, e := range n.entries {
+		if n.reaches(e.bb, bb) {
+			if n.leaf {
+				results = append(results, e.obj.(*indexItem).index)
+			} else {
+				results = searchIntersectIndex(e.child, bb, results)
+			}
+		}
+	}
+	return results
+}
+
+// NearestNeighbor returns the key whose bounding box is closest to p.  If the
+// tree is empty, ok is false.
+func (t *IndexTree) NearestNeighbor(p Point) (index int, ok bool) {
+	obj := t.tree.NearestNeighbor(p)
+	if obj == nil {
+		return 0, false
+	}
+	return obj.(*indexItem).index, true
+}
+
+// NearestNeighbors returns the keys of the k bounding boxes closest to p,
+// nearest first.
+func (t *IndexTree) NearestNeighbors(k int, p Point) []int {
+	objs := t.tree.NearestNeighbors(k, p)
+	indices := make([]int, len(objs))
+	for i, obj := range objs {
+		indices[i] = obj.(*indexItem).index
+	}
+	return indices
+}
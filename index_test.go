@@ -0,0 +1,66 @@
+// Copyright 2012 Daniel Connelly.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rtreego
+
+import (
+	"sort"
+	"testing"
+)
+
+func TestIndexTree(t *testing.T) {
+	objs := randomRects(200, 17)
+	it := NewIndexTree(3, 6)
+	rt := NewTree(3, 6)
+	for i, obj := range objs {
+		it.Insert(i, obj.Bounds())
+		rt.Insert(obj)
+	}
+	if it.Size() != len(objs) {
+		t.Errorf("IndexTree has size %d; expected %d", it.Size(), len(objs))
+	}
+
+	bb := mustRect(Point{20, 20, 20}, [Dim]float64{40, 40, 40})
+	indices := it.SearchIntersect(bb)
+	var expected []int
+	for _, obj := range rt.SearchIntersect(bb) {
+		expected = append(expected, indexOf(objs, obj))
+	}
+	sort.Ints(indices)
+	sort.Ints(expected)
+	if len(indices) != len(expected) {
+		t.Fatalf("SearchIntersect returned %d keys; expected %d", len(indices), len(expected))
+	}
+	for i := range expected {
+		if indices[i] != expected[i] {
+			t.Errorf("SearchIntersect()[%d] = %d; expected %d", i, indices[i], expected[i])
+		}
+	}
+
+	p := Point{50, 50, 50}
+	if i, ok := it.NearestNeighbor(p); !ok || objs[i] != rt.NearestNeighbor(p) {
+		t.Errorf("NearestNeighbor(%v) = %d, %v", p, i, ok)
+	}
+	nearest := it.NearestNeighbors(5, p)
+	for i, obj := range rt.NearestNeighbors(5, p) {
+		if objs[nearest[i]] != obj {
+			t.Errorf("NearestNeighbors()[%d] = %d; expected %v", i, nearest[i], obj)
+		}
+	}
+
+	if it.Delete(3, objs[4].Bounds()) {
+		t.Errorf("Delete removed a key with the wrong bounds")
+	}
+	for i, obj := range objs {
+		if !it.Delete(i, obj.Bounds()) {
+			t.Fatalf("Delete failed to remove key %d", i)
+		}
+	}
+	if it.Size() != 0 || len(it.SearchIntersect(bb)) != 0 {
+		t.Errorf("IndexTree not empty after deleting every key")
+	}
+	if _, ok := it.NearestNeighbor(p); ok {
+		t.Errorf("NearestNeighbor on an empty IndexTree succeeded")
+	}
+}
@@ -0,0 +1,60 @@
+// Copyright 2012 Daniel Connelly.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rtreego
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// MarshalJSON encodes p as an array of its coordinates.
+func (p Point) MarshalJSON() ([]byte, error) {
+	return json.Marshal([Dim]float64(p))
+}
+
+// UnmarshalJSON decodes an array of exactly Dim coordinates into p.  An
+// array of any other length is an ErrDimMismatch.
+func (p *Point) UnmarshalJSON(data []byte) error {
+	var coords []float64
+	if err := json.Unmarshal(data, &coords); err != nil {
+		return err
+	}
+	if len(coords) != Dim {
+		return fmt.Errorf("rtreego: point with %d coordinates: %w", len(coords), ErrDimMismatch)
+	}
+	copy(p[:], coords)
+	return nil
+}
+
+// jsonRect is the JSON form of a Rect.
+type jsonRect struct {
+	Min *Point `json:"min"`
+	Max *Point `json:"max"`
+}
+
+// MarshalJSON encodes r as an object with its most-negative and
+// most-positive corners, {"min": [...], "max": [...]}.
+func (r Rect) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonRect{&r.p, &r.q})
+}
+
+// UnmarshalJSON decodes an object of the form written by MarshalJSON into r.
+// Both corners are required, and if min exceeds max along any axis the
+// returned error is a DistError.
+func (r *Rect) UnmarshalJSON(data []byte) error {
+	var corners jsonRect
+	if err := json.Unmarshal(data, &corners); err != nil {
+		return err
+	}
+	if corners.Min == nil || corners.Max == nil {
+		return fmt.Errorf("rtreego: rectangle without both min and max corners")
+	}
+	decoded := Rect{*corners.Min, *corners.Max}
+	if err := decoded.check(); err != nil {
+		return err
+	}
+	*r = decoded
+	return nil
+}
@@ -0,0 +1,63 @@
+// Copyright 2012 Daniel Connelly.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rtreego
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestPointJSON(t *testing.T) {
+	p := Point{1, -2.5, 3}
+	data, err := json.Marshal(p)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	if string(data) != "[1,-2.5,3]" {
+		t.Errorf("Marshal(%v) = %s", p, data)
+	}
+	var decoded Point
+	if err := json.Unmarshal(data, &decoded); err != nil || decoded != p {
+		t.Errorf("Unmarshal(%s) = %v, %v; expected %v", data, decoded, err, p)
+	}
+
+	for _, bad := range []string{"[1,2]", "[1,2,3,4]"} {
+		if err := json.Unmarshal([]byte(bad), &decoded); !errors.Is(err, ErrDimMismatch) {
+			t.Errorf("Unmarshal(%s) returned %v; expected ErrDimMismatch", bad, err)
+		}
+	}
+}
+
+func TestRectJSON(t *testing.T) {
+	r := mustRect(Point{1, -2, 3}, [Dim]float64{4, 0.5, 0})
+	data, err := json.Marshal(r)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	if string(data) != `{"min":[1,-2,3],"max":[5,-1.5,4]}` {
+		t.Errorf("Marshal(%v) = %s", r, data)
+	}
+	if byValue, _ := json.Marshal(*r); string(byValue) != string(data) {
+		t.Errorf("Marshal of a Rect value = %s; expected %s", byValue, data)
+	}
+	var decoded Rect
+	if err := json.Unmarshal(data, &decoded); err != nil || decoded != *r {
+		t.Errorf("Unmarshal(%s) = %v, %v; expected %v", data, &decoded, err, r)
+	}
+
+	inverted := `{"min":[1,2,3],"max":[5,1,4]}`
+	if err := json.Unmarshal([]byte(inverted), &decoded); !errors.Is(err, ErrZeroLength) {
+		t.Errorf("Unmarshal(%s) returned %v; expected a DistError", inverted, err)
+	}
+	if decoded != *r {
+		t.Errorf("failed Unmarshal changed the rectangle to %v", &decoded)
+	}
+	for _, bad := range []string{`{"min":[1,2,3]}`, `{"min":[1,2],"max":[5,6,7]}`, `[1,2,3]`} {
+		if err := json.Unmarshal([]byte(bad), &decoded); err == nil {
+			t.Errorf("Unmarshal(%s) succeeded", bad)
+		}
+	}
+}
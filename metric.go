@@ -0,0 +1,327 @@
+// Copyright 2012 Daniel Connelly.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rtreego
+
+import (
+	"math"
+	"sort"
+)
+
+// Metric measures the distance from points to rectangles for
+// nearest-neighbor queries.  The distance to a stored object is the distance
+// to its bounding box.
+type Metric interface {
+	// MinDist returns the distance from p to the nearest point of r.
+	// Nearest-neighbor searches prune any subtree whose bounding box is
+	// farther than the best candidate found so far, so MinDist must never
+	// exceed the distance from p to a point inside r.
+	MinDist(p Point, r *Rect) float64
+}
+
+// MinMaxMetric is a Metric that can also bound from above the distance from
+// a point to the nearest object inside a rectangle.
+type MinMaxMetric interface {
+	Metric
+
+	// MinMaxDist returns the smallest distance d such that, if r is the
+	// bounding box of some objects, at least one of them lies within d of p.
+	// Because every face of a bounding box touches one of its objects, this
+	// is the minimum over the faces of r of the distance from p to the
+	// farthest point of the face.
+	MinMaxDist(p Point, r *Rect) float64
+}
+
+// Euclidean is the usual straight-line distance.  It is the metric used by
+// NearestNeighbor and NearestNeighbors; the other metrics are used through
+// NearestNeighborMetric and NearestNeighborsMetric.
+var Euclidean MinMaxMetric = euclidean{}
+
+type euclidean struct{}
+
+func (euclidean) MinDist(p Point, r *Rect) float64 {
+	return math.Sqrt(p.minDist(r))
+}
+
+func (euclidean) MinMaxDist(p Point, r *Rect) float64 {
+	return math.Sqrt(p.minMaxDist(r))
+}
+
+// Manhattan is the L1 distance, the sum of the distances along each axis.
+var Manhattan MinMaxMetric = manhattan{}
+
+type manhattan struct{}
+
+func (manhattan) MinDist(p Point, r *Rect) float64 {
+	sum := 0.0
+	for i := range p {
+		sum += axisDist(p[i], r.p[i], r.q[i])
+	}
+	return sum
+}
+
+func (manhattan) MinMaxDist(p Point, r *Rect) float64 {
+	near, far := faceDists(p, r)
+	total := 0.0
+	for _, f := range far {
+		total += f
+	}
+	d := math.Inf(1)
+	for k := range p {
+		d = math.Min(d, total-far[k]+near[k])
+	}
+	return d
+}
+
+// Chebyshev is the L∞ distance, the largest of the distances along each
+// axis.
+var Chebyshev MinMaxMetric = chebyshev{}
+
+type chebyshev struct{}
+
+func (chebyshev) MinDist(p Point, r *Rect) float64 {
+	d := 0.0
+	for i := range p {
+		d = math.Max(d, axisDist(p[i], r.p[i], r.q[i]))
+	}
+	return d
+}
+
+func (chebyshev) MinMaxDist(p Point, r *Rect) float64 {
+	near, far := faceDists(p, r)
+	d := math.Inf(1)
+	for k := range p {
+		dk := near[k]
+		for i, f := range far {
+			if i != k {
+				dk = math.Max(dk, f)
+			}
+		}
+		d = math.Min(d, dk)
+	}
+	return d
+}
+
+// WrappingMetric is the Euclidean distance in a world that wraps around along
+// some of its axes, like a torus: moving past one end of a wrapping axis
+// leads back in at the other, so the nearest object may lie across the edge
+// of the world.  Size holds the length of each axis, that is the period
+// after which its coordinates repeat; axes whose size is not positive, or
+// is infinite, don't wrap.
+//
+// MinDist is the exact distance from p to the nearest copy of each
+// coordinate interval of r, so it never exceeds the distance to an object
+// inside r and searches that prune with it stay correct.  Objects may have
+// coordinates outside [0, Size); there is no need to duplicate those near
+// the edges.  WrappingMetric is not a MinMaxMetric, so searches with it
+// skip the MinMaxDist pruning.
+type WrappingMetric struct {
+	Size [Dim]float64
+}
+
+// MinDist implements Metric.
+func (m WrappingMetric) MinDist(p Point, r *Rect) float64 {
+	sum := 0.0
+	for i := range p {
+		d := wrappedAxisDist(p[i], r.p[i], r.q[i], m.Size[i])
+		sum += d * d
+	}
+	return math.Sqrt(sum)
+}
+
+// wrappedAxisDist returns the distance from x to the interval [a, b] on an
+// axis whose coordinates repeat every size.
+func wrappedAxisDist(x, a, b, size float64) float64 {
+	if !(size > 0) || math.IsInf(size, 1) {
+		return axisDist(x, a, b)
+	}
+	if b-a >= size {
+		return 0
+	}
+	// the copy of x in [a, a+size)
+	x = a + math.Mod(x-a, size)
+	if x < a {
+		x += size
+	}
+	if x <= b {
+		return 0
+	}
+	return math.Min(x-b, a+size-x)
+}
+
+// axisDist returns the distance from x to the interval [a, b].
+func axisDist(x, a, b float64) float64 {
+	if x < a {
+		return a - x
+	}
+	if x > b {
+		return x - b
+	}
+	return 0
+}
+
+// faceDists returns, for each axis, the distances from p to the nearer and
+// to the farther of the two faces of r perpendicular to it, measured along
+// that axis, as used in computing MinMaxDist.
+func faceDists(p Point, r *Rect) (near, far Point) {
+	for i := range p {
+		a, b := math.Abs(p[i]-r.p[i]), math.Abs(p[i]-r.q[i])
+		if p[i] <= (r.p[i]+r.q[i])/2 {
+			near[i], far[i] = a, b
+		} else {
+			near[i], far[i] = b, a
+		}
+	}
+	return near, far
+}
+
+// NearestNeighborMetric returns the object closest to p as measured by m.
+//
+// The search always prunes subtrees whose distance from p, according to
+// m.MinDist, is no smaller than that of the best candidate.  If m is a
+// MinMaxMetric it additionally discards, before descending, any subtree whose
+// MinDist exceeds the smallest MinMaxDist of its siblings; otherwise that
+// step is skipped, which keeps the search correct but makes it visit more
+// nodes.  It is also skipped while a tree created with WithLazyCondense has
+// deletions pending compaction.
+func (tree *Rtree) NearestNeighborMetric(p Point, m Metric) Spatial {
+	obj, _ := tree.nearestNeighborMetric(p, m, tree.root, math.Inf(1), nil)
+	return obj
+}
+
+func (tree *Rtree) nearestNeighborMetric(p Point, m Metric, n *node, d float64, nearest Spatial) (Spatial, float64) {
+	if n.leaf {
+		for _, e := range n.entries {
+			if dist := m.MinDist(p, e.bb); dist < d {
+				d = dist
+				nearest = e.obj
+			}
+		}
+		return nearest, d
+	}
+
+	branches, dists := sortEntriesMetric(p, m, n.entries)
+	if mm, ok := m.(MinMaxMetric); ok && !tree.loose() {
+		bound := math.Inf(1)
+		for _, e := range branches {
+			if minMaxDist := mm.MinMaxDist(p, e.bb); minMaxDist < bound {
+				bound = minMaxDist
+			}
+		}
+		for i := range dists {
+			if dists[i] > bound {
+				branches = branches[:i]
+				break
+			}
+		}
+	}
+	for i, e := range branches {
+		if dists[i] >= d {
+			break
+		}
+		nearest, d = tree.nearestNeighborMetric(p, m, e.child, d, nearest)
+	}
+	return nearest, d
+}
+
+// NearestNeighborsMetric returns the k objects closest to p as measured by
+// m, nearest first.  The result holds fewer than k objects only if the tree
+// does.
+//
+// Objects at the same distance are ordered as by NearestNeighbors.  Subtrees
+// whose distance from p, according to m.MinDist, is greater than that of the
+// k-th best candidate are pruned.  A MinMaxDist bound only
+// guarantees a single object, so it is not used when k > 1.
+func (tree *Rtree) NearestNeighborsMetric(k int, p Point, m Metric) []Spatial {
+	if k <= 0 {
+		return []Spatial{}
+	}
+	dists := make([]float64, k)
+	objs := make([]Spatial, k)
+	for i := range dists {
+		dists[i] = math.Inf(1)
+	}
+	objs, dists = tree.nearestNeighborsMetric(k, p, m, tree.root, dists, objs)
+	for i, obj := range objs {
+		if obj == nil {
+			return objs[:i]
+		}
+	}
+	return objs
+}
+
+func (tree *Rtree) nearestNeighborsMetric(k int, p Point, m Metric, n *node, dists []float64, nearest []Spatial) ([]Spatial, []float64) {
+	if n.leaf {
+		for _, e := range n.entries {
+			dists, nearest = insertNearest(k, dists, nearest, m.MinDist(p, e.bb), e.obj)
+		}
+		return nearest, dists
+	}
+
+	branches, branchDists := sortEntriesMetric(p, m, n.entries)
+	for i, e := range branches {
+		if branchDists[i] > dists[k-1] {
+			break
+		}
+		nearest, dists = tree.nearestNeighborsMetric(k, p, m, e.child, dists, nearest)
+	}
+	return nearest, dists
+}
+
+func sortEntriesMetric(p Point, m Metric, entries []entry) ([]entry, []float64) {
+	sorted := make([]entry, len(entries))
+	dists := make([]float64, len(entries))
+	for i := range entries {
+		sorted[i] = entries[i]
+		dists[i] = m.MinDist(p, entries[i].bb)
+	}
+	sort.Sort(entrySlice{sorted, dists})
+	return sorted, dists
+}
+
+// Weighted is implemented by objects whose distance from a point is scaled
+// by a weight in NearestNeighborWeighted, such as a penalty or a cost.
+type Weighted interface {
+	Weight() float64
+}
+
+// NearestNeighborWeighted returns the object with the smallest weighted
+// distance from p: the Euclidean distance to its bounding box multiplied by
+// its Weight, or by 1 if it is not Weighted.  It returns nil for an empty
+// tree.
+//
+// Subtrees are pruned with the unweighted distance to their bounding boxes,
+// which never exceeds the weighted distance of an object inside provided
+// that no weight is less than 1.  Every weight must therefore be at least 1;
+// scale the weights if necessary, which doesn't change which object is
+// nearest.  Smaller weights may make the search miss the nearest object.
+func (tree *Rtree) NearestNeighborWeighted(p Point) Spatial {
+	obj, _ := tree.nearestNeighborWeighted(p, tree.root, math.Inf(1), nil)
+	return obj
+}
+
+func (tree *Rtree) nearestNeighborWeighted(p Point, n *node, d float64, nearest Spatial) (Spatial, float64) {
+	if n.leaf {
+		for _, e := range n.entries {
+			dist := math.Sqrt(p.minDist(e.bb))
+			if w, ok := e.obj.(Weighted); ok {
+				dist *= w.Weight()
+			}
+			if dist < d {
+				d = dist
+				nearest = e.obj
+			}
+		}
+		return nearest, d
+	}
+
+	branches, dists := sortEntriesMetric(p, Euclidean, n.entries)
+	for i, e := range branches {
+		if dists[i] >= d {
+			break
+		}
+		nearest, d = tree.nearestNeighborWeighted(p, e.child, d, nearest)
+	}
+	return nearest, d
+}
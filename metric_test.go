@@ -0,0 +1,209 @@
+// Copyright 2012 Daniel Connelly.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rtreego
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+// minDistOnly hides the MinMaxDist method of a metric.
+type minDistOnly struct {
+	m Metric
+}
+
+func (m minDistOnly) MinDist(p Point, r *Rect) float64 {
+	return m.m.MinDist(p, r)
+}
+
+func nearestByScan(objs []Spatial, k int, p Point, m Metric) []Spatial {
+	sorted := make([]Spatial, len(objs))
+	copy(sorted, objs)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return m.MinDist(p, sorted[i].Bounds()) < m.MinDist(p, sorted[j].Bounds())
+	})
+	if k < len(sorted) {
+		sorted = sorted[:k]
+	}
+	return sorted
+}
+
+func checkNearestMetric(t *testing.T, m Metric) {
+	objs := randomRects(300, 7)
+	rt := NewTree(3, 6)
+	for _, obj := range objs {
+		rt.Insert(obj)
+	}
+
+	queries := []Point{{0, 0, 0}, {50, 50, 50}, {-20, 130, 40}, {99, 1, 57}}
+	for _, q := range queries {
+		expected := nearestByScan(objs, 7, q, m)
+		if obj := rt.NearestNeighborMetric(q, m); obj != expected[0] {
+			t.Errorf("NearestNeighborMetric(%v) = %v; expected %v", q, obj, expected[0])
+		}
+		objs := rt.NearestNeighborsMetric(7, q, m)
+		if len(objs) != len(expected) {
+			t.Fatalf("NearestNeighborsMetric returned %d objects; expected %d", len(objs), len(expected))
+		}
+		for i := range expected {
+			if objs[i] != expected[i] {
+				t.Errorf("NearestNeighborsMetric(%v)[%d] = %v; expected %v", q, i, objs[i], expected[i])
+			}
+		}
+	}
+}
+
+func TestNearestNeighborMetricEuclidean(t *testing.T) {
+	checkNearestMetric(t, Euclidean)
+}
+
+func TestNearestNeighborMetricManhattan(t *testing.T) {
+	checkNearestMetric(t, Manhattan)
+}
+
+func TestNearestNeighborMetricChebyshev(t *testing.T) {
+	checkNearestMetric(t, Chebyshev)
+}
+
+func TestMetricDistances(t *testing.T) {
+	r := mustRect(Point{1, 1, 1}, [Dim]float64{2, 2, 2})
+	tests := []struct {
+		m               MinMaxMetric
+		p               Point
+		minDist, minMax float64
+	}{
+		{Manhattan, Point{0, 0, 0}, 3, 7},
+		{Manhattan, Point{2, 2, 2}, 0, 3},
+		{Manhattan, Point{5, 2, 1}, 2, 5},
+		{Chebyshev, Point{0, 0, 0}, 1, 3},
+		{Chebyshev, Point{2, 2, 2}, 0, 1},
+		{Chebyshev, Point{5, 2, 1}, 2, 2},
+	}
+	for _, test := range tests {
+		if d := test.m.MinDist(test.p, r); d != test.minDist {
+			t.Errorf("%T.MinDist(%v, %v) = %v; expected %v", test.m, test.p, r, d, test.minDist)
+		}
+		if d := test.m.MinMaxDist(test.p, r); d != test.minMax {
+			t.Errorf("%T.MinMaxDist(%v, %v) = %v; expected %v", test.m, test.p, r, d, test.minMax)
+		}
+	}
+}
+
+func TestNearestNeighborMetricWrapping(t *testing.T) {
+	checkNearestMetric(t, WrappingMetric{Size: [Dim]float64{100, 100, 100}})
+}
+
+func TestWrappingMetric(t *testing.T) {
+	m := WrappingMetric{Size: [Dim]float64{100, 50, 0}}
+	r := mustRect(Point{90, 10, 0}, [Dim]float64{8, 10, 1}) // [90, 98]x[10, 20]x[0, 1]
+	tests := []struct {
+		p       Point
+		minDist float64
+	}{
+		{Point{95, 15, 0.5}, 0},
+		{Point{-5, 15, 0.5}, 0}, // the same point one period down
+		{Point{1, 15, 0.5}, 3},  // across the edge at 100
+		{Point{80, 15, 0.5}, 10},
+		{Point{94, 48, 0.5}, 12}, // across the edge at 50
+		{Point{94, 15, 4}, 3},    // the third axis doesn't wrap
+		{Point{194, 65, 0.5}, 0},
+	}
+	for _, test := range tests {
+		if d := m.MinDist(test.p, r); math.Abs(d-test.minDist) > EPS {
+			t.Errorf("MinDist(%v, %v) = %v; expected %v", test.p, r, d, test.minDist)
+		}
+	}
+
+	// the nearest object lies across the edge of the world
+	rt := NewTree(3, 6)
+	near, far := mustRect(Point{97, 50, 50}, [Dim]float64{2, 1, 1}), mustRect(Point{10, 50, 50}, [Dim]float64{1, 1, 1})
+	rt.Insert(near)
+	rt.Insert(far)
+	p := Point{2, 50, 50}
+	if obj := rt.NearestNeighbor(p); obj != far {
+		t.Errorf("NearestNeighbor(%v) = %v; expected %v", p, obj, far)
+	}
+	if obj := rt.NearestNeighborMetric(p, WrappingMetric{Size: [Dim]float64{100}}); obj != near {
+		t.Errorf("NearestNeighborMetric(%v) with wrapping = %v; expected %v", p, obj, near)
+	}
+}
+
+func TestNearestNeighborMetricWithoutMinMaxDist(t *testing.T) {
+	checkNearestMetric(t, minDistOnly{Euclidean})
+}
+
+func TestNearestNeighborsMetricSmallTree(t *testing.T) {
+	rt := NewTree(2, 3)
+	things := []*Rect{
+		mustRect(Point{1, 1}, [Dim]float64{1, 1}),
+		mustRect(Point{-7, -7}, [Dim]float64{1, 1}),
+	}
+	for _, thing := range things {
+		rt.Insert(thing)
+	}
+	objs := rt.NearestNeighborsMetric(5, Point{0, 0}, Euclidean)
+	if len(objs) != 2 || objs[0] != things[0] || objs[1] != things[1] {
+		t.Errorf("NearestNeighborsMetric = %v; expected both objects", objs)
+	}
+	if objs := rt.NearestNeighborsMetric(0, Point{0, 0}, Euclidean); len(objs) != 0 {
+		t.Errorf("NearestNeighborsMetric(0) = %v; expected no objects", objs)
+	}
+}
+
+// weightedRect is a rectangle whose distances are scaled by weight.
+type weightedRect struct {
+	*Rect
+	weight float64
+}
+
+func (r weightedRect) Weight() float64 { return r.weight }
+
+func TestNearestNeighborWeighted(t *testing.T) {
+	rnd := rand.New(rand.NewSource(49))
+	rt := NewTree(3, 6)
+	var objs []Spatial
+	for i, obj := range randomRects(500, 49) {
+		if i%5 != 0 {
+			// every fifth object is unweighted
+			obj = weightedRect{obj.Bounds(), 1 + rnd.Float64()*9}
+		}
+		objs = append(objs, obj)
+		rt.Insert(obj)
+	}
+
+	weighted := func(p Point, obj Spatial) float64 {
+		d := math.Sqrt(p.minDist(obj.Bounds()))
+		if w, ok := obj.(Weighted); ok {
+			d *= w.Weight()
+		}
+		return d
+	}
+	for _, p := range []Point{{0, 0, 0}, {50, 50, 50}, {-20, 130, 40}, {99, 1, 57}} {
+		expected := objs[0]
+		for _, obj := range objs[1:] {
+			if weighted(p, obj) < weighted(p, expected) {
+				expected = obj
+			}
+		}
+		if got := rt.NearestNeighborWeighted(p); weighted(p, got) != weighted(p, expected) {
+			t.Errorf("NearestNeighborWeighted(%v) = %v at %v; expected %v at %v", p, got, weighted(p, got), expected, weighted(p, expected))
+		}
+	}
+
+	// a heavy object loses to a farther light one
+	small := NewTree(3, 6)
+	heavy := weightedRect{mustRect(Point{1, 0, 0}, [Dim]float64{1, 1, 1}), 10}
+	light := mustRect(Point{5, 0, 0}, [Dim]float64{1, 1, 1})
+	small.Insert(heavy)
+	small.Insert(light)
+	if got := small.NearestNeighborWeighted(Point{0, 0.5, 0.5}); got != light {
+		t.Errorf("NearestNeighborWeighted = %v; expected %v", got, light)
+	}
+	if got := NewTree(3, 6).NearestNeighborWeighted(Point{}); got != nil {
+		t.Errorf("NearestNeighborWeighted of an empty tree = %v; expected nil", got)
+	}
+}
@@ -0,0 +1,129 @@
+// Copyright 2012 Daniel Connelly.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rtreego
+
+import (
+	"fmt"
+	"unsafe"
+)
+
+// An Option configures a tree created by NewTree.
+type Option func(*Rtree)
+
+// WithLazyCondense makes Delete only remove the object from its leaf,
+// postponing the condensation of underfull nodes, the reinsertion of their
+// entries and the tightening of bounding boxes until the next Insert or an
+// explicit call to Compact, which process all pending deletions in one batch.
+// This amortizes the restructuring cost of bursts of deletions.
+//
+// In the meantime searches remain correct: they only traverse a looser
+// structure, in which bounding boxes may be larger than what they contain
+// and some nodes may be underfull or empty.  Searches never compact the
+// tree, so they remain safe to run concurrently with each other.
+func WithLazyCondense() Option {
+	return func(tree *Rtree) {
+		tree.lazyCondense = true
+	}
+}
+
+// EntryBytes is the memory taken by an entry of a node, including its
+// bounding box, as used by WithTargetNodeBytes.
+const EntryBytes = int(unsafe.Sizeof(entry{}) + unsafe.Sizeof(Rect{}))
+
+// WithTargetNodeBytes sets the branching factors of the tree from the size of
+// its nodes instead of the values given to NewTree, as for nodes stored in
+// pages of a fixed size: MaxChildren becomes the number of entries of
+// EntryBytes that fit in bytes, and MinChildren 40% of it, or 1 for small
+// nodes.  The values given to NewTree are then ignored, so they may be left
+// as zero.  The resulting values can be read with Params.  It panics if bytes
+// can't hold at least two entries.
+func WithTargetNodeBytes(bytes int) Option {
+	maxChildren := bytes / EntryBytes
+	if maxChildren < 2 {
+		panic(fmt.Errorf("rtreego: nodes of %d bytes hold fewer than 2 entries", bytes))
+	}
+	minChildren := max(1, maxChildren*2/5)
+	return func(tree *Rtree) {
+		tree.MinChildren, tree.MaxChildren = minChildren, maxChildren
+	}
+}
+
+// WithUniverse restricts the tree to objects whose bounding boxes lie within
+// universe, as for catching objects with bad coordinates where they enter the
+// tree.  InsertChecked returns an error wrapping ErrOutsideUniverse for other
+// objects, and every other method that adds or moves objects panics with it:
+// Insert, InsertBatch, InsertOrMerge, Merge, Update and UpdateAll leave the
+// tree unchanged, while InsertAll and InsertAllUnique keep the objects they
+// inserted before the offending one.  Without a universe, any bounding box is
+// accepted.
+func WithUniverse(universe *Rect) Option {
+	u := *universe
+	return func(tree *Rtree) {
+		tree.universe = &u
+	}
+}
+
+// WithSequenceNumbers makes the tree number the objects in the order they
+// are inserted, so that WalkOrdered can visit them in that order.  The
+// numbers are kept in a map beside the nodes, so trees without this option
+// don't pay for them, and the objects must be valid map keys: comparable
+// with ==, even if they are Comparable.
+func WithSequenceNumbers() Option {
+	return func(tree *Rtree) {
+		tree.seqs = make(map[Spatial]uint64)
+	}
+}
+
+// WithSplitStrategy makes the tree split overflowing nodes with s instead of
+// the quadratic split, for example with RStarSplit.
+func WithSplitStrategy(s SplitStrategy) Option {
+	return func(tree *Rtree) {
+		tree.splitter = s
+	}
+}
+
+// WithInsertHeuristic makes Insert choose the subtree that receives each new
+// object with h instead of LeastEnlargement.  It panics if h is not one of
+// the heuristics defined by this package.
+func WithInsertHeuristic(h InsertHeuristic) Option {
+	if h != LeastEnlargement && h != LeastOverlap {
+		panic(fmt.Errorf("rtreego: unknown insertion heuristic %d", h))
+	}
+	return func(tree *Rtree) {
+		tree.heuristic = h
+	}
+}
+
+// WithAutoOptimize makes the tree rebuild itself with Optimize whenever its
+// TreeStats.Overlap exceeds threshold.  The overlap is measured by the
+// methods that insert, delete or move objects, such as Insert, InsertBatch,
+// Merge, Delete, DeleteWithFunc, Update and UpdateAll, once the number of
+// objects changed since the last measurement reaches the size of the tree, or
+// a few hundred for small trees, so that its cost stays proportional to the
+// changes.  It panics if threshold is not positive.
+func WithAutoOptimize(threshold float64) Option {
+	if !(threshold > 0) {
+		panic(fmt.Errorf("rtreego: optimization threshold %v is not positive", threshold))
+	}
+	return func(tree *Rtree) {
+		tree.optimizeAbove = threshold
+	}
+}
+
+// WithReinsertPercentage enables the forced reinsertion of the R*-tree: the
+// first time an insertion overflows a node at some level, other than the
+// root, the fraction p of its entries farthest from its center are removed
+// and inserted again instead of the node being split.  This spreads entries
+// to the nodes that fit them best, giving tighter, fuller nodes at the cost of
+// slower insertions; the R*-tree paper recommends p = 0.3.  It panics if p is
+// not in [0, 1); a p of 0 disables reinsertion, which is the default.
+func WithReinsertPercentage(p float64) Option {
+	if !(p >= 0 && p < 1) {
+		panic(fmt.Errorf("rtreego: reinsert percentage %v is not in [0, 1)", p))
+	}
+	return func(tree *Rtree) {
+		tree.reinsert = p
+	}
+}
@@ -0,0 +1,387 @@
+// Copyright 2012 Daniel Connelly.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rtreego
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+// verifyTight checks that every bounding box in the subtree of n is the
+// bounding box of its contents.
+func verifyTight(t *testing.T, n *node) {
+	if n.leaf {
+		return
+	}
+	for _, e := range n.entries {
+		if len(e.child.entries) == 0 {
+			t.Errorf("found an empty node at level %d", e.child.level)
+			continue
+		}
+		if bb := e.child.computeBoundingBox(); !bb.Equal(e.bb) {
+			t.Errorf("entry has bounding box %v; expected %v", e.bb, bb)
+		}
+		verifyTight(t, e.child)
+	}
+}
+
+func TestLazyCondense(t *testing.T) {
+	objs := randomRects(500, 22)
+	rt := NewTree(3, 6, WithLazyCondense())
+	for _, obj := range objs {
+		rt.Insert(obj)
+	}
+	depth := rt.Depth()
+
+	bb := mustRect(Point{10, 10, 10}, [Dim]float64{60, 60, 60})
+	queries := []Point{{0, 0, 0}, {50, 50, 50}, {80, 20, 60}}
+	for i, obj := range objs[:400] {
+		if !rt.Delete(obj) {
+			t.Fatalf("failed to delete object %d", i)
+		}
+		if i%50 != 49 {
+			continue
+		}
+		remaining := objs[i+1:]
+		if rt.Size() != len(remaining) || rt.Depth() != depth {
+			t.Errorf("lazy Delete left size %d and depth %d", rt.Size(), rt.Depth())
+		}
+		q := rt.SearchIntersect(bb)
+		expected := 0
+		for _, obj := range remaining {
+			if intersect(obj.Bounds(), bb) {
+				expected++
+				if indexOf(q, obj) < 0 {
+					t.Errorf("SearchIntersect after lazy deletes failed to find %v", obj)
+				}
+			}
+		}
+		if len(q) != expected {
+			t.Errorf("SearchIntersect after lazy deletes found %d objects; expected %d", len(q), expected)
+		}
+		for _, p := range queries {
+			if obj, want := rt.NearestNeighbor(p), nearestByScan(remaining, 1, p, Euclidean)[0]; obj != want {
+				t.Errorf("NearestNeighbor(%v) after lazy deletes = %v; expected %v", p, obj, want)
+			}
+		}
+	}
+
+	rt.Compact()
+	verify(t, rt.root)
+	verifyTight(t, rt.root)
+	if rt.Size() != 100 || rt.loose() {
+		t.Errorf("Compact left size %d and pending changes %v", rt.Size(), rt.loose())
+	}
+	for _, obj := range objs[400:] {
+		if !rt.Contains(obj, nil) {
+			t.Errorf("Compact lost %v", obj)
+		}
+	}
+
+	rt.Delete(objs[400])
+	rt.Insert(objs[400])
+	if rt.loose() {
+		t.Errorf("Insert did not compact pending deletions")
+	}
+	verifyTight(t, rt.root)
+}
+
+func TestBulkDelete(t *testing.T) {
+	objs := randomRects(500, 28)
+	eager, rt := NewTree(3, 6), NewTree(3, 6)
+	for _, obj := range objs {
+		eager.Insert(obj)
+		rt.Insert(obj)
+	}
+	bb := mustRect(Point{10, 10, 10}, [Dim]float64{60, 60, 60})
+
+	rt.BeginBulkDelete()
+	for i, obj := range objs[:400] {
+		eager.Delete(obj)
+		if !rt.Delete(obj) {
+			t.Fatalf("failed to delete object %d", i)
+		}
+		if i%100 == 99 {
+			if q, expected := rt.SearchIntersect(bb), eager.SearchIntersect(bb); len(q) != len(expected) {
+				t.Errorf("SearchIntersect during a bulk deletion found %d objects; expected %d", len(q), len(expected))
+			}
+		}
+	}
+	if !rt.loose() {
+		t.Errorf("deletions condensed the tree before EndBulkDelete")
+	}
+	rt.DeleteWithFunc(func(obj Spatial) bool { return obj == objs[400] })
+	eager.Delete(objs[400])
+	rt.EndBulkDelete()
+
+	if err := rt.Validate(); err != nil || rt.loose() {
+		t.Errorf("tree invalid after EndBulkDelete: %v", err)
+	}
+	verifyTight(t, rt.root)
+	if rt.Size() != eager.Size() {
+		t.Errorf("tree has size %d after a bulk deletion; expected %d", rt.Size(), eager.Size())
+	}
+	for _, obj := range objs[401:] {
+		if !rt.Contains(obj, nil) {
+			t.Errorf("bulk deletion lost %v", obj)
+		}
+	}
+
+	// later deletions condense immediately again
+	rt.Delete(objs[401])
+	if rt.loose() {
+		t.Errorf("Delete after EndBulkDelete didn't condense the tree")
+	}
+}
+
+func TestTargetNodeBytes(t *testing.T) {
+	rt := NewTree(3, 6, WithTargetNodeBytes(4096))
+	min, max := rt.Params()
+	if max != 4096/EntryBytes || min != max*2/5 {
+		t.Errorf("nodes of 4096 bytes have branching factors (%d, %d); expected (%d, %d)", min, max, 4096/EntryBytes*2/5, 4096/EntryBytes)
+	}
+	for _, obj := range randomRects(2000, 24) {
+		rt.Insert(obj)
+	}
+	if err := rt.Validate(); err != nil {
+		t.Errorf("tree invalid: %v", err)
+	}
+	rt.root.walkNodes(func(n *node) {
+		if len(n.entries)*EntryBytes > 4096 {
+			t.Errorf("node of %d entries doesn't fit in 4096 bytes", len(n.entries))
+		}
+	})
+	if min, max := NewTree(3, 6, WithTargetNodeBytes(2*EntryBytes)).Params(); min != 1 || max != 2 {
+		t.Errorf("nodes of two entries have branching factors (%d, %d); expected (1, 2)", min, max)
+	}
+	// the branching factors given to NewTree aren't checked when replaced
+	if min, max := NewTree(0, 0, WithTargetNodeBytes(4096)).Params(); max != 4096/EntryBytes || min != max*2/5 {
+		t.Errorf("NewTree(0, 0) with nodes of 4096 bytes has branching factors (%d, %d)", min, max)
+	}
+	if min, max := NewTree(3, 6).Params(); min != 3 || max != 6 {
+		t.Errorf("Params() = (%d, %d); expected (3, 6)", min, max)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("WithTargetNodeBytes for a single entry didn't panic")
+		}
+	}()
+	WithTargetNodeBytes(EntryBytes)
+}
+
+func TestUniverse(t *testing.T) {
+	universe := mustRect(Point{0, 0, 0}, [Dim]float64{200, 200, 200})
+	rt := NewTree(3, 6, WithUniverse(universe))
+	objs := randomRects(100, 25)
+	for _, obj := range objs {
+		if err := rt.InsertChecked(obj); err != nil {
+			t.Fatalf("InsertChecked(%v) failed: %v", obj, err)
+		}
+	}
+	// the universe itself and its faces are inside it
+	rt.Insert(universe)
+	rt.Insert(&Rect{Point{200, 0, 0}, Point{200, 1, 1}})
+
+	if err := rt.InsertChecked(mustRect(Point{199, 50, 50}, [Dim]float64{2, 1, 1})); !errors.Is(err, ErrOutsideUniverse) {
+		t.Errorf("InsertChecked outside the universe = %v; expected ErrOutsideUniverse", err)
+	}
+	for _, bad := range []*Rect{
+		mustRect(Point{199, 50, 50}, [Dim]float64{2, 1, 1}),
+		mustRect(Point{-200, -200, -200}, [Dim]float64{1, 1, 1}),
+		{Point{math.NaN(), 1, 1}, Point{2, 2, 2}},
+	} {
+		if err := rt.InsertChecked(bad); err == nil {
+			t.Errorf("InsertChecked(%v) succeeded", bad)
+		}
+		func() {
+			defer func() {
+				if err, _ := recover().(error); !errors.Is(err, ErrOutsideUniverse) {
+					t.Errorf("Insert(%v) panicked with %v; expected ErrOutsideUniverse", bad, err)
+				}
+			}()
+			rt.Insert(bad)
+		}()
+		func() {
+			defer func() {
+				if err, _ := recover().(error); !errors.Is(err, ErrOutsideUniverse) {
+					t.Errorf("Update to %v panicked with %v; expected ErrOutsideUniverse", bad, err)
+				}
+			}()
+			rt.Update(objs[0], bad)
+		}()
+	}
+	outside := NewTree(3, 6)
+	outside.Insert(mustRect(Point{10, 10, 10}, [Dim]float64{1, 1, 1}))
+	outside.Insert(mustRect(Point{-10, 10, 10}, [Dim]float64{1, 1, 1}))
+	func() {
+		defer func() {
+			if err, _ := recover().(error); !errors.Is(err, ErrOutsideUniverse) {
+				t.Errorf("Merge of objects outside the universe panicked with %v; expected ErrOutsideUniverse", err)
+			}
+		}()
+		rt.Merge(outside)
+	}()
+	func() {
+		defer func() {
+			if err, _ := recover().(error); !errors.Is(err, ErrOutsideUniverse) {
+				t.Errorf("InsertOrMerge outside the universe panicked with %v; expected ErrOutsideUniverse", err)
+			}
+		}()
+		rt.InsertOrMerge(objs[3].Bounds(), func(existing []Spatial, obj Spatial) Spatial {
+			return mustRect(Point{-1, 0, 0}, [Dim]float64{1, 1, 1})
+		})
+	}()
+	if rt.Size() != len(objs)+2 || !rt.Contains(objs[0], nil) || !rt.Contains(objs[3], nil) {
+		t.Errorf("rejected objects changed the tree")
+	}
+	verify(t, rt.root)
+	inside := NewTree(3, 6)
+	inside.Insert(mustRect(Point{10, 10, 10}, [Dim]float64{1, 1, 1}))
+	rt.Merge(inside)
+	if rt.Size() != len(objs)+3 {
+		t.Errorf("Merge of objects inside the universe left size %d; expected %d", rt.Size(), len(objs)+3)
+	}
+
+	// modifying the universe passed in doesn't change the tree's
+	universe.q[0] = 1000
+	if err := rt.InsertChecked(mustRect(Point{500, 0, 0}, [Dim]float64{1, 1, 1})); err == nil {
+		t.Errorf("the tree's universe changed with the rectangle it was created from")
+	}
+}
+
+func TestSequenceNumbers(t *testing.T) {
+	ordered := func(rt *Rtree) ([]uint64, []Spatial) {
+		var seqs []uint64
+		var objs []Spatial
+		rt.WalkOrdered(func(seq uint64, obj Spatial) bool {
+			seqs = append(seqs, seq)
+			objs = append(objs, obj)
+			return true
+		})
+		return seqs, objs
+	}
+
+	objs := randomRects(300, 26)
+	rt := NewTree(3, 6, WithSequenceNumbers(), WithReinsertPercentage(0.3))
+	for _, obj := range objs[:200] {
+		rt.Insert(obj)
+	}
+	rt.InsertBatch(objs[200:])
+	seqs, got := ordered(rt)
+	if len(got) != len(objs) {
+		t.Fatalf("WalkOrdered visited %d objects; expected %d", len(got), len(objs))
+	}
+	for i, obj := range got {
+		if obj != objs[i] || seqs[i] != uint64(i) {
+			t.Fatalf("WalkOrdered visited %v with number %d in place %d; expected %v", obj, seqs[i], i, objs[i])
+		}
+	}
+
+	// numbers survive updates and restructuring, and deleted objects get
+	// new ones when inserted again
+	rt.Update(objs[5], mustRect(Point{500, 500, 500}, [Dim]float64{1, 1, 1}))
+	rt.Optimize()
+	rt.Delete(objs[0])
+	rt.DeleteWithFunc(func(obj Spatial) bool { return obj == objs[1] })
+	rt.Insert(objs[0])
+	seqs, got = ordered(rt)
+	if len(got) != len(objs)-1 || got[0] != objs[2] || got[3] != objs[5] || got[len(got)-1] != objs[0] || seqs[len(seqs)-1] != uint64(len(objs)) {
+		t.Errorf("WalkOrdered after changes visited %v first and %v last with number %d", got[0], got[len(got)-1], seqs[len(seqs)-1])
+	}
+	if len(rt.seqs) != rt.Size() {
+		t.Errorf("tree keeps %d numbers for %d objects", len(rt.seqs), rt.Size())
+	}
+
+	// rebuilding part or all of the tree keeps every number
+	beforeSeqs, before := ordered(rt)
+	rt.RebuildRegion(mustRect(Point{0, 0, 0}, [Dim]float64{60, 60, 60}))
+	rt.Optimize()
+	afterSeqs, after := ordered(rt)
+	if len(after) != len(before) {
+		t.Fatalf("WalkOrdered after rebuilding visited %d objects; expected %d", len(after), len(before))
+	}
+	for i := range after {
+		if after[i] != before[i] || afterSeqs[i] != beforeSeqs[i] {
+			t.Fatalf("WalkOrdered after rebuilding visited %v with number %d in place %d; expected %v with %d",
+				after[i], afterSeqs[i], i, before[i], beforeSeqs[i])
+		}
+	}
+
+	// merged objects are numbered in their own order
+	other := NewTree(3, 6, WithSequenceNumbers())
+	extra := randomRects(20, 27)
+	for _, obj := range extra {
+		other.Insert(obj)
+	}
+	rt.Merge(other)
+	_, got = ordered(rt)
+	for i, obj := range got[len(got)-len(extra):] {
+		if obj != extra[i] {
+			t.Errorf("merged object %d is %v; expected %v", i, obj, extra[i])
+		}
+	}
+
+	count := 0
+	rt.WalkOrdered(func(uint64, Spatial) bool {
+		count++
+		return count < 3
+	})
+	if count != 3 {
+		t.Errorf("WalkOrdered continued after fn returned false")
+	}
+	if c := rt.Clone(); len(c.seqs) != len(rt.seqs) {
+		t.Errorf("Clone kept %d numbers; expected %d", len(c.seqs), len(rt.seqs))
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("WalkOrdered of a tree without numbers didn't panic")
+		}
+	}()
+	NewTree(3, 6).WalkOrdered(func(uint64, Spatial) bool { return true })
+}
+
+func BenchmarkBurstyDelete(b *testing.B) {
+	objs := randomRects(5000, 23)
+	for _, tc := range []struct {
+		name string
+		opts []Option
+	}{
+		{"Eager", nil},
+		{"Lazy", []Option{WithLazyCondense()}},
+	} {
+		b.Run(tc.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				rt := NewTree(4, 16, tc.opts...)
+				for _, obj := range objs {
+					rt.Insert(obj)
+				}
+				b.StartTimer()
+				for _, obj := range objs[:4000] {
+					rt.Delete(obj)
+				}
+				rt.Compact()
+			}
+		})
+	}
+	b.Run("Bulk", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			b.StopTimer()
+			rt := NewTree(4, 16)
+			for _, obj := range objs {
+				rt.Insert(obj)
+			}
+			b.StartTimer()
+			rt.BeginBulkDelete()
+			for _, obj := range objs[:4000] {
+				rt.Delete(obj)
+			}
+			rt.EndBulkDelete()
+		}
+	})
+}
@@ -0,0 +1,180 @@
+// Copyright 2012 Daniel Connelly.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rtreego
+
+import (
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+)
+
+// savedTree is the header of a saved tree.
+type savedTree struct {
+	Dim         int
+	MinChildren int
+	MaxChildren int
+	Size        int
+	Height      int
+}
+
+// savedNode is a node of a saved tree.  Leaf entries record the IDs of their
+// objects, and the entries of other nodes their children.
+type savedNode struct {
+	Level   int
+	Entries []savedEntry
+}
+
+type savedEntry struct {
+	P, Q  Point
+	ID    string
+	Child *savedNode
+}
+
+// Save writes tree to w in a gob-encoded form that Load can read back.
+// Every object in the tree must implement Identifiable, and only its ID is
+// written: the objects themselves are left for the caller to persist.  Any
+// deletions pending in lazy mode are condensed first.
+//
+// The nodes are written as they are, so the loaded tree has the same shape
+// as the saved one and answers every query with the same objects.  The
+// entries of each node are written in the canonical order of
+// AllOrderedByBounds, so the output depends only on the objects and the
+// shape of the tree, not on the order of the entries within its nodes.  Call
+// Optimize first to make it depend on the objects alone, for example to
+// compare saved trees by their hashes.
+func (tree *Rtree) Save(w io.Writer) error {
+	tree.Compact()
+	root, err := saveNode(tree.root)
+	if err != nil {
+		return err
+	}
+	enc := gob.NewEncoder(w)
+	header := savedTree{
+		Dim:         Dim,
+		MinChildren: tree.MinChildren,
+		MaxChildren: tree.MaxChildren,
+		Size:        tree.size,
+		Height:      tree.height,
+	}
+	if err := enc.Encode(header); err != nil {
+		return err
+	}
+	return enc.Encode(root)
+}
+
+func saveNode(n *node) (*savedNode, error) {
+	entries := slices.Clone(n.entries)
+	sortCanonical(entries)
+	s := &savedNode{Level: n.level, Entries: make([]savedEntry, len(entries))}
+	for i, e := range entries {
+		s.Entries[i] = savedEntry{P: e.bb.p, Q: e.bb.q}
+		if n.leaf {
+			obj, ok := e.obj.(Identifiable)
+			if !ok {
+				return nil, fmt.Errorf("rtreego: cannot save object %v: not Identifiable", e.obj)
+			}
+			s.Entries[i].ID = obj.ID()
+			continue
+		}
+		child, err := saveNode(e.child)
+		if err != nil {
+			return nil, err
+		}
+		s.Entries[i].Child = child
+	}
+	return s, nil
+}
+
+// Load reads a tree written by Save from r.  resolve is called with the ID
+// of each saved object and must return the object, whose Bounds are not
+// consulted: each object is stored with the bounding box it was saved with.
+// Load fails with ErrDimMismatch if the tree was saved with a different Dim.
+//
+// Only the branching factors and the nodes are saved, not the options the
+// tree was created with, so the loaded tree is configured by opts as by
+// NewTree.  Options that would change the saved branching factors make Load
+// fail.  With WithUniverse, Load fails with ErrOutsideUniverse if a saved box
+// lies outside the universe, and with WithSequenceNumbers the objects are
+// numbered in the order they are loaded, since their original numbers are
+// lost.
+func Load(r io.Reader, resolve func(id string) Spatial, opts ...Option) (*Rtree, error) {
+	dec := gob.NewDecoder(r)
+	var header savedTree
+	if err := dec.Decode(&header); err != nil {
+		return nil, err
+	}
+	if header.Dim != Dim {
+		return nil, fmt.Errorf("rtreego: tree saved with %d dimensions: %w", header.Dim, ErrDimMismatch)
+	}
+	if err := checkBranching(header.MinChildren, header.MaxChildren); err != nil {
+		return nil, err
+	}
+	var root savedNode
+	if err := dec.Decode(&root); err != nil {
+		return nil, err
+	}
+
+	tree := &Rtree{
+		MinChildren: header.MinChildren,
+		MaxChildren: header.MaxChildren,
+	}
+	for _, opt := range opts {
+		opt(tree)
+	}
+	if tree.MinChildren != header.MinChildren || tree.MaxChildren != header.MaxChildren {
+		return nil, fmt.Errorf("rtreego: options change the saved branching factors (%d, %d) to (%d, %d)",
+			header.MinChildren, header.MaxChildren, tree.MinChildren, tree.MaxChildren)
+	}
+	tree.height = header.Height
+	if root.Level != header.Height {
+		return nil, errors.New("rtreego: saved root is not at the saved height")
+	}
+	n, err := tree.loadNode(&root, resolve)
+	if err != nil {
+		return nil, err
+	}
+	if tree.size != header.Size {
+		return nil, fmt.Errorf("rtreego: loaded %d objects, expected %d", tree.size, header.Size)
+	}
+	tree.root = n
+	return tree, nil
+}
+
+// loadNode rebuilds a saved node and its subtree, counting their objects in
+// tree.size.
+func (tree *Rtree) loadNode(s *savedNode, resolve func(id string) Spatial) (*node, error) {
+	n := &node{
+		leaf:    s.Level == 1,
+		level:   s.Level,
+		entries: make([]entry, len(s.Entries), max(len(s.Entries), tree.MaxChildren+1)),
+	}
+	for i, se := range s.Entries {
+		e := entry{bb: &Rect{se.P, se.Q}}
+		if n.leaf {
+			if e.obj = resolve(se.ID); e.obj == nil {
+				return nil, fmt.Errorf("rtreego: no object with ID %q", se.ID)
+			}
+			if err := tree.checkUniverse(e.obj, e.bb); err != nil {
+				return nil, err
+			}
+			tree.number(e.obj)
+			tree.size++
+		} else {
+			if se.Child == nil || se.Child.Level != s.Level-1 {
+				return nil, fmt.Errorf("rtreego: saved node at level %d has an invalid child", s.Level)
+			}
+			child, err := tree.loadNode(se.Child, resolve)
+			if err != nil {
+				return nil, err
+			}
+			child.parent = n
+			e.child = child
+		}
+		n.entries[i] = e
+	}
+	return n, nil
+}
@@ -0,0 +1,165 @@
+// Copyright 2012 Daniel Connelly.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rtreego
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestSaveLoad(t *testing.T) {
+	rt := NewTree(3, 6)
+	byID := map[string]Spatial{}
+	for i, obj := range randomRects(300, 29) {
+		named := namedRect{obj.Bounds(), fmt.Sprint(i)}
+		byID[named.name] = named
+		rt.Insert(named)
+	}
+
+	var buf bytes.Buffer
+	if err := rt.Save(&buf); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	loaded, err := Load(&buf, func(id string) Spatial { return byID[id] })
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	verify(t, loaded.root)
+	if loaded.Size() != rt.Size() || loaded.Depth() != rt.Depth() {
+		t.Errorf("loaded tree has size %d and depth %d; expected %d and %d", loaded.Size(), loaded.Depth(), rt.Size(), rt.Depth())
+	}
+	if loaded.MinChildren != 3 || loaded.MaxChildren != 6 {
+		t.Errorf("loaded tree has branching factors %d and %d; expected 3 and 6", loaded.MinChildren, loaded.MaxChildren)
+	}
+
+	bb := mustRect(Point{20, 20, 20}, [Dim]float64{50, 50, 50})
+	expected, q := rt.SearchIntersect(bb), loaded.SearchIntersect(bb)
+	if len(q) != len(expected) {
+		t.Fatalf("loaded tree found %d objects; expected %d", len(q), len(expected))
+	}
+	// the entries are saved in canonical order, so only the order of the
+	// results may differ
+	for _, obj := range expected {
+		if indexOf(q, obj) < 0 {
+			t.Errorf("loaded tree didn't return %v", obj)
+		}
+	}
+	p := Point{33, 66, 99}
+	if got, want := loaded.NearestNeighbor(p), rt.NearestNeighbor(p); got != want {
+		t.Errorf("loaded tree's nearest neighbor is %v; expected %v", got, want)
+	}
+
+	// the loaded tree can be modified
+	loaded.Insert(mustRect(Point{1, 2, 3}, [Dim]float64{1, 1, 1}))
+	if !loaded.Delete(byID["7"]) {
+		t.Errorf("failed to delete from the loaded tree")
+	}
+	verify(t, loaded.root)
+}
+
+func TestSaveDeterministic(t *testing.T) {
+	var objs []Spatial
+	for i, obj := range randomRects(200, 48) {
+		objs = append(objs, namedRect{obj.Bounds(), fmt.Sprint(i)})
+	}
+	// identical boxes are ordered by their IDs
+	for _, name := range []string{"x", "y", "z"} {
+		objs = append(objs, namedRect{mustRect(Point{5, 5, 5}, [Dim]float64{1, 1, 1}), name})
+	}
+	save := func(rt *Rtree) []byte {
+		var buf bytes.Buffer
+		if err := rt.Save(&buf); err != nil {
+			t.Fatalf("Save failed: %v", err)
+		}
+		return buf.Bytes()
+	}
+
+	// a single leaf holds its entries in insertion order
+	small1, small2 := NewTree(3, 6), NewTree(3, 6)
+	for i := range 6 {
+		small1.Insert(objs[i])
+		small2.Insert(objs[5-i])
+	}
+	if !bytes.Equal(save(small1), save(small2)) {
+		t.Errorf("Save of one leaf depends on the insertion order")
+	}
+
+	rt1, rt2 := NewTree(3, 6), NewTree(3, 6)
+	for i := range objs {
+		rt1.Insert(objs[i])
+		rt2.Insert(objs[len(objs)-1-i])
+	}
+	rt1.Optimize()
+	rt2.Optimize()
+	if !bytes.Equal(save(rt1), save(rt2)) {
+		t.Errorf("Save of optimized trees depends on the insertion order")
+	}
+}
+
+func TestLoadOptions(t *testing.T) {
+	rt := NewTree(3, 6)
+	byID := map[string]Spatial{}
+	for i, obj := range randomRects(100, 49) {
+		named := namedRect{obj.Bounds(), fmt.Sprint(i)}
+		byID[named.name] = named
+		rt.Insert(named)
+	}
+	var saved bytes.Buffer
+	if err := rt.Save(&saved); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	load := func(opts ...Option) (*Rtree, error) {
+		return Load(bytes.NewReader(saved.Bytes()), func(id string) Spatial { return byID[id] }, opts...)
+	}
+
+	loaded, err := load(WithLazyCondense(), WithSequenceNumbers(), WithSplitStrategy(RStarSplit{}))
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if !loaded.lazyCondense || loaded.splitter == nil || len(loaded.seqs) != rt.Size() {
+		t.Errorf("Load didn't apply its options")
+	}
+	if _, err := load(WithUniverse(mustRect(Point{0, 0, 0}, [Dim]float64{200, 200, 200}))); err != nil {
+		t.Errorf("Load within the universe failed: %v", err)
+	}
+	if _, err := load(WithUniverse(mustRect(Point{0, 0, 0}, [Dim]float64{50, 50, 50}))); !errors.Is(err, ErrOutsideUniverse) {
+		t.Errorf("Load outside the universe returned %v; expected ErrOutsideUniverse", err)
+	}
+	if _, err := load(WithTargetNodeBytes(4096)); err == nil {
+		t.Errorf("Load succeeded with options changing the branching factors")
+	}
+}
+
+func TestSaveLoadErrors(t *testing.T) {
+	rt := NewTree(3, 6)
+	rt.Insert(namedRect{mustRect(Point{0, 0, 0}, [Dim]float64{1, 1, 1}), "a"})
+	var buf bytes.Buffer
+	if err := rt.Save(&buf); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	if _, err := Load(&buf, func(id string) Spatial { return nil }); err == nil {
+		t.Errorf("Load succeeded without resolving the saved ID")
+	}
+
+	buf.Reset()
+	gob.NewEncoder(&buf).Encode(savedTree{Dim: Dim + 1})
+	if _, err := Load(&buf, nil); !errors.Is(err, ErrDimMismatch) {
+		t.Errorf("Load of a tree with other dimensions returned %v; expected ErrDimMismatch", err)
+	}
+
+	buf.Reset()
+	gob.NewEncoder(&buf).Encode(savedTree{Dim: Dim, MinChildren: 4, MaxChildren: 3})
+	if _, err := Load(&buf, nil); err == nil {
+		t.Errorf("Load of a tree with invalid branching factors succeeded")
+	}
+
+	rt.Insert(mustRect(Point{1, 1, 1}, [Dim]float64{1, 1, 1}))
+	if err := rt.Save(&bytes.Buffer{}); err == nil {
+		t.Errorf("Save succeeded with an object that isn't Identifiable")
+	}
+}
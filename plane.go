@@ -0,0 +1,43 @@
+// Copyright 2012 Daniel Connelly.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rtreego
+
+// Helpers for indexing planar data.  Points and rectangles built by these
+// functions use the first two axes for x and y and give every further axis
+// the same fixed coordinates, so they behave exactly like 2D geometry in
+// searches and distance computations whatever the value of Dim.
+
+// The helpers need at least two axes; this fails to compile if Dim < 2.
+var _ [Dim - 2]struct{}
+
+// planeDepth is the half-length of the rectangles built by Rect2D along the
+// axes beyond the first two.
+const planeDepth = 0.5
+
+// Point2D returns the point with coordinates x and y in the plane.
+func Point2D(x, y float64) Point {
+	var p Point
+	p[0], p[1] = x, y
+	return p
+}
+
+// Rect2D returns the rectangle [minX, maxX] x [minY, maxY] in the plane.  Its
+// error is as for NewRect.
+func Rect2D(minX, minY, maxX, maxY float64) (r Rect, err error) {
+	var lengths [Dim]float64
+	lengths[0], lengths[1] = maxX-minX, maxY-minY
+	p := Point2D(minX, minY)
+	for i := 2; i < Dim; i++ {
+		p[i] = -planeDepth
+		lengths[i] = 2 * planeDepth
+	}
+	return NewRect(p, lengths)
+}
+
+// Tree2D creates a new R-tree for objects whose bounding boxes are built by
+// Rect2D.  It is the same as NewTree, provided for symmetry.
+func Tree2D(MinChildren, MaxChildren int) *Rtree {
+	return NewTree(MinChildren, MaxChildren)
+}
@@ -0,0 +1,52 @@
+// Copyright 2012 Daniel Connelly.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rtreego
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+func mustRect2D(minX, minY, maxX, maxY float64) *Rect {
+	r, err := Rect2D(minX, minY, maxX, maxY)
+	if err != nil {
+		panic(err)
+	}
+	return &r
+}
+
+func TestPlane(t *testing.T) {
+	r := mustRect2D(1, 2, 4, 6)
+	if r.PointCoord(0) != 1 || r.PointCoord(1) != 2 || r.LengthsCoord(0) != 3 || r.LengthsCoord(1) != 4 {
+		t.Errorf("Rect2D(1, 2, 4, 6) = %v", r)
+	}
+	if _, err := Rect2D(1, 2, 0, 6); !errors.Is(err, ErrZeroLength) {
+		t.Errorf("Rect2D with negative width returned %v; expected ErrZeroLength", err)
+	}
+
+	rt := Tree2D(2, 3)
+	things := []*Rect{
+		mustRect2D(0, 0, 1, 1),
+		mustRect2D(5, 5, 7, 6),
+		mustRect2D(2, 8, 3, 9),
+		mustRect2D(-4, 1, -3, 2),
+	}
+	for _, thing := range things {
+		rt.Insert(thing)
+	}
+	q := rt.SearchIntersect(mustRect2D(0.5, 0.5, 6, 6))
+	if len(q) != 2 || indexOf(q, things[0]) < 0 || indexOf(q, things[1]) < 0 {
+		t.Errorf("SearchIntersect in the plane = %v", q)
+	}
+
+	p := Point2D(4, 9)
+	if math.Sqrt(p.minDist(things[2])) != 1 {
+		t.Errorf("distance from %v to %v = %v; expected 1", p, things[2], math.Sqrt(p.minDist(things[2])))
+	}
+	if obj := rt.NearestNeighbor(p); obj != things[2] {
+		t.Errorf("NearestNeighbor(%v) = %v; expected %v", p, obj, things[2])
+	}
+}
@@ -6,13 +6,26 @@
 package rtreego
 
 import (
+	"cmp"
+	"container/heap"
+	"context"
+	"errors"
 	"fmt"
+	"iter"
+	"maps"
 	"math"
+	"slices"
 	"sort"
+	"sync"
+	"sync/atomic"
 )
 
 const Dim = 3
 
+// ErrEmptyTree is returned by operations that are undefined on a tree with
+// no objects.
+var ErrEmptyTree = errors.New("rtreego: empty tree")
+
 // Rtree represents an R-tree, a balanced search tree for storing and querying
 // spatial objects.  MinChildren/MaxChildren specify the minimum/maximum branching factors.
 type Rtree struct {
@@ -21,19 +34,153 @@ type Rtree struct {
 	root        *node
 	size        int
 	height      int
+	gen         uint64 // nonzero for copy-on-write trees; see cow.go
+
+	// the level of the highest node split by the insertion in progress
+	// and by the last call to Insert, or 0 for none
+	splitLevel, lastSplit int
+
+	lazyCondense bool
+	bulkDelete   bool    // between BeginBulkDelete and EndBulkDelete
+	dirty        []*node // leaves changed by lazy deletions
+
+	// the Overlap above which the tree rebuilds itself, or 0 for never, and
+	// the number of insertions and deletions since it was last measured
+	optimizeAbove float64
+	changes       int
+
+	splitter  SplitStrategy // nil for the quadratic split
+	heuristic InsertHeuristic
+
+	// the fraction of the entries of an overflowing node to reinsert, the
+	// levels at which the insertion in progress has already done so, and
+	// the entries waiting to be reinserted
+	reinsert   float64
+	reinserted uint64
+	pending    []pendingEntry
+
+	free []*node // detached nodes kept for reuse; see newNode
+
+	universe *Rect // the bounds of every object, or nil for unbounded
+
+	// the insertion numbers of the objects, and the next one to give, in a
+	// tree created WithSequenceNumbers
+	seqs    map[Spatial]uint64
+	nextSeq uint64
+
+	// the objects moved to other leaves by the insertion in progress, if
+	// InsertWithCallback is tracking them
+	moved *[]Spatial
+}
+
+// pendingEntry is an entry removed for reinsertion at the given level.
+type pendingEntry struct {
+	e     entry
+	level int
 }
 
-// NewTree creates a new R-tree instance.
-func NewTree(MinChildren, MaxChildren int) *Rtree {
+// NewTree creates a new R-tree instance, configured by any options given.
+//
+// It panics unless 1 <= MinChildren <= (MaxChildren+1)/2 and MaxChildren >=
+// 2, checking the branching factors in effect after the options, which may
+// replace those given.  The split of a node overflowing with MaxChildren+1
+// entries can then always give both halves at least MinChildren of them.
+// MinChildren is typically around 40% of MaxChildren.
+func NewTree(MinChildren, MaxChildren int, opts ...Option) *Rtree {
 	rt := Rtree{MinChildren: MinChildren, MaxChildren: MaxChildren}
+	for _, opt := range opts {
+		opt(&rt)
+	}
+	if err := checkBranching(rt.MinChildren, rt.MaxChildren); err != nil {
+		panic(err)
+	}
 	rt.height = 1
 	rt.root = &node{}
-	rt.root.entries = make([]entry, 0, MaxChildren)
+	rt.root.entries = make([]entry, 0, rt.MaxChildren)
 	rt.root.leaf = true
 	rt.root.level = 1
 	return &rt
 }
 
+// Params returns the branching factors of tree, which differ from those given
+// to NewTree if it was created WithTargetNodeBytes.
+func (tree *Rtree) Params() (MinChildren, MaxChildren int) {
+	return tree.MinChildren, tree.MaxChildren
+}
+
+// checkBranching reports whether MinChildren and MaxChildren are branching
+// factors NewTree accepts.
+func checkBranching(MinChildren, MaxChildren int) error {
+	switch {
+	case MinChildren < 1:
+		return fmt.Errorf("rtreego: MinChildren %d is less than 1", MinChildren)
+	case MaxChildren < 2:
+		return fmt.Errorf("rtreego: MaxChildren %d is less than 2", MaxChildren)
+	case 2*MinChildren > MaxChildren+1:
+		return fmt.Errorf("rtreego: MinChildren %d is greater than (MaxChildren+1)/2 for MaxChildren %d", MinChildren, MaxChildren)
+	}
+	return nil
+}
+
+// Clear removes every object from tree, leaving it as NewTree created it,
+// with the same branching factors and options.  The root node and its
+// entries are reused, so a tree that is refilled to a similar size doesn't
+// reallocate them.
+func (tree *Rtree) Clear() {
+	root := tree.mutableRoot()
+	clear(root.entries)
+	*root = node{
+		leaf:    true,
+		level:   1,
+		gen:     root.gen,
+		entries: root.entries[:0],
+	}
+	tree.root = root
+	tree.size = 0
+	tree.height = 1
+	tree.lastSplit = 0
+	tree.dirty = nil
+	clear(tree.seqs)
+	tree.nextSeq = 0
+}
+
+// Clone returns an independent copy of tree, with the same configuration and
+// its own nodes, that stores the same objects.  Changes to either tree don't
+// affect the other.
+func (tree *Rtree) Clone() *Rtree {
+	c := *tree
+	c.gen = 0
+	c.dirty = nil
+	c.pending = nil
+	c.free = nil
+	c.seqs = maps.Clone(tree.seqs)
+	c.root = tree.root.deepCopy(nil, &c)
+	return &c
+}
+
+// deepCopy copies n and the nodes below it for the tree c, with parent as
+// the parent of the copy.  Bounding boxes are never modified in place, so they
+// are shared.
+func (n *node) deepCopy(parent *node, c *Rtree) *node {
+	cp := &node{
+		parent:  parent,
+		leaf:    n.leaf,
+		level:   n.level,
+		dirty:   n.dirty,
+		entries: make([]entry, len(n.entries), max(len(n.entries), c.MaxChildren+1)),
+	}
+	copy(cp.entries, n.entries)
+	for i, e := range cp.entries {
+		if e.child != nil {
+			cp.entries[i].child = e.child.deepCopy(cp, c)
+		}
+	}
+	if cp.dirty {
+		c.dirty = append(c.dirty, cp)
+	}
+	return cp
+}
+
 // Size returns the number of objects currently stored in tree.
 func (tree *Rtree) Size() int {
 	return tree.size
@@ -48,18 +195,400 @@ func (tree *Rtree) Depth() int {
 	return tree.height
 }
 
+// Bounds returns a copy of the bounding box of every object stored in tree,
+// or nil if the tree is empty.
+func (tree *Rtree) Bounds() *Rect {
+	if len(tree.root.entries) == 0 {
+		return nil
+	}
+	bb := *tree.root.computeBoundingBox()
+	return &bb
+}
+
+// Extent returns the most-negative and most-positive corners of the bounding
+// box of every object stored in tree, or ErrEmptyTree if there are none.
+func (tree *Rtree) Extent() (min, max Point, err error) {
+	bb := tree.Bounds()
+	if bb == nil {
+		return min, max, ErrEmptyTree
+	}
+	return bb.p, bb.q, nil
+}
+
+// OverlapsTree reports whether the bounding boxes of tree and other
+// intersect.  An empty tree overlaps nothing.
+func (tree *Rtree) OverlapsTree(other *Rtree) bool {
+	bb, obb := tree.Bounds(), other.Bounds()
+	if bb == nil || obb == nil {
+		return false
+	}
+	return intersect(bb, obb)
+}
+
+// TreeStats describes the shape of a tree.
+type TreeStats struct {
+	Leaves   int // number of leaf nodes
+	Internal int // number of other nodes, including a non-leaf root
+
+	// Fill[i] is the average number of entries of the nodes at level i+1,
+	// counting the leaves as level 1 and the root as level Depth(),
+	// divided by MaxChildren.
+	Fill []float64
+
+	// Overlap is the total volume of the pairwise intersections of the
+	// bounding boxes of sibling nodes, divided by the total volume of those
+	// boxes, or 0 if they have none.  It is 0 when no two sibling nodes
+	// overlap and grows as searches have to descend into more of them.
+	Overlap float64
+}
+
+// Stats traverses tree and returns statistics on its shape.
+func (tree *Rtree) Stats() TreeStats {
+	stats := TreeStats{Fill: make([]float64, tree.height)}
+	nodes := make([]int, tree.height)
+	overlap, volume := 0.0, 0.0
+	tree.root.walkNodes(func(n *node) {
+		if n.leaf {
+			stats.Leaves++
+		} else {
+			stats.Internal++
+			overlap += n.childOverlap()
+			for _, e := range n.entries {
+				volume += e.bb.size()
+			}
+		}
+		nodes[n.level-1]++
+		stats.Fill[n.level-1] += float64(len(n.entries))
+	})
+	if volume > 0 {
+		stats.Overlap = overlap / volume
+	}
+	for i, count := range nodes {
+		if count > 0 {
+			stats.Fill[i] /= float64(count) * float64(tree.MaxChildren)
+		}
+	}
+	return stats
+}
+
+// TotalOverlap returns the total volume of the pairwise intersections of the
+// bounding boxes of sibling nodes, summed over every internal node: the
+// numerator of TreeStats.Overlap, for comparing the quality of trees holding
+// the same objects.  Lower is better.
+func (tree *Rtree) TotalOverlap() float64 {
+	overlap := 0.0
+	tree.root.walkNodes(func(n *node) {
+		if !n.leaf {
+			overlap += n.childOverlap()
+		}
+	})
+	return overlap
+}
+
+// childOverlap returns the total volume of the pairwise intersections of the
+// bounding boxes of the entries of n.
+func (n *node) childOverlap() float64 {
+	overlap := 0.0
+	for i, e := range n.entries {
+		for _, other := range n.entries[i+1:] {
+			overlap += OverlapVolume(e.bb, other.bb)
+		}
+	}
+	return overlap
+}
+
+// SizeHistogram bins the bounding-box sizes of the stored objects into the
+// specified number of logarithmically spaced buckets spanning the smallest
+// and largest observed sizes.  Objects with zero size fall into the first
+// bucket.  It returns nil if buckets is not positive.
+func (tree *Rtree) SizeHistogram(buckets int) []int {
+	if buckets <= 0 {
+		return nil
+	}
+	hist := make([]int, buckets)
+
+	sizes := make([]float64, 0, tree.size)
+	lo, hi := math.MaxFloat64, 0.0
+	tree.root.walk(func(e entry) bool {
+		s := e.bb.size()
+		sizes = append(sizes, s)
+		if s > 0 && s < lo {
+			lo = s
+		}
+		if s > hi {
+			hi = s
+		}
+		return true
+	})
+
+	span := math.Log(hi) - math.Log(lo)
+	for _, s := range sizes {
+		i := 0
+		if s > 0 && span > 0 {
+			i = int((math.Log(s) - math.Log(lo)) / span * float64(buckets))
+		}
+		if i >= buckets {
+			i = buckets - 1
+		}
+		hist[i]++
+	}
+	return hist
+}
+
+// Walk calls fn for each object stored in tree, in no particular order,
+// until fn returns false.  fn must not modify the tree.
+func (tree *Rtree) Walk(fn func(obj Spatial) bool) {
+	tree.root.walk(func(e entry) bool {
+		return fn(e.obj)
+	})
+}
+
+// WalkOrdered calls fn on every object stored in tree in the order they were
+// inserted, with their insertion numbers, until fn returns false.  Numbers
+// start at 0 and increase with each object inserted, by Insert, InsertBatch
+// or Merge, and are kept when objects are updated or the tree restructured;
+// an object inserted again after being deleted gets a new number.  An object
+// stored more than once has the number of its latest insertion.  It panics
+// unless tree was created WithSequenceNumbers.
+func (tree *Rtree) WalkOrdered(fn func(seq uint64, obj Spatial) bool) {
+	if tree.seqs == nil {
+		panic(fmt.Errorf("rtreego: tree wasn't created WithSequenceNumbers"))
+	}
+	type numbered struct {
+		seq uint64
+		obj Spatial
+	}
+	objs := make([]numbered, 0, tree.size)
+	tree.Walk(func(obj Spatial) bool {
+		objs = append(objs, numbered{tree.seqs[obj], obj})
+		return true
+	})
+	slices.SortStableFunc(objs, func(a, b numbered) int {
+		return cmp.Compare(a.seq, b.seq)
+	})
+	for _, o := range objs {
+		if !fn(o.seq, o.obj) {
+			return
+		}
+	}
+}
+
+// number gives obj the next insertion number, if tree keeps them.
+func (tree *Rtree) number(obj Spatial) {
+	if tree.seqs != nil {
+		tree.seqs[obj] = tree.nextSeq
+		tree.nextSeq++
+	}
+}
+
+// All returns every object stored in tree, in no particular order.
+func (tree *Rtree) All() []Spatial {
+	objs := make([]Spatial, 0, tree.size)
+	tree.Walk(func(obj Spatial) bool {
+		objs = append(objs, obj)
+		return true
+	})
+	return objs
+}
+
+// AllOrderedByBounds returns an iterator over every stored object in
+// ascending lexicographic order of the most-negative corners of their
+// bounding boxes, so the order depends only on the set of stored objects and
+// not on the shape of the tree.  Objects whose corners tie are ordered by
+// their most-positive corners, and objects with identical boxes by their IDs
+// if they are Identifiable.  Among identical boxes, objects that aren't
+// Identifiable come first, in tree order.
+//
+// The objects are collected and sorted when iteration begins.
+func (tree *Rtree) AllOrderedByBounds() iter.Seq[Spatial] {
+	return func(yield func(Spatial) bool) {
+		entries := make([]entry, 0, tree.size)
+		tree.root.walk(func(e entry) bool {
+			entries = append(entries, e)
+			return true
+		})
+		sortCanonical(entries)
+		for _, e := range entries {
+			if !yield(e.obj) {
+				return
+			}
+		}
+	}
+}
+
+// WalkBFS visits the nodes of tree in breadth-first order, passing each
+// node's bounding box, its level (0 for the root, increasing towards the
+// leaves) and whether it is a leaf.  If visit returns false, the children of
+// that node are not visited.  The bounding boxes are copies and may be kept
+// or modified by visit.  Nothing is visited in an empty tree.
+func (tree *Rtree) WalkBFS(visit func(bb *Rect, level int, isLeaf bool) bool) {
+	if len(tree.root.entries) == 0 {
+		return
+	}
+	type item struct {
+		n     *node
+		bb    *Rect
+		level int
+	}
+	queue := []item{{tree.root, tree.root.computeBoundingBox(), 0}}
+	for len(queue) > 0 {
+		it := queue[0]
+		queue = queue[1:]
+		bb := *it.bb
+		if !visit(&bb, it.level, it.n.leaf) || it.n.leaf {
+			continue
+		}
+		for _, e := range it.n.entries {
+			queue = append(queue, item{e.child, e.bb, it.level + 1})
+		}
+	}
+}
+
+// Visitor is called by Traverse for the nodes and objects of a tree.
+type Visitor interface {
+	// VisitNode is called with the bounding box of each node and its
+	// depth, 0 for the root and increasing towards the leaves, and
+	// reports whether to visit what lies below the node.
+	VisitNode(bb *Rect, depth int) (descend bool)
+
+	// VisitLeaf is called with each object stored in a leaf that is
+	// descended into, and the bounding box it is stored with.
+	VisitLeaf(obj Spatial, bb *Rect)
+}
+
+// Traverse visits the nodes of tree depth-first, each node's children in
+// order after the node itself, calling v.VisitNode for every node and
+// v.VisitLeaf for the objects of every leaf it descends into.  The bounding
+// boxes are copies and may be kept or modified by v, which must not modify
+// the tree.  Nothing is visited in an empty tree.
+func (tree *Rtree) Traverse(v Visitor) {
+	if len(tree.root.entries) == 0 {
+		return
+	}
+	tree.root.traverse(tree.root.computeBoundingBox(), 0, v)
+}
+
+func (n *node) traverse(bb *Rect, depth int, v Visitor) {
+	nodeBB := *bb
+	if !v.VisitNode(&nodeBB, depth) {
+		return
+	}
+	for _, e := range n.entries {
+		if n.leaf {
+			objBB := *e.bb
+			v.VisitLeaf(e.obj, &objBB)
+		} else {
+			e.child.traverse(e.bb, depth+1, v)
+		}
+	}
+}
+
+// GetAllBoundingBoxes returns copies of the bounding boxes of all the nodes
+// of the tree below the root, which shows how well the tree is structured:
+// the less the boxes of sibling nodes overlap, the fewer nodes searches
+// visit.
+func (tree *Rtree) GetAllBoundingBoxes() []*Rect {
+	return tree.root.appendBoxes(nil)
+}
+
+func (n *node) appendBoxes(boxes []*Rect) []*Rect {
+	if n.leaf {
+		return boxes
+	}
+	for _, e := range n.entries {
+		bb := *e.bb
+		boxes = e.child.appendBoxes(append(boxes, &bb))
+	}
+	return boxes
+}
+
+// DepthBox is the bounding box of a node with its depth in the tree, 0 for
+// the root and increasing towards the leaves.
+type DepthBox struct {
+	Rect  *Rect
+	Depth int
+}
+
+// GetAllBoundingBoxesWithDepth returns the same boxes as GetAllBoundingBoxes,
+// in the same order, each with the depth of its node.
+func (tree *Rtree) GetAllBoundingBoxesWithDepth() []DepthBox {
+	return tree.root.appendDepthBoxes(nil, 1)
+}
+
+func (n *node) appendDepthBoxes(boxes []DepthBox, depth int) []DepthBox {
+	if n.leaf {
+		return boxes
+	}
+	for _, e := range n.entries {
+		bb := *e.bb
+		boxes = e.child.appendDepthBoxes(append(boxes, DepthBox{&bb, depth}), depth+1)
+	}
+	return boxes
+}
+
+// BoundingBoxesAtDepth returns copies of the bounding boxes of the nodes at
+// the given depth, 0 for the root and increasing towards the leaves, from the
+// first to the last node of that depth.  There are none outside the range
+// [0, Depth()), nor in an empty tree.
+func (tree *Rtree) BoundingBoxesAtDepth(depth int) []*Rect {
+	if len(tree.root.entries) == 0 || depth < 0 || depth >= tree.Depth() {
+		return nil
+	}
+	if depth == 0 {
+		return []*Rect{tree.root.computeBoundingBox()}
+	}
+	var boxes []*Rect
+	tree.root.walkNodes(func(n *node) {
+		if n.leaf || tree.height-n.level != depth-1 {
+			return
+		}
+		for _, e := range n.entries {
+			bb := *e.bb
+			boxes = append(boxes, &bb)
+		}
+	})
+	return boxes
+}
+
 // node represents a tree node of an Rtree.
 type node struct {
 	parent  *node
 	leaf    bool
 	entries []entry
 	level   int // node depth in the Rtree
+	gen     uint64
+	dirty   bool // whether the node is in Rtree.dirty
+}
+
+// walkNodes calls fn for n and each node below it, parents first.
+func (n *node) walkNodes(fn func(n *node)) {
+	fn(n)
+	if !n.leaf {
+		for _, e := range n.entries {
+			e.child.walkNodes(fn)
+		}
+	}
 }
 
 func (n *node) String() string {
 	return fmt.Sprintf("node{leaf: %v, entries: %v}", n.leaf, n.entries)
 }
 
+// walk calls fn for each leaf entry in the subtree rooted at n until fn
+// returns false.  It reports whether the walk ran to completion.
+func (n *node) walk(fn func(e entry) bool) bool {
+	for _, e := range n.entries {
+		if n.leaf {
+			if !fn(e) {
+				return false
+			}
+		} else if !e.child.walk(fn) {
+			return false
+		}
+	}
+	return true
+}
+
 // entry represents a spatial index record stored in a tree node.
 type entry struct {
 	bb    *Rect // bounding-box of all children of this entry
@@ -75,25 +604,249 @@ func (e entry) String() string {
 }
 
 // Any type that implements Spatial can be stored in an Rtree and queried.
+//
+// Objects whose bounding boxes have NaN coordinates can be stored and
+// deleted, but they intersect no rectangle, are at NaN distance from every
+// point and so are never returned as nearest neighbors, and don't contribute
+// their NaN coordinates to the bounding boxes of the nodes above them.
+// Likewise, queries with NaN coordinates find nothing.
 type Spatial interface {
 	Bounds() *Rect
 }
 
+// Identifiable is implemented by objects with a stable identifier.  It is
+// used to order objects with identical bounding boxes canonically, so that
+// the order doesn't depend on the history of the tree.
+type Identifiable interface {
+	ID() string
+}
+
+// sortCanonical sorts entries by the corners of their bounding boxes, then by
+// the IDs of Identifiable objects, which come after the other objects with
+// the same box.  Entries that still tie keep their order.
+func sortCanonical(entries []entry) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		return lessCanonical(entries[i].bb, entries[j].bb, entries[i].obj, entries[j].obj)
+	})
+}
+
+// lessCanonical orders objects with the bounding boxes b1 and b2 as
+// sortCanonical does.
+func lessCanonical(b1, b2 *Rect, obj1, obj2 Spatial) bool {
+	if lessCorners(b1, b2) || lessCorners(b2, b1) {
+		return lessCorners(b1, b2)
+	}
+	id1, ok1 := obj1.(Identifiable)
+	id2, ok2 := obj2.(Identifiable)
+	if ok1 != ok2 {
+		return ok2
+	}
+	return ok1 && id1.ID() < id2.ID()
+}
+
+// SpatialIndex is the set of core operations supported by a spatial index.
+// Rtree is the canonical implementation; code that depends only on
+// SpatialIndex can be benchmarked against alternative indexes.
+type SpatialIndex interface {
+	Insert(obj Spatial)
+	Delete(obj Spatial) bool
+	SearchIntersect(bb *Rect, filters ...Filter) []Spatial
+	NearestNeighbor(p Point) Spatial
+	Size() int
+}
+
+var _ SpatialIndex = (*Rtree)(nil)
+
+// Comparator compares two spatial objects and reports whether they should be
+// considered the same object.
+type Comparator func(obj1, obj2 Spatial) (equal bool)
+
+// Comparable is implemented by objects that define their own equality, such
+// as values of types that can't be compared with ==.  Delete, Update and
+// Contains with a nil Comparator use it to find the stored object equal to
+// the one they are given.
+//
+// Objects that are Equal must report equal bounds: the tree is searched only
+// in the subtrees containing the bounds of the object given, so an equal
+// object stored with different bounds may not be found.
+type Comparable interface {
+	Equal(other Spatial) bool
+}
+
+// defaultComparator compares objects with the Equal method of obj1 if it is
+// Comparable, and by interface equality otherwise.
+func defaultComparator(obj1, obj2 Spatial) bool {
+	if c, ok := obj1.(Comparable); ok {
+		return c.Equal(obj2)
+	}
+	return obj1 == obj2
+}
+
 // Insertion
 
 // Insert inserts a spatial object into the tree.  If insertion
 // causes a leaf node to overflow, the tree is rebalanced automatically.
 //
+// A leaf whose entries all have identical bounding boxes, such as markers
+// stacked at a single coordinate, cannot be usefully split, so it is allowed
+// to grow beyond MaxChildren as an overflow bucket.  Once an object with
+// different bounds is added to it, that object moves to a new leaf with as
+// few of the identical objects as needed to fill it, and the rest stay in the
+// bucket.
+//
 // Implemented per Section 3.2 of "R-trees: A Dynamic Index Structure for
 // Spatial Searching" by A. Guttman, Proceedings of ACM SIGMOD, p. 47-57, 1984.
 func (tree *Rtree) Insert(obj Spatial) {
 	e := entry{obj.Bounds(), nil, obj}
+	if err := tree.checkUniverse(obj, e.bb); err != nil {
+		panic(err)
+	}
+	tree.Compact()
+	tree.number(obj)
+	tree.splitLevel = 0
+	tree.reinserted = 0
 	tree.insert(e, 1)
+	tree.lastSplit = tree.splitLevel
 	tree.size++
+	tree.changed()
+}
+
+// InsertWithCallback is like Insert, but then calls onReorg with the objects
+// already in the tree that the insertion moved to another leaf, as for
+// invalidating caches keyed by leaf: by splitting their leaf, by reinserting
+// them, by condensing the deletions left by a tree created WithLazyCondense,
+// or by rebuilding a tree created WithAutoOptimize, which moves every object.
+// The slice is empty, not nil, if no object moved, and never holds obj
+// itself.  Reinserted objects are reported even if they return to their own
+// leaf.  Objects are told apart as Delete matches them.
+func (tree *Rtree) InsertWithCallback(obj Spatial, onReorg func(moved []Spatial)) {
+	moved := []Spatial{}
+	tree.moved = &moved
+	tree.Insert(obj)
+	tree.moved = nil
+
+	distinct := moved[:0]
+	for _, m := range moved {
+		if defaultComparator(obj, m) || slices.ContainsFunc(distinct, func(d Spatial) bool { return defaultComparator(m, d) }) {
+			continue
+		}
+		distinct = append(distinct, m)
+	}
+	onReorg(distinct)
+}
+
+// InsertChecked is like Insert, but first validates the bounding box of obj,
+// as BulkLoadChecked does.  If the bounds are nil or have a NaN coordinate or
+// an inverted dimension, it returns an error naming obj and leaves the tree
+// unchanged.  A Rect always has Dim coordinates, so its dimensionality can't
+// be wrong.
+func (tree *Rtree) InsertChecked(obj Spatial) error {
+	bb := obj.Bounds()
+	if bb == nil {
+		return fmt.Errorf("rtreego: object %v: nil bounds", obj)
+	}
+	if err := bb.check(); err != nil {
+		return fmt.Errorf("rtreego: object %v: %w", obj, err)
+	}
+	if err := tree.checkUniverse(obj, bb); err != nil {
+		return err
+	}
+	tree.Insert(obj)
+	return nil
+}
+
+// checkUniverse returns an error if tree was created WithUniverse and bb,
+// the bounds of obj, don't lie within the universe.  Bounds with NaN
+// coordinates never do.
+func (tree *Rtree) checkUniverse(obj Spatial, bb *Rect) error {
+	if tree.universe != nil && !tree.universe.ContainsRect(bb) {
+		return fmt.Errorf("rtreego: object %v with bounds %v: %w", obj, bb, ErrOutsideUniverse)
+	}
+	return nil
+}
+
+// LastInsertSplit reports whether the most recent call to Insert split any
+// nodes, and if so the level of the highest one, counting the leaves as level
+// 1 and the root as level Depth().  A split of the root adds a level to the
+// tree, so in that case level is Depth()-1 after the insert.
+func (tree *Rtree) LastInsertSplit() (split bool, level int) {
+	return tree.lastSplit > 0, tree.lastSplit
+}
+
+// Contains reports whether an object equal to obj according to eq is stored
+// in the tree.  Only the subtrees whose bounding boxes contain obj.Bounds()
+// are searched.  A nil eq compares objects as Delete does.
+func (tree *Rtree) Contains(obj Spatial, eq Comparator) bool {
+	if eq == nil {
+		eq = defaultComparator
+	}
+	return tree.findLeaf(tree.root, obj, eq) != nil
+}
+
+// InsertUnique inserts obj into the tree unless an object equal to it
+// according to eq is already stored, and reports whether obj was inserted.
+// A nil eq compares objects as Delete does.
+//
+// As with Contains, only the subtrees containing obj.Bounds() are searched,
+// so eq must only report objects with equal bounds as equal; a stored object
+// that eq considers equal but whose bounds don't cover obj's is not detected
+// and obj is inserted alongside it.
+func (tree *Rtree) InsertUnique(obj Spatial, eq Comparator) bool {
+	if tree.Contains(obj, eq) {
+		return false
+	}
+	tree.Insert(obj)
+	return true
+}
+
+// InsertAllUnique inserts each of objs with InsertUnique and returns the
+// indices of the objects that were skipped as duplicates, either of objects
+// already in the tree or of earlier elements of objs.
+//
+// Each duplicate check searches every subtree whose bounding box contains
+// the object's bounding box, comparing against the objects in the leaves it
+// reaches, so its cost grows with the number of overlapping stored objects.
+func (tree *Rtree) InsertAllUnique(objs []Spatial, eq Comparator) []int {
+	var skipped []int
+	for i, obj := range objs {
+		if !tree.InsertUnique(obj, eq) {
+			skipped = append(skipped, i)
+		}
+	}
+	return skipped
+}
+
+// InsertOrMerge inserts obj into the tree, first merging it with any stored
+// objects whose bounding boxes intersect its own.  If there are such objects,
+// merge is called with them and obj, they are deleted from the tree and the
+// object returned by merge is inserted in their place and returned.  If
+// nothing intersects obj, merge is not called and obj itself is inserted and
+// returned.
+//
+// Only the objects intersecting obj are merged; if the merged object grows
+// to intersect further objects, these are left alone.  If the tree was
+// created WithUniverse and the merged object lies outside it, InsertOrMerge
+// panics before deleting anything.
+func (tree *Rtree) InsertOrMerge(obj Spatial, merge func(existing []Spatial, new Spatial) Spatial) Spatial {
+	existing := tree.SearchIntersect(obj.Bounds())
+	if len(existing) == 0 {
+		tree.Insert(obj)
+		return obj
+	}
+	merged := merge(existing, obj)
+	if err := tree.checkUniverse(merged, merged.Bounds()); err != nil {
+		panic(err)
+	}
+	for _, e := range existing {
+		tree.Delete(e)
+	}
+	tree.Insert(merged)
+	return merged
 }
 
 // insert adds the specified entry to the tree at the specified level.
 func (tree *Rtree) insert(e entry, level int) {
+	tree.root = tree.mutableRoot()
 	leaf := tree.chooseNode(tree.root, e, level)
 	leaf.entries = append(leaf.entries, e)
 
@@ -102,48 +855,127 @@ func (tree *Rtree) insert(e entry, level int) {
 		e.child.parent = leaf
 	}
 
-	// split leaf if overflows
+	// split leaf if overflows, unless no split could separate its entries
 	var split *node
-	if len(leaf.entries) > tree.MaxChildren {
-		leaf, split = leaf.split(tree.MinChildren)
+	if len(leaf.entries) > tree.MaxChildren && !leaf.isBucket() {
+		leaf, split = tree.overflow(leaf)
 	}
 	root, splitRoot := tree.adjustTree(leaf, split)
 	if splitRoot != nil {
 		oldRoot := root
 		tree.height++
-		tree.root = &node{
-			parent: nil,
-			level:  tree.height,
-			entries: []entry{
-				{bb: oldRoot.computeBoundingBox(), child: oldRoot},
-				{bb: splitRoot.computeBoundingBox(), child: splitRoot},
-			},
-		}
+		tree.root = tree.newNode(nil, false, tree.height)
+		tree.root.entries = append(tree.root.entries,
+			entry{bb: oldRoot.computeBoundingBox(), child: oldRoot},
+			entry{bb: splitRoot.computeBoundingBox(), child: splitRoot},
+		)
 		oldRoot.parent = tree.root
 		splitRoot.parent = tree.root
 	}
+
+	for len(tree.pending) > 0 {
+		p := tree.pending[0]
+		tree.pending = tree.pending[1:]
+		tree.insert(p.e, p.level)
+	}
+}
+
+// isBucket reports whether n is a leaf whose entries all have the same
+// bounding box.
+func (n *node) isBucket() bool {
+	if !n.leaf {
+		return false
+	}
+	for _, e := range n.entries[1:] {
+		if !e.bb.Equal(n.entries[0].bb) {
+			return false
+		}
+	}
+	return true
 }
 
+// InsertHeuristic selects how Insert chooses the subtree that receives a new
+// object; see WithInsertHeuristic.
+type InsertHeuristic int
+
+const (
+	// LeastEnlargement descends into the child whose bounding box needs the
+	// least enlargement to contain the new object, as in Guttman's original
+	// paper.  It is the default.
+	LeastEnlargement InsertHeuristic = iota
+	// LeastOverlap is the heuristic of the R*-tree: among the nodes just
+	// above the level the object is added to, it descends into the child
+	// whose enlargement least increases its overlap with its siblings,
+	// breaking ties by least enlargement.  Higher up it falls back to
+	// LeastEnlargement.  It gives trees whose leaves overlap less, at a cost
+	// quadratic in MaxChildren per insertion.
+	//
+	// Implemented per Section 4.1 of "The R*-tree: An Efficient and Robust
+	// Access Method for Points and Rectangles" by N. Beckmann, H.-P. Kriegel,
+	// R. Schneider and B. Seeger, Proceedings of ACM SIGMOD, p. 322-331, 1990.
+	LeastOverlap
+)
+
 // chooseNode finds the node at the specified level to which e should be added.
 func (tree *Rtree) chooseNode(n *node, e entry, level int) *node {
 	if n.leaf || n.level == level {
 		return n
 	}
 
-	// find the entry whose bb needs least enlargement to include obj
-	diff := math.MaxFloat64
-	var chosen entry
-	var bb Rect
-	for _, en := range n.entries {
-		initBoundingBox(&bb, en.bb, e.bb)
-		d := bb.size() - en.bb.size()
+	var ind int
+	if tree.heuristic == LeastOverlap && n.level == level+1 {
+		ind = n.leastOverlap(e.bb)
+	} else {
+		ind = n.leastEnlargement(e.bb)
+	}
+	return tree.chooseNode(tree.mutableChild(n, ind), e, level)
+}
+
+// leastEnlargement returns the index of the entry of n whose bb needs least
+// enlargement to include bb, breaking ties by least size.
+func (n *node) leastEnlargement(bb *Rect) int {
+	// start from the first entry, so that one is chosen even if every
+	// enlargement is NaN
+	diff := math.Inf(1)
+	chosen := n.entries[0]
+	var ind int
+	var enlarged Rect
+	for i, en := range n.entries {
+		initBoundingBox(&enlarged, en.bb, bb)
+		d := enlarged.size() - en.bb.size()
 		if d < diff || (d == diff && en.bb.size() < chosen.bb.size()) {
 			diff = d
 			chosen = en
+			ind = i
 		}
 	}
+	return ind
+}
 
-	return tree.chooseNode(chosen.child, e, level)
+// leastOverlap returns the index of the entry of n whose enlargement to
+// include bb least increases its total overlap with the other entries,
+// breaking ties as leastEnlargement does.
+func (n *node) leastOverlap(bb *Rect) int {
+	diff, enlargement := math.Inf(1), math.Inf(1)
+	chosen := n.entries[0]
+	var ind int
+	var enlarged Rect
+	for i, en := range n.entries {
+		initBoundingBox(&enlarged, en.bb, bb)
+		d := 0.0
+		for j, other := range n.entries {
+			if j != i {
+				d += OverlapVolume(&enlarged, other.bb) - OverlapVolume(en.bb, other.bb)
+			}
+		}
+		a := enlarged.size() - en.bb.size()
+		if d < diff || d == diff && (a < enlargement || a == enlargement && en.bb.size() < chosen.bb.size()) {
+			diff, enlargement = d, a
+			chosen = en
+			ind = i
+		}
+	}
+	return ind
 }
 
 // adjustTree splits overflowing nodes and propagates the changes upwards.
@@ -169,7 +1001,7 @@ func (tree *Rtree) adjustTree(n, nn *node) (*node, *node) {
 
 	// If the new entry overflows the parent, split the parent and propagate.
 	if len(n.parent.entries) > tree.MaxChildren {
-		return tree.adjustTree(n.parent.split(tree.MinChildren))
+		return tree.adjustTree(tree.overflow(n.parent))
 	}
 
 	// Otherwise keep propagating changes upwards.
@@ -190,17 +1022,58 @@ func (n *node) getEntry() *entry {
 
 // computeBoundingBox finds the MBR of the children of n.
 func (n *node) computeBoundingBox() (bb *Rect) {
-	childBoxes := make([]*Rect, len(n.entries))
-	for i, e := range n.entries {
-		childBoxes[i] = e.bb
+	if len(n.entries) == 1 {
+		return n.entries[0].bb
+	}
+	bb = boundingBox(n.entries[0].bb, n.entries[1].bb)
+	for _, e := range n.entries[2:] {
+		bb.enlarge(e.bb)
 	}
-	bb = boundingBoxN(childBoxes...)
 	return
 }
 
+// maxFreeNodes bounds the number of detached nodes a tree keeps for reuse.
+// Deletions and splits roughly balance under churn, so a few suffice.
+const maxFreeNodes = 16
+
+// newNode returns an empty node for the current write, reusing one freed by
+// freeNode if possible.
+func (tree *Rtree) newNode(parent *node, leaf bool, level int) *node {
+	if k := len(tree.free); k > 0 {
+		n := tree.free[k-1]
+		tree.free[k-1] = nil
+		tree.free = tree.free[:k-1]
+		n.parent, n.leaf, n.level, n.gen = parent, leaf, level, tree.gen
+		return n
+	}
+	return &node{
+		parent:  parent,
+		leaf:    leaf,
+		level:   level,
+		gen:     tree.gen,
+		entries: make([]entry, 0, tree.MaxChildren+1),
+	}
+}
+
+// freeNode records that n has been detached from the tree, so that newNode
+// may reuse it and its entries.  In a copy-on-write tree only nodes created
+// by the current write are reused, since older ones may still be seen by
+// snapshots, and nodes waiting for Compact are never reused, since it
+// checks whether they are still attached.
+func (tree *Rtree) freeNode(n *node) {
+	if tree.gen != 0 && n.gen != tree.gen || n.dirty || len(tree.free) >= maxFreeNodes {
+		return
+	}
+	entries := n.entries[:cap(n.entries)]
+	clear(entries)
+	*n = node{entries: entries[:0]}
+	tree.free = append(tree.free, n)
+}
+
 // split splits a node into two groups while attempting to minimize the
-// bounding-box area of the resulting groups.
-func (n *node) split(minGroupSize int) (left, right *node) {
+// bounding-box area of the resulting groups.  n is reused as the left node,
+// and sibling, which must be empty, as the right one.
+func (n *node) split(sibling *node, minGroupSize int) (left, right *node) {
 	// find the initial split
 	l, r := n.pickSeeds()
 	leftSeed, rightSeed := n.entries[l], n.entries[r]
@@ -211,13 +1084,10 @@ func (n *node) split(minGroupSize int) (left, right *node) {
 
 	// setup the new split nodes, but re-use n as the left node
 	left = n
-	left.entries = []entry{leftSeed}
-	right = &node{
-		parent:  n.parent,
-		leaf:    n.leaf,
-		level:   n.level,
-		entries: []entry{rightSeed},
-	}
+	left.entries = append(make([]entry, 0, cap(n.entries)), leftSeed)
+	right = sibling
+	right.parent, right.leaf, right.level, right.gen = n.parent, n.leaf, n.level, n.gen
+	right.entries = append(right.entries, rightSeed)
 
 	// TODO
 	if rightSeed.child != nil {
@@ -257,12 +1127,10 @@ func assign(e entry, group *node) {
 func assignGroup(e entry, left, right *node) {
 	leftBB := left.computeBoundingBox()
 	rightBB := right.computeBoundingBox()
-	leftEnlarged := boundingBox(leftBB, e.bb)
-	rightEnlarged := boundingBox(rightBB, e.bb)
 
 	// first, choose the group that needs the least enlargement
-	leftDiff := leftEnlarged.size() - leftBB.size()
-	rightDiff := rightEnlarged.size() - rightBB.size()
+	leftDiff := unionSize(leftBB, e.bb) - leftBB.size()
+	rightDiff := unionSize(rightBB, e.bb) - rightBB.size()
 	if diff := leftDiff - rightDiff; diff < 0 {
 		assign(e, left)
 		return
@@ -294,7 +1162,7 @@ func (n *node) pickSeeds() (int, int) {
 	maxWastedSpace := -1.0
 	for i, e1 := range n.entries {
 		for j, e2 := range n.entries[i+1:] {
-			d := boundingBox(e1.bb, e2.bb).size() - e1.bb.size() - e2.bb.size()
+			d := unionSize(e1.bb, e2.bb) - e1.bb.size() - e2.bb.size()
 			if d > maxWastedSpace {
 				maxWastedSpace = d
 				left, right = i, j+i+1
@@ -310,8 +1178,8 @@ func pickNext(left, right *node, entries []entry) (next int) {
 	leftBB := left.computeBoundingBox()
 	rightBB := right.computeBoundingBox()
 	for i, e := range entries {
-		d1 := boundingBox(leftBB, e.bb).size() - leftBB.size()
-		d2 := boundingBox(rightBB, e.bb).size() - rightBB.size()
+		d1 := unionSize(leftBB, e.bb) - leftBB.size()
+		d2 := unionSize(rightBB, e.bb) - rightBB.size()
 		d := math.Abs(d1 - d2)
 		if d > maxDiff {
 			maxDiff = d
@@ -324,128 +1192,1423 @@ func pickNext(left, right *node, entries []entry) (next int) {
 // Deletion
 
 // Delete removes an object from the tree.  If the object is not found, ok
-// is false; otherwise ok is true.
+// is false; otherwise ok is true.  Objects are matched by interface equality,
+// or by their Equal method if they are Comparable.  In a tree created with WithLazyCondense
+// the restructuring that follows is postponed; see Compact.
 //
 // Implemented per Section 3.3 of "R-trees: A Dynamic Index Structure for
 // Spatial Searching" by A. Guttman, Proceedings of ACM SIGMOD, p. 47-57, 1984.
 func (tree *Rtree) Delete(obj Spatial) bool {
-	n := tree.findLeaf(tree.root, obj)
+	_, ok := tree.DeleteAndGet(obj)
+	return ok
+}
+
+// DeleteAndGet removes an object from the tree as Delete does, and returns
+// the object that was stored, which may be a different value than obj if
+// it is Comparable.  If the object is not found, ok is false and the
+// returned object is nil.
+func (tree *Rtree) DeleteAndGet(obj Spatial) (Spatial, bool) {
+	n := tree.findMutableLeaf(obj, defaultComparator)
 	if n == nil {
-		return false
+		return nil, false
 	}
 
 	ind := -1
 	for i, e := range n.entries {
-		if e.obj == obj {
+		if defaultComparator(obj, e.obj) {
 			ind = i
+			break
 		}
 	}
 	if ind < 0 {
-		return false
+		return nil, false
+	}
+	stored := n.entries[ind].obj
+	tree.deleteEntry(n, ind)
+	if tree.seqs != nil && !tree.Contains(stored, nil) {
+		delete(tree.seqs, stored)
 	}
+	tree.changed()
+	return stored, true
+}
 
+// deleteEntry removes the ind-th entry of the leaf n and restructures the
+// tree, either immediately or, in lazy mode, when it is next compacted.
+func (tree *Rtree) deleteEntry(n *node, ind int) {
 	n.entries = append(n.entries[:ind], n.entries[ind+1:]...)
-
-	tree.condenseTree(n)
 	tree.size--
 
-	if !tree.root.leaf && len(tree.root.entries) == 1 {
-		tree.root = tree.root.entries[0].child
+	if tree.deferCondense() {
+		tree.markDirty(n)
+		return
 	}
 
+	tree.condenseTree(n)
+	tree.collapseRoot()
+	tree.flattenSmall()
+}
+
+// markDirty records that the leaf n has lost entries and must be condensed
+// by the next call to Compact.
+func (tree *Rtree) markDirty(n *node) {
+	if !n.dirty && n != tree.root {
+		n.dirty = true
+		tree.dirty = append(tree.dirty, n)
+	}
+}
+
+// Compact restructures the nodes changed by deletions from a tree created
+// with WithLazyCondense, as Delete does immediately for other trees.  It is
+// called automatically by Insert; calling it explicitly moves the cost to a
+// convenient time and gives later searches the tighter structure.  It does
+// nothing if there are no pending changes.
+func (tree *Rtree) Compact() {
+	if len(tree.dirty) == 0 {
+		return
+	}
+	dirty := tree.dirty
+	tree.dirty = nil
+	for _, n := range dirty {
+		n.dirty = false
+		if tree.attached(n) {
+			tree.condenseTree(n)
+		}
+	}
+	tree.collapseRoot()
+	tree.flattenSmall()
+}
+
+// BeginBulkDelete makes the following deletions lazy, as in a tree created
+// WithLazyCondense, until EndBulkDelete condenses all the changed nodes in
+// one pass.  This speeds up bursts of deletions from a tree that otherwise
+// condenses immediately.  Searches remain correct in the meantime, and an
+// Insert compacts the tree as usual.
+func (tree *Rtree) BeginBulkDelete() {
+	tree.bulkDelete = true
+}
+
+// EndBulkDelete ends the bulk deletion started by BeginBulkDelete and
+// restructures the nodes changed since, as Compact does.  The tree then
+// holds the same objects, with tight bounding boxes and no underfull nodes,
+// as if each deletion had condensed it immediately.
+func (tree *Rtree) EndBulkDelete() {
+	tree.bulkDelete = false
+	tree.Compact()
+}
+
+// deferCondense reports whether deletions currently leave the condensation
+// of the changed nodes to the next Compact.
+func (tree *Rtree) deferCondense() bool {
+	return tree.lazyCondense || tree.bulkDelete
+}
+
+// loose reports whether lazy deletions may have left bounding boxes larger
+// than their contents, or even empty nodes.  The boxes still contain their
+// objects, so searches remain correct, but minMaxDist can't be used to prune
+// nearest-neighbor searches, since it relies on every face of a box touching
+// an object.
+func (tree *Rtree) loose() bool {
+	return len(tree.dirty) > 0
+}
+
+// attached reports whether n is still part of the tree, rather than having
+// been dropped by an earlier condensation.
+func (tree *Rtree) attached(n *node) bool {
+	for ; n != tree.root; n = n.parent {
+		if n.parent == nil || n.getEntry() == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// collapseRoot promotes the child of a root with a single child, as often as
+// necessary, since such a root only adds a level to every search.  A root
+// left with no children at all is replaced by an empty leaf.
+func (tree *Rtree) collapseRoot() {
+	for !tree.root.leaf && len(tree.root.entries) == 1 {
+		old := tree.root
+		tree.root = old.entries[0].child
+		tree.root.parent = nil
+		tree.freeNode(old)
+	}
+	if !tree.root.leaf && len(tree.root.entries) == 0 {
+		tree.freeNode(tree.root)
+		tree.root = tree.newNode(nil, true, 1)
+	}
 	tree.height = tree.root.level
+}
+
+// flattenSmall moves every object into a single root leaf once deletions
+// have left no more than MaxChildren of them, so that a tree small enough to
+// fit in one node takes the same shape however it got there.  Otherwise a
+// small MinChildren lets nodes that hold few entries between them survive
+// condensation, keeping levels that only slow down every operation.
+func (tree *Rtree) flattenSmall() {
+	if tree.root.leaf || tree.size > tree.MaxChildren || tree.loose() {
+		return
+	}
+	root := tree.newNode(nil, true, 1)
+	tree.root.walk(func(e entry) bool {
+		root.entries = append(root.entries, e)
+		return true
+	})
+	tree.root = root
+	tree.height = 1
+}
+
+// Update moves obj to newBounds and reports whether it was found.  obj is
+// looked for at obj.Bounds(), so Update must be called before the bounds of
+// obj change; afterwards the tree expects obj.Bounds() to return newBounds.
+//
+// If newBounds still fits within the bounding box of the leaf holding obj,
+// the entry is rewritten in place and only the bounding boxes above it are
+// adjusted.  Otherwise, or if the leaf is an overflowing bucket, obj is
+// deleted and reinserted.
+func (tree *Rtree) Update(obj Spatial, newBounds *Rect) bool {
+	if err := tree.checkUniverse(obj, newBounds); err != nil {
+		panic(err)
+	}
+	n := tree.findMutableLeaf(obj, defaultComparator)
+	if n == nil {
+		return false
+	}
+
+	ind := 0
+	for !defaultComparator(obj, n.entries[ind].obj) {
+		ind++
+	}
+	// an overflowing bucket must keep its entries identical
+	bb := *newBounds
+	if len(n.entries) <= tree.MaxChildren && (n == tree.root || n.getEntry().bb.containsRect(&bb)) {
+		n.entries[ind].bb = &bb
+		tree.adjustTree(n, nil)
+		tree.changed()
+		return true
+	}
+
+	tree.deleteEntry(n, ind)
+	tree.Compact()
+	tree.reinserted = 0
+	tree.insert(entry{&bb, nil, obj}, 1)
+	tree.size++
+	tree.changed()
+	return true
+}
+
+// UpdateOp describes an object that has moved: it is stored in the tree with
+// bounding box OldBounds, and its Bounds method now returns its new box.
+type UpdateOp struct {
+	Object    Spatial
+	OldBounds *Rect
+}
+
+// UpdateAll moves each updated object in the tree from its old bounding box
+// to its current one, and returns the number of objects moved.  Updates of
+// objects that aren't stored with their old bounds are ignored.  Objects are
+// matched as by Delete.  Like Update, it panics without changing the tree if
+// the tree was created WithUniverse and a new bounding box lies outside the
+// universe.
+//
+// All the old entries are removed in a single traversal, which only visits
+// each node once however many of the objects lie below it, and the affected
+// nodes are then condensed together and the objects packed back in with the
+// subtrees built by RebuildRegion.  This is cheaper than a Delete and an
+// Insert for each object when many objects move at once.
+func (tree *Rtree) UpdateAll(updates []UpdateOp) int {
+	for _, u := range updates {
+		if err := tree.checkUniverse(u.Object, u.Object.Bounds()); err != nil {
+			panic(err)
+		}
+	}
+	ops := make([]int, len(updates))
+	for i := range ops {
+		ops[i] = i
+	}
+	stored := make([]Spatial, len(updates))
+	tree.removeUpdated(tree.root, updates, ops, stored)
+
+	var entries []entry
+	for i, u := range updates {
+		if stored[i] == nil {
+			continue
+		}
+		entries = append(entries, entry{bb: u.Object.Bounds(), obj: u.Object})
+		// a Comparable object may be stored as a different value
+		if tree.seqs != nil && stored[i] != u.Object {
+			tree.seqs[u.Object] = tree.seqs[stored[i]]
+			delete(tree.seqs, stored[i])
+		}
+	}
+	tree.insertPacked(entries)
+	for range entries {
+		tree.changed()
+	}
+	return len(entries)
+}
+
+// removeUpdated removes from the subtree of n the objects of those updates,
+// listed by index in ops, that haven't been found yet, recording each object
+// removed in stored, and marks the leaves it changes for compaction.
+func (tree *Rtree) removeUpdated(n *node, updates []UpdateOp, ops []int, stored []Spatial) {
+	if !n.leaf {
+		for _, e := range n.entries {
+			var sub []int
+			for _, i := range ops {
+				if stored[i] == nil && e.bb.containsRect(updates[i].OldBounds) {
+					sub = append(sub, i)
+				}
+			}
+			if len(sub) > 0 {
+				tree.removeUpdated(e.child, updates, sub, stored)
+			}
+		}
+		return
+	}
+
+	removed := false
+	for _, i := range ops {
+		for j, e := range n.entries {
+			if defaultComparator(updates[i].Object, e.obj) && *e.bb == *updates[i].OldBounds {
+				stored[i] = e.obj
+				n.entries = append(n.entries[:j], n.entries[j+1:]...)
+				tree.size--
+				removed = true
+				break
+			}
+		}
+	}
+	if removed {
+		tree.markDirty(n)
+	}
+}
+
+// DeleteWithFunc removes every object for which fn returns true, in a single
+// traversal of the tree, and returns the number of objects removed.  The
+// changed leaves are then condensed together, as by Compact, or in a tree
+// created with WithLazyCondense left for the next compaction.
+func (tree *Rtree) DeleteWithFunc(fn func(obj Spatial) bool) int {
+	if tree.seqs != nil {
+		match := fn
+		fn = func(obj Spatial) bool {
+			if match(obj) {
+				delete(tree.seqs, obj)
+				return true
+			}
+			return false
+		}
+	}
+	removed := tree.deleteWithFunc(tree.root, fn)
+	tree.size -= removed
+	if !tree.deferCondense() {
+		tree.Compact()
+	}
+	for range removed {
+		tree.changed()
+	}
+	return removed
+}
+
+func (tree *Rtree) deleteWithFunc(n *node, fn func(obj Spatial) bool) int {
+	removed := 0
+	if !n.leaf {
+		for _, e := range n.entries {
+			removed += tree.deleteWithFunc(e.child, fn)
+		}
+		return removed
+	}
+
+	kept := n.entries[:0]
+	for _, e := range n.entries {
+		if fn(e.obj) {
+			removed++
+		} else {
+			kept = append(kept, e)
+		}
+	}
+	clear(n.entries[len(kept):])
+	n.entries = kept
+	if removed > 0 {
+		tree.markDirty(n)
+	}
+	return removed
+}
+
+// RebuildRegion restructures the part of the tree holding the objects whose
+// bounding boxes intersect bb: those objects are removed and then packed
+// back in as freshly built subtrees, leaving the rest of the tree in place.
+// This repairs a region degraded by heavy churn at a fraction of the cost of
+// rebuilding the whole tree.  The objects are selected and reinserted by the
+// boxes they are stored with, so objects whose Bounds have changed since they
+// were inserted keep their old place until they are moved with UpdateAll.
+func (tree *Rtree) RebuildRegion(bb *Rect) {
+	var entries []entry
+	tree.removeRegion(tree.root, bb, &entries)
+	tree.size -= len(entries)
+	tree.insertPacked(entries)
+}
+
+// removeRegion removes from the subtree of n the leaf entries whose boxes
+// intersect bb, appending them to removed, and marks the leaves it changes
+// for compaction.
+func (tree *Rtree) removeRegion(n *node, bb *Rect, removed *[]entry) {
+	if !n.leaf {
+		for _, e := range n.entries {
+			if intersect(e.bb, bb) {
+				tree.removeRegion(e.child, bb, removed)
+			}
+		}
+		return
+	}
+
+	kept := n.entries[:0]
+	for _, e := range n.entries {
+		if intersect(e.bb, bb) {
+			*removed = append(*removed, e)
+		} else {
+			kept = append(kept, e)
+		}
+	}
+	if len(kept) < len(n.entries) {
+		clear(n.entries[len(kept):])
+		n.entries = kept
+		tree.markDirty(n)
+	}
+}
+
+// findLeaf finds the leaf node containing an object equal to obj according
+// to cmp.
+func (tree *Rtree) findLeaf(n *node, obj Spatial, cmp Comparator) *node {
+	if n.leaf {
+		for _, e := range n.entries {
+			if cmp(obj, e.obj) {
+				return n
+			}
+		}
+		return nil
+	}
+	// if not leaf, search all candidate subtrees
+	bb := obj.Bounds()
+	for _, e := range n.entries {
+		if e.bb.containsRect(bb) {
+			if leaf := tree.findLeaf(e.child, obj, cmp); leaf != nil {
+				return leaf
+			}
+		}
+	}
+	return nil
+}
+
+// condenseTree deletes underflowing nodes and propagates the changes upwards.
+func (tree *Rtree) condenseTree(n *node) {
+	var deleted []*node
+
+	for n != tree.root {
+		parent := n.parent
+		if len(n.entries) < tree.MinChildren {
+			// remove n from parent entries
+			i := 0
+			for i < len(parent.entries) && parent.entries[i].child != n {
+				i++
+			}
+			if i == len(parent.entries) {
+				// panic?????? whyyyy
+				panic(fmt.Errorf("Failed to remove entry from parent"))
+			}
+			parent.entries = slices.Delete(parent.entries, i, i+1)
+
+			// only add n to deleted if it still has children
+			if len(n.entries) > 0 {
+				deleted = append(deleted, n)
+			} else {
+				tree.freeNode(n)
+			}
+		} else {
+			// just a child entry deletion, no underflow
+			n.getEntry().bb = n.computeBoundingBox()
+		}
+		n = parent
+	}
+
+	// reinsert the entries of the deleted nodes at the levels they were at,
+	// so that the leaves below them stay at the same depth
+	tree.collapseRoot()
+	for _, n := range deleted {
+		for _, e := range n.entries {
+			tree.reinsertEntry(e, n.level)
+		}
+		tree.freeNode(n)
+	}
+}
+
+// reinsertEntry inserts an entry orphaned by condenseTree into a node at the
+// specified level.  If the tree has become too short for that, the entries of
+// its child are reinserted instead.  Empty children, left by lazy deletions,
+// are dropped.
+func (tree *Rtree) reinsertEntry(e entry, level int) {
+	if e.child == nil {
+		if tree.moved != nil {
+			*tree.moved = append(*tree.moved, e.obj)
+		}
+		tree.insert(e, level)
+		return
+	}
+	if len(e.child.entries) == 0 {
+		tree.freeNode(e.child)
+		return
+	}
+	if level <= tree.height {
+		tree.insert(e, level)
+		return
+	}
+	for _, ce := range e.child.entries {
+		tree.reinsertEntry(ce, level-1)
+	}
+	tree.freeNode(e.child)
+}
+
+// Searching
+
+// SearchIntersect returns all objects that intersect the specified rectangle
+// and are accepted by filters, which are combined as by AndFilters.  A filter
+// that aborts stops the search without visiting the remaining subtrees.
+//
+// Implemented per Section 3.1 of "R-trees: A Dynamic Index Structure for
+// Spatial Searching" by A. Guttman, Proceedings of ACM SIGMOD, p. 47-57, 1984.
+func (tree *Rtree) SearchIntersect(bb *Rect, filters ...Filter) []Spatial {
+	if len(filters) == 0 {
+		return tree.searchIntersect(tree.root, bb, []Spatial{})
+	}
+	results, _ := tree.searchIntersectFiltered(tree.root, bb, []Spatial{}, AndFilters(filters...))
+	return results
+}
+
+// reaches reports whether the entry of n with bounding box bb is, or may
+// lead to, an object intersecting query.  Objects must intersect it, but a
+// subtree must be searched as soon as its box touches query: an object lying
+// flat on the boundary of the box intersects a query that only touches it.
+func (n *node) reaches(bb, query *Rect) bool {
+	if n.leaf {
+		return intersect(bb, query)
+	}
+	return touch(bb, query)
+}
+
+func (tree *Rtree) searchIntersect(n *node, bb *Rect, results []Spatial) []Spatial {
+	for _, e := range n.entries {
+		if n.reaches(e.bb, bb) {
+			if n.leaf {
+				results = append(results, e.obj)
+			} else {
+				results = tree.searchIntersect(e.child, bb, results)
+			}
+		}
+	}
+	return results
+}
+
+// SearchIntersectWithLimit returns at most k of the objects that intersect
+// bb, stopping the search as soon as it has found k of them.  Which objects
+// are returned when more than k match is unspecified: they are the first
+// ones found, which depends on the shape of the tree.  The result is empty
+// if k is not positive.
+func (tree *Rtree) SearchIntersectWithLimit(k int, bb *Rect) []Spatial {
+	if k <= 0 {
+		return []Spatial{}
+	}
+	return tree.searchIntersectLimit(tree.root, bb, k, []Spatial{})
+}
+
+func (tree *Rtree) searchIntersectLimit(n *node, bb *Rect, k int, results []Spatial) []Spatial {
+	for _, e := range n.entries {
+		if len(results) == k {
+			break
+		}
+		if n.reaches(e.bb, bb) {
+			if n.leaf {
+				results = append(results, e.obj)
+			} else {
+				results = tree.searchIntersectLimit(e.child, bb, k, results)
+			}
+		}
+	}
+	return results
+}
+
+// SearchIntersectParallel is like SearchIntersect without filters, but
+// searches the subtrees of the root that reach bb on up to workers
+// goroutines at once.  It returns the same objects in the same order.  No
+// more goroutines are started than there are such subtrees, and the search
+// runs on the calling goroutine alone if workers is at most 1 or the root is
+// a leaf.  The tree must not be modified during the search; see
+// ConcurrentRtree for trees shared with writers.
+func (tree *Rtree) SearchIntersectParallel(bb *Rect, workers int) []Spatial {
+	n := tree.root
+	if workers <= 1 || n.leaf {
+		return tree.SearchIntersect(bb)
+	}
+	var subtrees []*node
+	for _, e := range n.entries {
+		if n.reaches(e.bb, bb) {
+			subtrees = append(subtrees, e.child)
+		}
+	}
+
+	found := make([][]Spatial, len(subtrees))
+	var next atomic.Int64
+	var wg sync.WaitGroup
+	for w := min(workers, len(subtrees)); w > 0; w-- {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				i := int(next.Add(1)) - 1
+				if i >= len(subtrees) {
+					return
+				}
+				found[i] = tree.searchIntersect(subtrees[i], bb, []Spatial{})
+			}
+		}()
+	}
+	wg.Wait()
+
+	total := 0
+	for _, objs := range found {
+		total += len(objs)
+	}
+	results := make([]Spatial, 0, total)
+	for _, objs := range found {
+		results = append(results, objs...)
+	}
+	return results
+}
+
+// CountIntersect returns the number of objects that intersect bb, which are
+// the objects SearchIntersect would return, without collecting them.
+// Subtrees whose bounding boxes lie within bb are counted without testing
+// their entries.
+func (tree *Rtree) CountIntersect(bb *Rect) int {
+	return tree.countIntersect(tree.root, bb)
+}
+
+func (tree *Rtree) countIntersect(n *node, bb *Rect) int {
+	count := 0
+	for _, e := range n.entries {
+		switch {
+		case !n.reaches(e.bb, bb):
+		case n.leaf:
+			count++
+		case bb.containsRect(e.bb):
+			count += e.child.count()
+		default:
+			count += tree.countIntersect(e.child, bb)
+		}
+	}
+	return count
+}
+
+// count returns the number of objects in the subtree of n.
+func (n *node) count() int {
+	if n.leaf {
+		return len(n.entries)
+	}
+	count := 0
+	for _, e := range n.entries {
+		count += e.child.count()
+	}
+	return count
+}
+
+// EstimateCount estimates the number of objects that intersect bb without
+// visiting more than sampleNodes nodes.
+//
+// The nodes overlapping bb are expanded in breadth-first order until the
+// budget runs out.  Objects in the leaves expanded so far are counted
+// exactly; every node still waiting to be expanded contributes the number of
+// objects its subtree would hold at the tree's average fan-out, scaled by the
+// fraction of its bounding box that lies within bb.  This assumes objects are
+// spread evenly through each remaining node, so the estimate degrades where
+// they cluster, but its error only comes from the nodes at the edge of bb:
+// nodes wholly within it are extrapolated from their exact entry counts.
+// A budget of a few hundred nodes is typically within ten percent or so on
+// evenly spread data; a budget at least as large as the number of nodes
+// overlapping bb gives the exact count, at the cost of a full SearchIntersect.
+func (tree *Rtree) EstimateCount(bb *Rect, sampleNodes int) int {
+	if tree.size == 0 {
+		return 0
+	}
+	// The fan-out below the root, which is usually less full than the rest.
+	fanout := 1.0
+	if tree.height > 1 {
+		fanout = math.Pow(float64(tree.size)/float64(len(tree.root.entries)), 1/float64(tree.height-1))
+	}
+
+	type item struct {
+		n  *node
+		bb *Rect
+	}
+	queue := []item{{tree.root, tree.root.computeBoundingBox()}}
+	count := 0
+	for ; len(queue) > 0 && sampleNodes > 0; sampleNodes-- {
+		n := queue[0].n
+		queue = queue[1:]
+		for _, e := range n.entries {
+			if !n.reaches(e.bb, bb) {
+				continue
+			}
+			if n.leaf {
+				count++
+			} else {
+				queue = append(queue, item{e.child, e.bb})
+			}
+		}
+	}
+
+	estimate := float64(count)
+	for _, it := range queue {
+		subtree := float64(len(it.n.entries)) * math.Pow(fanout, float64(it.n.level-1))
+		estimate += subtree * overlapFraction(it.bb, bb)
+	}
+	return int(math.Round(estimate))
+}
+
+// SearchIntersectFunc calls fn for each object that intersects the specified
+// rectangle, in the same order as SearchIntersect returns them, until fn
+// returns false.  No results slice is allocated.
+func (tree *Rtree) SearchIntersectFunc(bb *Rect, fn func(obj Spatial) bool) {
+	tree.searchIntersectFunc(tree.root, bb, fn)
+}
+
+func (tree *Rtree) searchIntersectFunc(n *node, bb *Rect, fn func(obj Spatial) bool) bool {
+	for _, e := range n.entries {
+		if !n.reaches(e.bb, bb) {
+			continue
+		}
+		if n.leaf {
+			if !fn(e.obj) {
+				return false
+			}
+		} else if !tree.searchIntersectFunc(e.child, bb, fn) {
+			return false
+		}
+	}
+	return true
+}
+
+// ctxCheckInterval is the number of nodes SearchIntersectContext visits
+// between checks of its context.
+const ctxCheckInterval = 64
+
+// SearchIntersectContext is like SearchIntersect, but gives up once ctx is
+// done, checking it every few nodes visited.  It then returns ctx.Err() along
+// with the objects found so far, which intersect bb but are not all of them.
+func (tree *Rtree) SearchIntersectContext(ctx context.Context, bb *Rect) ([]Spatial, error) {
+	visited := 0
+	return tree.searchIntersectContext(ctx, tree.root, bb, []Spatial{}, &visited)
+}
+
+func (tree *Rtree) searchIntersectContext(ctx context.Context, n *node, bb *Rect, results []Spatial, visited *int) ([]Spatial, error) {
+	if *visited++; *visited%ctxCheckInterval == 0 {
+		if err := ctx.Err(); err != nil {
+			return results, err
+		}
+	}
+	for _, e := range n.entries {
+		if !n.reaches(e.bb, bb) {
+			continue
+		}
+		if n.leaf {
+			results = append(results, e.obj)
+			continue
+		}
+		var err error
+		if results, err = tree.searchIntersectContext(ctx, e.child, bb, results, visited); err != nil {
+			return results, err
+		}
+	}
+	return results, nil
+}
+
+// SearchContained returns all objects whose bounding boxes lie within the
+// specified rectangle, including those touching its boundary from inside.
+func (tree *Rtree) SearchContained(bb *Rect) []Spatial {
+	return tree.searchContained(tree.root, bb, []Spatial{})
+}
+
+func (tree *Rtree) searchContained(n *node, bb *Rect, results []Spatial) []Spatial {
+	for _, e := range n.entries {
+		if n.leaf {
+			if bb.containsRect(e.bb) {
+				results = append(results, e.obj)
+			}
+		} else if touch(e.bb, bb) {
+			// a node that only touches bb may still hold objects lying
+			// flat on its boundary
+			results = tree.searchContained(e.child, bb, results)
+		}
+	}
+	return results
+}
+
+// searchIntersectFiltered is searchIntersect with a filter, and also reports
+// whether the filter aborted the search.
+func (tree *Rtree) searchIntersectFiltered(n *node, bb *Rect, results []Spatial, filter Filter) ([]Spatial, bool) {
+	for _, e := range n.entries {
+		if !n.reaches(e.bb, bb) {
+			continue
+		}
+		if !n.leaf {
+			var abort bool
+			if results, abort = tree.searchIntersectFiltered(e.child, bb, results, filter); abort {
+				return results, true
+			}
+			continue
+		}
+		refuse, abort := filter(results, e.obj)
+		if !refuse {
+			results = append(results, e.obj)
+		}
+		if abort {
+			return results, true
+		}
+	}
+	return results, false
+}
+
+// SearchIntersectGrouped returns all objects that intersect the specified
+// rectangle, grouped by the leaf node in which they are stored.  Leaves with
+// no matching objects are omitted.
+func (tree *Rtree) SearchIntersectGrouped(bb *Rect) [][]Spatial {
+	return tree.searchIntersectGrouped(tree.root, bb, [][]Spatial{})
+}
+
+func (tree *Rtree) searchIntersectGrouped(n *node, bb *Rect, groups [][]Spatial) [][]Spatial {
+	if n.leaf {
+		if group := tree.searchIntersect(n, bb, nil); len(group) > 0 {
+			groups = append(groups, group)
+		}
+		return groups
+	}
+	for _, e := range n.entries {
+		if n.reaches(e.bb, bb) {
+			groups = tree.searchIntersectGrouped(e.child, bb, groups)
+		}
+	}
+	return groups
+}
+
+// SearchIntersectCentered returns all objects that intersect the rectangle
+// centered at center that extends halfExtents[i] from it along each axis i.
+// It is equivalent to SearchIntersect, but saves the caller from allocating
+// a new query rectangle on every call.
+func (tree *Rtree) SearchIntersectCentered(center Point, halfExtents Point) []Spatial {
+	var bb Rect
+	for i := range center {
+		bb.p[i] = center[i] - halfExtents[i]
+		bb.q[i] = center[i] + halfExtents[i]
+	}
+	return tree.searchIntersect(tree.root, &bb, []Spatial{})
+}
+
+// SearchIntersectTransformed returns all objects that intersect bb, where bb
+// is expressed in a different coordinate frame from the tree and inverse maps
+// points from the tree's frame into bb's frame.  Objects and subtrees are
+// tested by mapping the corners of their bounding boxes through inverse and
+// checking the bounding box of the images against bb.
+//
+// For transforms that are not axis-aligned (rotations, shears) the mapped
+// box is larger than the mapped object, so the search is conservative: it
+// returns every object that intersects bb, possibly along with some that
+// don't, and callers needing exact results should filter them.  Subtrees are
+// pruned correctly for any affine transform.
+func (tree *Rtree) SearchIntersectTransformed(bb *Rect, inverse func(Point) Point) []Spatial {
+	return tree.searchIntersectTransformed(tree.root, bb, inverse, []Spatial{})
+}
+
+func (tree *Rtree) searchIntersectTransformed(n *node, bb *Rect, inverse func(Point) Point, results []Spatial) []Spatial {
+	for _, e := range n.entries {
+		if n.reaches(transformedBoundingBox(e.bb, inverse), bb) {
+			if n.leaf {
+				results = append(results, e.obj)
+			} else {
+				results = tree.searchIntersectTransformed(e.child, bb, inverse, results)
+			}
+		}
+	}
+	return results
+}
+
+// Prefetch reads the nodes of every subtree whose bounding box intersects
+// bb, down to the bounding boxes of the leaf entries, without collecting any
+// results, so that a following search of bb finds them in the CPU cache.  It
+// returns the number of entries read, which is also the work a search of bb
+// does before comparing any objects.  Whether this pays off depends on the
+// hardware and on how much else runs in between; it never changes the tree.
+func (tree *Rtree) Prefetch(bb *Rect) int {
+	return tree.prefetch(tree.root, bb)
+}
+
+func (tree *Rtree) prefetch(n *node, bb *Rect) int {
+	touched := len(n.entries)
+	for _, e := range n.entries {
+		if n.reaches(e.bb, bb) && !n.leaf {
+			touched += tree.prefetch(e.child, bb)
+		}
+	}
+	return touched
+}
+
+// FloodSelect returns the objects reachable from seed through chains of
+// objects with intersecting bounding boxes, in breadth-first order: first
+// those intersecting seed, then those intersecting any of them, and so on.
+// Unlike SearchIntersect, which only finds the objects overlapping a given
+// rectangle, this finds the whole connected region around seed.  seed itself
+// is included only if it is stored in the tree.  If includeBoundary is true,
+// boxes that merely touch count as connected.  Objects are told apart with
+// ==, so they must be comparable.
+//
+// Each object found costs one search of its bounding box, so the running
+// time grows with the size of the region times the cost of a search.
+func (tree *Rtree) FloodSelect(seed Spatial, includeBoundary bool) []Spatial {
+	overlaps := intersect
+	if includeBoundary {
+		overlaps = touch
+	}
+	results := []Spatial{}
+	visited := map[Spatial]bool{}
+	visit := func(obj Spatial) {
+		if !visited[obj] {
+			visited[obj] = true
+			results = append(results, obj)
+		}
+	}
+	tree.root.searchWith(seed.Bounds(), overlaps, visit)
+	for i := 0; i < len(results); i++ {
+		if results[i] != seed {
+			tree.root.searchWith(results[i].Bounds(), overlaps, visit)
+		}
+	}
+	return results
+}
+
+// searchWith calls fn for each object in the subtree of n whose bounding box
+// overlaps bb according to overlaps, which must imply touch, so that the
+// subtrees holding such objects are those whose boxes touch bb.
+func (n *node) searchWith(bb *Rect, overlaps func(r1, r2 *Rect) bool, fn func(obj Spatial)) {
+	for _, e := range n.entries {
+		if n.leaf {
+			if overlaps(e.bb, bb) {
+				fn(e.obj)
+			}
+		} else if touch(e.bb, bb) {
+			e.child.searchWith(bb, overlaps, fn)
+		}
+	}
+}
+
+// SearchIntersectByOverlap returns all objects that intersect the specified
+// rectangle, ordered by decreasing OverlapVolume with it.  Objects with equal
+// overlap are returned in tree order.
+func (tree *Rtree) SearchIntersectByOverlap(bb *Rect) []Spatial {
+	results := tree.SearchIntersect(bb)
+	overlaps := make([]float64, len(results))
+	for i, obj := range results {
+		overlaps[i] = OverlapVolume(bb, obj.Bounds())
+	}
+	sort.Stable(byOverlap{results, overlaps})
+	return results
+}
+
+// byOverlap sorts objects by decreasing overlap.
+type byOverlap struct {
+	objs     []Spatial
+	overlaps []float64
+}
+
+func (s byOverlap) Len() int { return len(s.objs) }
+
+func (s byOverlap) Swap(i, j int) {
+	s.objs[i], s.objs[j] = s.objs[j], s.objs[i]
+	s.overlaps[i], s.overlaps[j] = s.overlaps[j], s.overlaps[i]
+}
+
+func (s byOverlap) Less(i, j int) bool { return s.overlaps[i] > s.overlaps[j] }
+
+// BoxedResult is an object found by SearchIntersectBoxes, with a copy of the
+// bounding box the tree stores for it.
+type BoxedResult struct {
+	Object Spatial
+	Box    *Rect
+}
+
+// SearchIntersectBoxes is like SearchIntersect, but also returns the
+// bounding box of each object as it was stored when the object was inserted
+// or last updated, so that callers needn't call Bounds again.  The boxes are
+// copies and may be kept or modified.
+func (tree *Rtree) SearchIntersectBoxes(bb *Rect) []BoxedResult {
+	results := tree.searchIntersectBoxes(tree.root, bb, []BoxedResult{})
+	boxes := make([]Rect, len(results))
+	for i := range results {
+		boxes[i] = *results[i].Box
+		results[i].Box = &boxes[i]
+	}
+	return results
+}
+
+func (tree *Rtree) searchIntersectBoxes(n *node, bb *Rect, results []BoxedResult) []BoxedResult {
+	for _, e := range n.entries {
+		if n.reaches(e.bb, bb) {
+			if n.leaf {
+				results = append(results, BoxedResult{e.obj, e.bb})
+			} else {
+				results = tree.searchIntersectBoxes(e.child, bb, results)
+			}
+		}
+	}
+	return results
+}
+
+// SearchIntersectBounds is like SearchIntersect, but also returns the
+// bounding box of all the objects found, computed from their stored boxes
+// while searching, or nil if none are found.
+func (tree *Rtree) SearchIntersectBounds(bb *Rect) ([]Spatial, *Rect) {
+	var mbr Rect
+	results := tree.searchIntersectBounds(tree.root, bb, []Spatial{}, &mbr)
+	if len(results) == 0 {
+		return results, nil
+	}
+	return results, &mbr
+}
+
+func (tree *Rtree) searchIntersectBounds(n *node, bb *Rect, results []Spatial, mbr *Rect) []Spatial {
+	for _, e := range n.entries {
+		if !n.reaches(e.bb, bb) {
+			continue
+		}
+		if !n.leaf {
+			results = tree.searchIntersectBounds(e.child, bb, results, mbr)
+			continue
+		}
+		if len(results) == 0 {
+			*mbr = *e.bb
+		} else {
+			mbr.enlarge(e.bb)
+		}
+		results = append(results, e.obj)
+	}
+	return results
+}
+
+// SearchIntersectSorted returns all objects that intersect bb, as
+// SearchIntersect does, ordered by increasing distance from focus to their
+// bounding boxes, and in tree order among equal distances.  The focus needn't
+// lie in bb.
+func (tree *Rtree) SearchIntersectSorted(bb *Rect, focus Point) []Spatial {
+	found := tree.searchIntersectBoxes(tree.root, bb, nil)
+	var sorted entrySlice
+	sorted.entries = make([]entry, len(found))
+	sorted.dists = make([]float64, len(found))
+	for i, r := range found {
+		sorted.entries[i] = entry{bb: r.Box, obj: r.Object}
+		sorted.dists[i] = focus.minDist(r.Box)
+	}
+	sort.Stable(sorted)
+	objs := make([]Spatial, len(sorted.entries))
+	for i, e := range sorted.entries {
+		objs[i] = e.obj
+	}
+	return objs
+}
+
+// RankedResult is an object found by SearchIntersectRanked, with the volume
+// of the intersection of its bounding box with the query rectangle.
+type RankedResult struct {
+	Object  Spatial
+	Overlap float64
+}
+
+// SearchIntersectRanked returns all objects that intersect the specified
+// rectangle with their OverlapVolume with it, ordered by decreasing overlap,
+// and in tree order among equal overlaps.  Objects that only intersect it in
+// a region of no volume, such as flat objects lying within it, are returned
+// last with an overlap of zero.
+func (tree *Rtree) SearchIntersectRanked(bb *Rect) []RankedResult {
+	found := tree.searchIntersectBoxes(tree.root, bb, nil)
+	results := make([]RankedResult, len(found))
+	for i, r := range found {
+		results[i] = RankedResult{r.Object, OverlapVolume(bb, r.Box)}
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Overlap > results[j].Overlap
+	})
+	return results
+}
 
-	return true
+// SmallestCovering returns the object with the smallest bounding box that
+// contains bb, or nil if no stored object contains it.  Only subtrees whose
+// bounding boxes contain bb are searched.
+func (tree *Rtree) SmallestCovering(bb *Rect) Spatial {
+	obj, _ := tree.smallestCovering(tree.root, bb, nil, math.MaxFloat64)
+	return obj
+}
+
+// SmallestContaining returns the object with the smallest bounding box that
+// contains query.  It is the same as SmallestCovering, under the name used by
+// some GIS libraries.
+func (tree *Rtree) SmallestContaining(query *Rect) Spatial {
+	return tree.SmallestCovering(query)
+}
+
+func (tree *Rtree) smallestCovering(n *node, bb *Rect, best Spatial, size float64) (Spatial, float64) {
+	for _, e := range n.entries {
+		if !e.bb.containsRect(bb) {
+			continue
+		}
+		if n.leaf {
+			if s := e.bb.size(); best == nil || s < size {
+				best, size = e.obj, s
+			}
+		} else {
+			best, size = tree.smallestCovering(e.child, bb, best, size)
+		}
+	}
+	return best, size
+}
+
+// NearestNeighbor returns the closest object to the specified point.
+// Implemented per "Nearest Neighbor Queries" by Roussopoulos et al
+func (tree *Rtree) NearestNeighbor(p Point) Spatial {
+	obj, _ := tree.nearestNeighbor(p, tree.root, math.MaxFloat64, nil)
+	return obj
+}
+
+// NearestChain returns up to limit objects in greedy nearest-first order: the
+// first is the object nearest to start, and each subsequent one is the
+// unvisited object nearest to the center of the previous one's bounding box.
+// The objects are told apart by the entries holding them, so they needn't be
+// comparable with ==, and an object stored twice may be visited twice.
+func (tree *Rtree) NearestChain(start Point, limit int) []Spatial {
+	chain := []Spatial{}
+	visited := map[*entry]bool{}
+	p := start
+	for len(chain) < limit {
+		e, _ := tree.nearestNeighborSkip(p, tree.root, math.MaxFloat64, nil, visited)
+		if e == nil {
+			break
+		}
+		chain = append(chain, e.obj)
+		visited[e] = true
+		p = e.obj.Bounds().center()
+	}
+	return chain
 }
 
-// findLeaf finds the leaf node containing obj.
-func (tree *Rtree) findLeaf(n *node, obj Spatial) *node {
+// nearestNeighborSkip finds the leaf entry closest to p that is not in skip.
+// Since the nearest entry in a subtree may be skipped, branches are only
+// pruned by their distance from p and not by minMaxDist.
+func (tree *Rtree) nearestNeighborSkip(p Point, n *node, d float64, nearest *entry, skip map[*entry]bool) (*entry, float64) {
 	if n.leaf {
-		return n
+		for i := range n.entries {
+			e := &n.entries[i]
+			dist := math.Sqrt(p.minDist(e.bb))
+			if dist < d && !skip[e] {
+				d = dist
+				nearest = e
+			}
+		}
+		return nearest, d
 	}
-	// if not leaf, search all candidate subtrees
+
+	branches, dists := sortEntries(p, n.entries)
+	for i, e := range branches {
+		if math.Sqrt(dists[i]) >= d {
+			break
+		}
+		nearest, d = tree.nearestNeighborSkip(p, e.child, d, nearest, skip)
+	}
+	return nearest, d
+}
+
+// LargestInRect returns up to n of the objects that intersect bb with the
+// largest bounding boxes, largest first.  Ties are broken arbitrarily.  The
+// candidates are kept in a bounded heap during a single search, so only n of
+// the matches are held at any time.
+func (tree *Rtree) LargestInRect(bb *Rect, n int) []Spatial {
+	if n <= 0 {
+		return []Spatial{}
+	}
+	h := &sizeHeap{}
+	tree.largestInRect(tree.root, bb, n, h)
+	objs := make([]Spatial, h.Len())
+	for i := len(objs) - 1; i >= 0; i-- {
+		objs[i] = heap.Pop(h).(sizedObject).obj
+	}
+	return objs
+}
+
+func (tree *Rtree) largestInRect(n *node, bb *Rect, k int, h *sizeHeap) {
 	for _, e := range n.entries {
-		if e.bb.containsRect(obj.Bounds()) {
-			leaf := tree.findLeaf(e.child, obj)
-			if leaf == nil {
-				continue
+		if !n.reaches(e.bb, bb) {
+			continue
+		}
+		if !n.leaf {
+			tree.largestInRect(e.child, bb, k, h)
+			continue
+		}
+		size := e.bb.size()
+		if h.Len() < k {
+			heap.Push(h, sizedObject{e.obj, size})
+		} else if size > (*h)[0].size {
+			(*h)[0] = sizedObject{e.obj, size}
+			heap.Fix(h, 0)
+		}
+	}
+}
+
+type sizedObject struct {
+	obj  Spatial
+	size float64
+}
+
+// sizeHeap is a min-heap of objects by size.
+type sizeHeap []sizedObject
+
+func (h sizeHeap) Len() int           { return len(h) }
+func (h sizeHeap) Less(i, j int) bool { return h[i].size < h[j].size }
+func (h sizeHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *sizeHeap) Push(x any) { *h = append(*h, x.(sizedObject)) }
+
+func (h *sizeHeap) Pop() any {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// NearestInCone returns the object closest to apex among those whose
+// bounding boxes intersect the infinite cone with that apex, axis along dir
+// and the specified half-angle in radians, or nil if there is none.  dir need
+// not be normalized, but it must not be zero.  Distances are measured from
+// apex to the nearest point of each bounding box, as by NearestNeighbor.
+//
+// Subtrees are pruned by testing the sphere circumscribing their boxes
+// against the cone, which is cheap and never misses a box that intersects
+// it, and the boxes of the objects are then tested exactly.
+func (tree *Rtree) NearestInCone(apex Point, dir Point, halfAngle float64) Spatial {
+	obj, _ := tree.nearestInCone(tree.root, apex, dir, halfAngle, math.Inf(1), nil)
+	return obj
+}
+
+func (tree *Rtree) nearestInCone(n *node, apex, dir Point, halfAngle, d float64, nearest Spatial) (Spatial, float64) {
+	entries, dists := sortEntries(apex, n.entries)
+	for i, e := range entries {
+		if dists[i] >= d {
+			break
+		}
+		if n.leaf {
+			if e.bb.inCone(apex, dir, halfAngle) {
+				d, nearest = dists[i], e.obj
 			}
-			// check if the leaf actually contains the object
-			for _, leafEntry := range leaf.entries {
-				if leafEntry.obj == obj {
-					return leaf
-				}
+		} else if e.bb.sphereInCone(apex, dir, halfAngle) {
+			nearest, d = tree.nearestInCone(e.child, apex, dir, halfAngle, d, nearest)
+		}
+	}
+	return nearest, d
+}
+
+// SearchRay returns all objects whose bounding boxes are hit by the ray from
+// origin in direction dir, in order of the distance along the ray at which
+// it enters them, so the first is the nearest hit.  Objects containing the
+// origin are entered at distance zero, and objects entered at the same
+// distance are returned in tree order.  Only subtrees the ray hits are
+// visited.
+func (tree *Rtree) SearchRay(origin, dir Point) []Spatial {
+	var hits entrySlice
+	tree.searchRay(tree.root, origin, dir, &hits)
+	sort.Stable(hits)
+	objs := make([]Spatial, len(hits.entries))
+	for i, e := range hits.entries {
+		objs[i] = e.obj
+	}
+	return objs
+}
+
+func (tree *Rtree) searchRay(n *node, origin, dir Point, hits *entrySlice) {
+	for _, e := range n.entries {
+		t, ok := e.bb.rayEntry(origin, dir)
+		if !ok {
+			continue
+		}
+		if n.leaf {
+			hits.entries = append(hits.entries, e)
+			hits.dists = append(hits.dists, t)
+		} else {
+			tree.searchRay(e.child, origin, dir, hits)
+		}
+	}
+}
+
+// FarthestInRect returns, among the objects that intersect bb, the one
+// farthest from p, or nil if no object intersects bb.  The distance to an
+// object is measured to the nearest point of its bounding box, as for
+// NearestNeighbor; subtrees that cannot hold an object farther than the
+// current candidate are pruned.
+func (tree *Rtree) FarthestInRect(bb *Rect, p Point) Spatial {
+	obj, _ := tree.farthestInRect(tree.root, bb, p, nil, -1)
+	return obj
+}
+
+func (tree *Rtree) farthestInRect(n *node, bb *Rect, p Point, farthest Spatial, d float64) (Spatial, float64) {
+	for _, e := range n.entries {
+		if !n.reaches(e.bb, bb) {
+			continue
+		}
+		if n.leaf {
+			if dist := p.minDist(e.bb); dist > d {
+				farthest, d = e.obj, dist
 			}
+		} else if p.maxDist(e.bb) > d {
+			farthest, d = tree.farthestInRect(e.child, bb, p, farthest, d)
 		}
 	}
-	return nil
+	return farthest, d
 }
 
-// condenseTree deletes underflowing nodes and propagates the changes upwards.
-func (tree *Rtree) condenseTree(n *node) {
-	deleted := []*node{}
+// FarthestNeighbors returns the k objects farthest from p, farthest first,
+// or all of them if the tree holds fewer than k; the result is empty for a k
+// that is not positive.  As for NearestNeighbors, the distance to an object is
+// that to the nearest point of its bounding box, and objects at the same
+// distance come in the order they are found.  Branches are visited in order
+// of the distance to the farthest point of their boxes, which bounds the
+// distance of every object in them, and pruned once they can't beat the k-th
+// farthest object found so far.
+func (tree *Rtree) FarthestNeighbors(k int, p Point) []Spatial {
+	if k <= 0 {
+		return []Spatial{}
+	}
+	objs := make([]Spatial, 0, k)
+	dists := make([]float64, 0, k)
+	objs, _ = tree.farthestNeighbors(k, p, tree.root, objs, dists)
+	return objs
+}
 
-	for n != tree.root {
-		if len(n.entries) < tree.MinChildren {
-			// remove n from parent entries
-			entries := []entry{}
-			for _, e := range n.parent.entries {
-				if e.child != n {
-					entries = append(entries, e)
-				}
+func (tree *Rtree) farthestNeighbors(k int, p Point, n *node, farthest []Spatial, dists []float64) ([]Spatial, []float64) {
+	if n.leaf {
+		for _, e := range n.entries {
+			d := p.minDist(e.bb)
+			i := 0
+			for i < len(dists) && !(d > dists[i]) {
+				i++
 			}
-			if len(n.parent.entries) == len(entries) {
-				// panic?????? whyyyy
-				panic(fmt.Errorf("Failed to remove entry from parent"))
+			if i == k || isNaN(d) {
+				continue
 			}
-			n.parent.entries = entries
-
-			// only add n to deleted if it still has children
-			if len(n.entries) > 0 {
-				deleted = append(deleted, n)
+			if len(dists) < k {
+				dists, farthest = append(dists, 0), append(farthest, nil)
 			}
-		} else {
-			// just a child entry deletion, no underflow
-			n.getEntry().bb = n.computeBoundingBox()
+			copy(dists[i+1:], dists[i:])
+			copy(farthest[i+1:], farthest[i:])
+			dists[i], farthest[i] = d, e.obj
 		}
-		n = n.parent
+		return farthest, dists
 	}
 
-	for _, n := range deleted {
-		// reinsert entry so that it will remain at the same level as before
-		e := entry{n.computeBoundingBox(), n, nil}
-		tree.insert(e, n.level+1)
+	branches := make([]queuedEntry, len(n.entries))
+	for i, e := range n.entries {
+		branches[i] = queuedEntry{e, p.maxDist(e.bb)}
+	}
+	sort.SliceStable(branches, func(i, j int) bool { return branches[i].dist > branches[j].dist })
+	for _, b := range branches {
+		if len(dists) == k && !(b.dist > dists[k-1]) {
+			break
+		}
+		farthest, dists = tree.farthestNeighbors(k, p, b.e.child, farthest, dists)
 	}
+	return farthest, dists
 }
 
-// Searching
-
-// SearchIntersect returns all objects that intersect the specified rectangle.
+// PairsWithin calls visit once for every unordered pair of objects in the
+// tree whose bounding boxes are no farther than d apart, passing the
+// distance between the boxes.
 //
-// Implemented per Section 3.1 of "R-trees: A Dynamic Index Structure for
-// Spatial Searching" by A. Guttman, Proceedings of ACM SIGMOD, p. 47-57, 1984.
-func (tree *Rtree) SearchIntersect(bb *Rect) []Spatial {
-	return tree.searchIntersect(tree.root, bb, []Spatial{})
+// It is implemented as a join of the tree with itself that only descends
+// into pairs of subtrees whose bounding boxes are within d of each other.
+func (tree *Rtree) PairsWithin(d float64, visit func(a, b Spatial, dist float64)) {
+	tree.pairsWithin(tree.root, tree.root, d*d, visit)
 }
 
-func (tree *Rtree) searchIntersect(n *node, bb *Rect, results []Spatial) []Spatial {
-	for _, e := range n.entries {
-		if intersect(e.bb, bb) {
-			if n.leaf {
-				results = append(results, e.obj)
+// pairsWithin visits the pairs within distance sqrt(d2) formed by an entry of
+// n1 and an entry of n2, which are at the same level.  If n1 and n2 are the
+// same node each pair is visited once.
+func (tree *Rtree) pairsWithin(n1, n2 *node, d2 float64, visit func(a, b Spatial, dist float64)) {
+	for i, e1 := range n1.entries {
+		j := 0
+		if n1 == n2 {
+			j = i
+			if n1.leaf {
+				j++
+			}
+		}
+		for _, e2 := range n2.entries[j:] {
+			dist := rectDist(e1.bb, e2.bb)
+			if dist > d2 {
+				continue
+			}
+			if n1.leaf {
+				visit(e1.obj, e2.obj, math.Sqrt(dist))
 			} else {
-				results = tree.searchIntersect(e.child, bb, results)
+				tree.pairsWithin(e1.child, e2.child, d2, visit)
 			}
 		}
 	}
-	return results
 }
 
-// NearestNeighbor returns the closest object to the specified point.
-// Implemented per "Nearest Neighbor Queries" by Roussopoulos et al
-func (tree *Rtree) NearestNeighbor(p Point) Spatial {
-	obj, _ := tree.nearestNeighbor(p, tree.root, math.MaxFloat64, nil)
-	return obj
+// SpatialJoin returns every pair of an object of a and an object of b whose
+// bounding boxes intersect, with the object of a first.
+//
+// The trees are traversed together, descending only into pairs of subtrees
+// whose boxes touch, so each pair of nodes is compared once instead of
+// searching b for every object of a.  If one tree is taller, it is descended
+// alone until the levels line up.
+func SpatialJoin(a, b *Rtree) [][2]Spatial {
+	if a.size == 0 || b.size == 0 {
+		return nil
+	}
+	return spatialJoin(a.root, b.root, nil)
+}
+
+func spatialJoin(n1, n2 *node, pairs [][2]Spatial) [][2]Spatial {
+	switch {
+	case n1.level > n2.level:
+		bb := n2.computeBoundingBox()
+		for _, e1 := range n1.entries {
+			if touch(e1.bb, bb) {
+				pairs = spatialJoin(e1.child, n2, pairs)
+			}
+		}
+	case n2.level > n1.level:
+		bb := n1.computeBoundingBox()
+		for _, e2 := range n2.entries {
+			if touch(bb, e2.bb) {
+				pairs = spatialJoin(n1, e2.child, pairs)
+			}
+		}
+	default:
+		for _, e1 := range n1.entries {
+			for _, e2 := range n2.entries {
+				if !n1.reaches(e1.bb, e2.bb) {
+					continue
+				}
+				if n1.leaf {
+					pairs = append(pairs, [2]Spatial{e1.obj, e2.obj})
+				} else {
+					pairs = spatialJoin(e1.child, e2.child, pairs)
+				}
+			}
+		}
+	}
+	return pairs
 }
 
 // utilities for sorting slices of entries
@@ -506,7 +2669,9 @@ func (tree *Rtree) nearestNeighbor(p Point, n *node, d float64, nearest Spatial)
 		}
 	} else {
 		branches, dists := sortEntries(p, n.entries)
-		branches = pruneEntries(p, branches, dists)
+		if !tree.loose() {
+			branches = pruneEntries(p, branches, dists)
+		}
 		for _, e := range branches {
 			subNearest, dist := tree.nearestNeighbor(p, e.child, d, nearest)
 			if dist < d {
@@ -519,54 +2684,402 @@ func (tree *Rtree) nearestNeighbor(p Point, n *node, d float64, nearest Spatial)
 	return nearest, d
 }
 
+// NearestNeighbors returns the k objects closest to p, nearest first, or all
+// of them if the tree holds fewer than k; the result is empty, never nil, for
+// an empty tree or a k that is not positive.  Objects at the same distance are
+// ordered canonically, as by AllOrderedByBounds: by the corners of their
+// bounding boxes, then by their IDs if they are Identifiable, so that the
+// order doesn't depend on the shape of the tree.  Only objects that tie on
+// all of these come out in the order they are found.
 func (tree *Rtree) NearestNeighbors(k int, p Point) []Spatial {
+	if k <= 0 {
+		return []Spatial{}
+	}
+	objs, _ := tree.nearestNeighborsTrimmed(k, p, nil)
+	return objs
+}
+
+// NearestNeighborsDist is like NearestNeighbors, but also returns the
+// distance from p to each object, as used to rank them: the Euclidean
+// distance to the nearest point of its bounding box.  Fewer than k objects
+// are returned if the tree holds fewer, and none if k is not positive.
+func (tree *Rtree) NearestNeighborsDist(k int, p Point) ([]Spatial, []float64) {
+	return tree.nearestNeighborsTrimmed(k, p, nil)
+}
+
+// NearestNeighborsFilter returns the k objects closest to p that filter
+// accepts, in order of increasing distance, or all of them if fewer are
+// accepted.  filter is called only on the objects in the leaves the search
+// visits, never on nodes.  Rejected objects don't count towards k, so the
+// search only prunes the subtrees farther than the k-th accepted object.
+func (tree *Rtree) NearestNeighborsFilter(k int, p Point, filter func(obj Spatial) bool) []Spatial {
+	objs, _ := tree.nearestNeighborsTrimmed(k, p, filter)
+	return objs
+}
+
+// NearestNeighborExcept returns the closest object to p other than exclude,
+// or nil if there is none, as for finding the nearest other object to each
+// object of the tree.  Every stored object equal to exclude is skipped,
+// comparing them as Delete does.
+func (tree *Rtree) NearestNeighborExcept(p Point, exclude Spatial) Spatial {
+	objs := tree.NearestNeighborsExcept(1, p, exclude)
+	if len(objs) == 0 {
+		return nil
+	}
+	return objs[0]
+}
+
+// NearestNeighborsExcept is like NearestNeighbors, but skips every stored
+// object equal to exclude, as NearestNeighborExcept does.
+func (tree *Rtree) NearestNeighborsExcept(k int, p Point, exclude Spatial) []Spatial {
+	if k <= 0 {
+		return []Spatial{}
+	}
+	objs, _ := tree.nearestNeighborsTrimmed(k, p, func(obj Spatial) bool {
+		return !defaultComparator(exclude, obj)
+	})
+	return objs
+}
+
+// NearestNeighborsDistinct returns the objects closest to p with the first k
+// distinct keys, in order of increasing distance, or one for each key if
+// there are fewer than k.  Each key is represented by its nearest object;
+// farther objects with a key already seen are skipped and don't count
+// towards k, as for an index holding several boxes per entity.  Keys must be
+// comparable.  The search visits nodes in order of distance, as
+// NearestNeighborIterator does, so it stops as soon as it has found k keys.
+func (tree *Rtree) NearestNeighborsDistinct(k int, p Point, key func(obj Spatial) any) []Spatial {
+	objs := []Spatial{}
+	if k <= 0 {
+		return objs
+	}
+	seen := make(map[any]bool)
+	next := tree.NearestNeighborIterator(p)
+	for len(objs) < k {
+		obj, _, ok := next()
+		if !ok {
+			break
+		}
+		if kk := key(obj); !seen[kk] {
+			seen[kk] = true
+			objs = append(objs, obj)
+		}
+	}
+	return objs
+}
+
+// nearestNeighborsTrimmed finds the k objects closest to p that accept
+// admits, or all objects if accept is nil, without padding the results.
+func (tree *Rtree) nearestNeighborsTrimmed(k int, p Point, accept func(obj Spatial) bool) ([]Spatial, []float64) {
+	if k <= 0 {
+		return nil, nil
+	}
 	dists := make([]float64, k)
 	objs := make([]Spatial, k)
-	for i := 0; i < k; i++ {
+	buf := nnBuffers.Get().(*nnBuffer)
+	n := tree.nearestNeighborsIn(p, dists, objs, accept, buf)
+	nnBuffers.Put(buf)
+	return objs[:n], dists[:n]
+}
+
+// NearestNeighborsInto is like NearestNeighbors with k = len(dst), but stores
+// the objects in dst and returns the prefix of it that they fill, so that a
+// buffer can be reused across searches.  Once its scratch space has grown to
+// fit, a search doesn't allocate.
+func (tree *Rtree) NearestNeighborsInto(dst []Spatial, p Point) []Spatial {
+	if len(dst) == 0 {
+		return dst
+	}
+	buf := nnBuffers.Get().(*nnBuffer)
+	buf.dists = slices.Grow(buf.dists[:0], len(dst))[:len(dst)]
+	n := tree.nearestNeighborsIn(p, buf.dists, dst, nil, buf)
+	nnBuffers.Put(buf)
+	return dst[:n]
+}
+
+// nearestNeighborsIn stores the len(objs) objects closest to p that accept
+// admits, or all objects if accept is nil, in objs and their distances in
+// dists, using the scratch space of buf, and returns how many it found.
+func (tree *Rtree) nearestNeighborsIn(p Point, dists []float64, objs []Spatial, accept func(obj Spatial) bool, buf *nnBuffer) int {
+	for i := range dists {
 		dists[i] = math.MaxFloat64
-		objs[i] = nil
 	}
-	objs, _ = tree.nearestNeighbors(k, p, tree.root, dists, objs)
+	clear(objs)
+	k := len(objs)
+	tree.nearestNeighbors(k, p, tree.root, dists, objs, accept, buf)
+	n := 0
+	for n < k && objs[n] != nil {
+		n++
+	}
+	return n
+}
+
+// NearestNeighborsWithin returns every object within distance radius of p,
+// including those exactly at it, in order of increasing distance.  Subtrees
+// farther than radius from p are never visited.
+func (tree *Rtree) NearestNeighborsWithin(p Point, radius float64) []Spatial {
+	var within entrySlice
+	tree.nearestNeighborsWithin(p, radius*radius, tree.root, &within)
+	sort.Stable(within)
+	objs := make([]Spatial, len(within.entries))
+	for i, e := range within.entries {
+		objs[i] = e.obj
+	}
 	return objs
 }
 
-// insert obj into nearest and return the first k elements in increasing order.
+func (tree *Rtree) nearestNeighborsWithin(p Point, r2 float64, n *node, within *entrySlice) {
+	for _, e := range n.entries {
+		d := p.minDist(e.bb)
+		if !(d <= r2) {
+			continue
+		}
+		if n.leaf {
+			within.entries = append(within.entries, e)
+			within.dists = append(within.dists, d)
+		} else {
+			tree.nearestNeighborsWithin(p, r2, e.child, within)
+		}
+	}
+}
+
+// NearestNeighborIterator returns a function that yields the objects of the
+// tree one at a time in order of increasing distance from p, with the
+// distance as for NearestNeighborsDist, and reports false once every object
+// has been yielded.  Each call does only the work needed to find the next
+// object, so the caller can stop as soon as it has found one it wants,
+// without choosing k in advance.  The tree must not be modified while the
+// iterator is in use.
+//
+// Implemented per "Distance Browsing in Spatial Databases" by G. Hjaltason
+// and H. Samet, ACM Transactions on Database Systems 24(2), p. 265-318,
+// 1999: a single priority queue holds both nodes and objects by their
+// distance from p, and an object is yielded when it reaches the front, as
+// nothing still in the queue can hold anything nearer.
+func (tree *Rtree) NearestNeighborIterator(p Point) func() (Spatial, float64, bool) {
+	queue := &distQueue{}
+	for _, e := range tree.root.entries {
+		queue.push(queuedEntry{e, p.minDist(e.bb)})
+	}
+	return func() (Spatial, float64, bool) {
+		for queue.Len() > 0 {
+			item := queue.pop()
+			if item.e.child == nil {
+				return item.e.obj, math.Sqrt(item.dist), true
+			}
+			for _, e := range item.e.child.entries {
+				queue.push(queuedEntry{e, p.minDist(e.bb)})
+			}
+		}
+		return nil, 0, false
+	}
+}
+
+// queuedEntry is an entry waiting in a distQueue, with the squared distance
+// from the query point to its bounding box.
+type queuedEntry struct {
+	e    entry
+	dist float64
+}
+
+// distQueue is a min-heap of entries by distance.
+type distQueue []queuedEntry
+
+func (q distQueue) Len() int           { return len(q) }
+func (q distQueue) Less(i, j int) bool { return q[i].dist < q[j].dist }
+func (q distQueue) Swap(i, j int)      { q[i], q[j] = q[j], q[i] }
+
+func (q *distQueue) Push(x any) { *q = append(*q, x.(queuedEntry)) }
+
+func (q *distQueue) Pop() any {
+	old := *q
+	x := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return x
+}
+
+// push and pop are like heap.Push and heap.Pop, but don't convert the entries
+// to any, which would allocate.
+func (q *distQueue) push(x queuedEntry) {
+	*q = append(*q, x)
+	heap.Fix(q, len(*q)-1)
+}
+
+func (q *distQueue) pop() queuedEntry {
+	old := *q
+	x := old[0]
+	n := len(old) - 1
+	old[0] = old[n]
+	old[n] = queuedEntry{}
+	*q = old[:n]
+	if n > 0 {
+		heap.Fix(q, 0)
+	}
+	return x
+}
+
+// NNSearcher finds nearest neighbors in a tree, reusing its priority queue
+// from one search to the next, so that repeated searches don't allocate once
+// the queue has grown to the size they need.  An NNSearcher is not safe for
+// concurrent use; give each goroutine its own.  It sees the tree as it is at
+// each call, so the tree may be modified between calls, but not during one.
+type NNSearcher struct {
+	tree  *Rtree
+	queue distQueue
+}
+
+// NewNNSearcher returns an NNSearcher for tree.
+func (tree *Rtree) NewNNSearcher() *NNSearcher {
+	return &NNSearcher{tree: tree}
+}
+
+// Nearest returns the object in the tree closest to p, or nil if the tree is
+// empty.  Like NearestNeighborIterator, it expands nodes and objects in order
+// of distance from a single priority queue, and stops at the first object.
+func (s *NNSearcher) Nearest(p Point) Spatial {
+	defer s.reset()
+	for _, e := range s.tree.root.entries {
+		s.queue.push(queuedEntry{e, p.minDist(e.bb)})
+	}
+	for len(s.queue) > 0 {
+		item := s.queue.pop()
+		if item.e.child == nil {
+			return item.e.obj
+		}
+		for _, e := range item.e.child.entries {
+			s.queue.push(queuedEntry{e, p.minDist(e.bb)})
+		}
+	}
+	return nil
+}
+
+// reset empties the queue, keeping its storage, and drops its references to
+// the tree.
+func (s *NNSearcher) reset() {
+	clear(s.queue)
+	s.queue = s.queue[:0]
+}
+
+// SearchSwept returns every object that a box moving from start to end may
+// hit along the way, as in continuous collision detection: the objects
+// intersecting the box at some point of its motion.  Each corner of the box
+// moves in a straight line at constant speed, so the box may grow or shrink
+// if start and end differ in size.  The swept volume itself is tested, not
+// just its bounding box, so objects beside a diagonal path are left out.
+// With start and end equal it finds the same objects as SearchIntersect.
+func (tree *Rtree) SearchSwept(start, end *Rect) []Spatial {
+	return tree.searchSwept(tree.root, start, end, []Spatial{})
+}
+
+func (tree *Rtree) searchSwept(n *node, start, end *Rect, results []Spatial) []Spatial {
+	for _, e := range n.entries {
+		lo, hi, ok := sweptInterval(start, end, e.bb)
+		if !ok {
+			continue
+		}
+		if !n.leaf {
+			results = tree.searchSwept(e.child, start, end, results)
+		} else if mid := lerpRect(start, end, (lo+hi)/2); intersect(&mid, e.bb) {
+			results = append(results, e.obj)
+		}
+	}
+	return results
+}
+
+// SearchSphere returns every object whose bounding box meets the sphere with
+// the specified center and radius, that is, whose nearest point to center
+// lies within radius of it, including those exactly at it.  It finds the same
+// objects as NearestNeighborsWithin, but in tree order, without sorting them.
+// Subtrees that don't meet the sphere are never visited.
+func (tree *Rtree) SearchSphere(center Point, radius float64) []Spatial {
+	return tree.searchSphere(tree.root, center, radius*radius, []Spatial{})
+}
+
+func (tree *Rtree) searchSphere(n *node, center Point, r2 float64, results []Spatial) []Spatial {
+	for _, e := range n.entries {
+		if !(center.minDist(e.bb) <= r2) {
+			continue
+		}
+		if n.leaf {
+			results = append(results, e.obj)
+		} else {
+			results = tree.searchSphere(e.child, center, r2, results)
+		}
+	}
+	return results
+}
+
+// insert obj into nearest, in place, and return the first k elements in
+// increasing order.
+// Objects at the same distance are ordered canonically, and otherwise in the
+// order they are inserted.  Objects at NaN distance are never inserted.
 func insertNearest(k int, dists []float64, nearest []Spatial, dist float64, obj Spatial) ([]float64, []Spatial) {
 	i := 0
-	for i < k && dist >= dists[i] {
+	for i < k && !(dist < dists[i] || dist == dists[i] && nearest[i] != nil &&
+		lessCanonical(obj.Bounds(), nearest[i].Bounds(), obj, nearest[i])) {
 		i++
 	}
 	if i >= k {
 		return dists, nearest
 	}
 
-	left, right := dists[:i], dists[i:k-1]
-	updatedDists := make([]float64, k)
-	copy(updatedDists, left)
-	updatedDists[i] = dist
-	copy(updatedDists[i+1:], right)
-
-	leftObjs, rightObjs := nearest[:i], nearest[i:k-1]
-	updatedNearest := make([]Spatial, k)
-	copy(updatedNearest, leftObjs)
-	updatedNearest[i] = obj
-	copy(updatedNearest[i+1:], rightObjs)
-
-	return updatedDists, updatedNearest
+	copy(dists[i+1:k], dists[i:k-1])
+	dists[i] = dist
+	copy(nearest[i+1:k], nearest[i:k-1])
+	nearest[i] = obj
+	return dists, nearest
 }
 
-func (tree *Rtree) nearestNeighbors(k int, p Point, n *node, dists []float64, nearest []Spatial) ([]Spatial, []float64) {
+func (tree *Rtree) nearestNeighbors(k int, p Point, n *node, dists []float64, nearest []Spatial, accept func(obj Spatial) bool, buf *nnBuffer) ([]Spatial, []float64) {
 	if n.leaf {
 		for _, e := range n.entries {
+			if accept != nil && !accept(e.obj) {
+				continue
+			}
 			dist := math.Sqrt(p.minDist(e.bb))
 			dists, nearest = insertNearest(k, dists, nearest, dist, e.obj)
 		}
 	} else {
-		branches, branchDists := sortEntries(p, n.entries)
-		branches = pruneEntries(p, branches, branchDists)
-		for _, e := range branches {
-			nearest, dists = tree.nearestNeighbors(k, p, e.child, dists, nearest)
+		// Branches are visited in order of distance until the rest can't
+		// beat the k-th nearest object found so far, nor tie with it and
+		// come first canonically.  The MinMax pruning of NearestNeighbor
+		// only bounds the distance of the single nearest object, so it
+		// would discard the branches holding the others.
+		base := len(buf.branches)
+		for _, e := range n.entries {
+			buf.branches = append(buf.branches, queuedEntry{e, p.minDist(e.bb)})
+		}
+		branches := buf.branches[base:]
+		sortQueued(branches)
+		for _, b := range branches {
+			if k == 0 || math.Sqrt(b.dist) > dists[k-1] {
+				break
+			}
+			nearest, dists = tree.nearestNeighbors(k, p, b.e.child, dists, nearest, accept, buf)
 		}
+		clear(branches)
+		buf.branches = buf.branches[:base]
 	}
 	return nearest, dists
 }
+
+// nnBuffer holds the scratch space of a k-nearest-neighbors search: the
+// distances of the neighbors found so far, and a stack of the branches of
+// the nodes being visited, sorted by distance.  Buffers are pooled, so that
+// repeated searches don't allocate them.
+type nnBuffer struct {
+	dists    []float64
+	branches []queuedEntry
+}
+
+var nnBuffers = sync.Pool{New: func() any { return new(nnBuffer) }}
+
+// sortQueued sorts entries by increasing distance with an insertion sort,
+// which suits the few entries of a node and doesn't allocate.
+func sortQueued(entries []queuedEntry) {
+	for i := 1; i < len(entries); i++ {
+		for j := i; j > 0 && entries[j].dist < entries[j-1].dist; j-- {
+			entries[j], entries[j-1] = entries[j-1], entries[j]
+		}
+	}
+}
@@ -1,16 +1,16 @@
 package rtreego
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"math"
 	"math/rand"
+	"sort"
 	"strings"
 	"testing"
 )
 
-func (r *Rect) Bounds() *Rect {
-	return r
-}
-
 func mustRect(p Point, widths [Dim]float64) *Rect {
 	if widths[Dim-1] == 0 {
 		widths[Dim-1] = 1
@@ -133,13 +133,13 @@ func TestChooseLeafNode(t *testing.T) {
 		rt := Rtree{}
 		rt.root = &node{}
 
-		leaf0 := &node{rt.root, true, []entry{}, 1}
+		leaf0 := &node{parent: rt.root, leaf: true, entries: []entry{}, level: 1}
 		entry0 := entry{test.bb0, leaf0, nil}
 
-		leaf1 := &node{rt.root, true, []entry{}, 1}
+		leaf1 := &node{parent: rt.root, leaf: true, entries: []entry{}, level: 1}
 		entry1 := entry{test.bb1, leaf1, nil}
 
-		leaf2 := &node{rt.root, true, []entry{}, 1}
+		leaf2 := &node{parent: rt.root, leaf: true, entries: []entry{}, level: 1}
 		entry2 := entry{test.bb2, leaf2, nil}
 
 		rt.root.entries = []entry{entry0, entry1, entry2}
@@ -192,7 +192,7 @@ func TestSplit(t *testing.T) {
 	entries := []entry{entry1, entry2, entry3, entry4, entry5}
 	n := &node{entries: entries}
 
-	l, r := n.split(0) // left=entry2, right=entry4
+	l, r := n.split(&node{}, 0) // left=entry2, right=entry4
 	expLeft := mustRect(Point{1, -1}, [Dim]float64{2, 4, 1})
 	expRight := mustRect(Point{-3, -3}, [Dim]float64{3, 4, 1})
 
@@ -215,7 +215,7 @@ func TestSplitUnderflow(t *testing.T) {
 	entries := []entry{entry1, entry2, entry3, entry4, entry5}
 	n := &node{entries: entries}
 
-	l, r := n.split(2)
+	l, r := n.split(&node{}, 2)
 
 	if len(l.entries) != 3 || len(r.entries) != 2 {
 		t.Errorf("expected underflow assignment for right group")
@@ -275,7 +275,7 @@ func TestAdjustTreeNoPreviousSplit(t *testing.T) {
 	r01 := entry{bb: mustRect(Point{0, 1}, [Dim]float64{1, 1, 1})}
 	r10 := entry{bb: mustRect(Point{1, 0}, [Dim]float64{1, 1, 1})}
 	entries := []entry{r00, r01, r10}
-	n := node{rt.root, false, entries, 1}
+	n := node{parent: rt.root, leaf: false, entries: entries, level: 1}
 	rt.root.entries = []entry{entry{bb: Point{0, 0}.ToRect(0), child: &n}}
 
 	rt.adjustTree(&n, nil)
@@ -288,16 +288,16 @@ func TestAdjustTreeNoPreviousSplit(t *testing.T) {
 }
 
 func TestAdjustTreeNoSplit(t *testing.T) {
-	rt := NewTree(3, 3)
+	rt := NewTree(2, 3)
 
 	r00 := entry{bb: mustRect(Point{0, 0}, [Dim]float64{1, 1})}
 	r01 := entry{bb: mustRect(Point{0, 1}, [Dim]float64{1, 1})}
-	left := node{rt.root, false, []entry{r00, r01}, 1}
+	left := node{parent: rt.root, leaf: false, entries: []entry{r00, r01}, level: 1}
 	leftEntry := entry{bb: Point{0, 0}.ToRect(0), child: &left}
 
 	r10 := entry{bb: mustRect(Point{1, 0}, [Dim]float64{1, 1})}
 	r11 := entry{bb: mustRect(Point{1, 1}, [Dim]float64{1, 1})}
-	right := node{rt.root, false, []entry{r10, r11}, 1}
+	right := node{parent: rt.root, leaf: false, entries: []entry{r10, r11}, level: 1}
 
 	rt.root.entries = []entry{leftEntry}
 	retl, retr := rt.adjustTree(&left, &right)
@@ -320,17 +320,55 @@ func TestAdjustTreeNoSplit(t *testing.T) {
 	}
 }
 
+func TestNewTreeInvalidBranching(t *testing.T) {
+	for _, tt := range []struct{ min, max int }{
+		{0, 5},
+		{-1, 5},
+		{1, 1},
+		{1, 0},
+		{4, 3},
+		{7, 2},
+		{3, 3},
+		{2, 2},
+		{4, 6},
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("NewTree(%d, %d) didn't panic", tt.min, tt.max)
+				}
+			}()
+			NewTree(tt.min, tt.max)
+		}()
+		if _, err := BulkLoad(tt.min, tt.max, 1, nil); err == nil {
+			t.Errorf("BulkLoad(%d, %d) didn't fail", tt.min, tt.max)
+		}
+	}
+
+	for _, tt := range []struct{ min, max int }{{1, 2}, {2, 3}, {3, 5}, {20, 50}} {
+		if rt := NewTree(tt.min, tt.max); rt.MinChildren != tt.min || rt.MaxChildren != tt.max {
+			t.Errorf("NewTree(%d, %d) has branching factors %d, %d", tt.min, tt.max, rt.MinChildren, rt.MaxChildren)
+		}
+	}
+
+	want := "rtreego: MinChildren 3 is greater than (MaxChildren+1)/2 for MaxChildren 3"
+	if _, err := BulkLoad(3, 3, 1, nil); err == nil || err.Error() != want {
+		t.Errorf("BulkLoad(3, 3) returned %v, want %q", err, want)
+	}
+}
+
 func TestAdjustTreeSplitParent(t *testing.T) {
-	rt := NewTree(1, 1)
+	rt := NewTree(1, 2)
+	rt.MaxChildren = 1 // so that the root overflows with two children
 
 	r00 := entry{bb: mustRect(Point{0, 0}, [Dim]float64{1, 1})}
 	r01 := entry{bb: mustRect(Point{0, 1}, [Dim]float64{1, 1})}
-	left := node{rt.root, false, []entry{r00, r01}, 1}
+	left := node{parent: rt.root, leaf: false, entries: []entry{r00, r01}, level: 1}
 	leftEntry := entry{bb: Point{0, 0}.ToRect(0), child: &left}
 
 	r10 := entry{bb: mustRect(Point{1, 0}, [Dim]float64{1, 1})}
 	r11 := entry{bb: mustRect(Point{1, 1}, [Dim]float64{1, 1})}
-	right := node{rt.root, false, []entry{r10, r11}, 1}
+	right := node{parent: rt.root, leaf: false, entries: []entry{r10, r11}, level: 1}
 
 	rt.root.entries = []entry{leftEntry}
 	retl, retr := rt.adjustTree(&left, &right)
@@ -357,7 +395,7 @@ func TestInsertRepeated(t *testing.T) {
 }
 
 func TestInsertNoSplit(t *testing.T) {
-	rt := NewTree(3, 3)
+	rt := NewTree(2, 3)
 	thing := mustRect(Point{0, 0}, [Dim]float64{2, 1})
 	rt.Insert(thing)
 
@@ -371,7 +409,7 @@ func TestInsertNoSplit(t *testing.T) {
 }
 
 func TestInsertSplitRoot(t *testing.T) {
-	rt := NewTree(3, 3)
+	rt := NewTree(2, 3)
 	things := []*Rect{
 		mustRect(Point{0, 0}, [Dim]float64{2, 1}),
 		mustRect(Point{3, 1}, [Dim]float64{1, 2}),
@@ -388,18 +426,19 @@ func TestInsertSplitRoot(t *testing.T) {
 		t.Errorf("Insert failed to insert")
 	}
 
-	if len(rt.root.entries) != 2 {
+	if len(rt.root.entries) != 3 {
 		t.Errorf("Insert failed to split")
 	}
 
-	left, right := rt.root.entries[0].child, rt.root.entries[1].child
-	if len(left.entries) != 3 || len(right.entries) != 3 {
-		t.Errorf("Insert failed to split evenly")
+	for _, e := range rt.root.entries {
+		if len(e.child.entries) != 2 {
+			t.Errorf("Insert failed to split evenly")
+		}
 	}
 }
 
 func TestInsertSplit(t *testing.T) {
-	rt := NewTree(3, 3)
+	rt := NewTree(2, 3)
 	things := []*Rect{
 		mustRect(Point{0, 0}, [Dim]float64{2, 1}),
 		mustRect(Point{3, 1}, [Dim]float64{1, 2}),
@@ -422,15 +461,15 @@ func TestInsertSplit(t *testing.T) {
 	}
 
 	a, b, c := rt.root.entries[0], rt.root.entries[1], rt.root.entries[2]
-	if len(a.child.entries) != 3 ||
-		len(b.child.entries) != 3 ||
-		len(c.child.entries) != 1 {
+	if len(a.child.entries) != 2 ||
+		len(b.child.entries) != 2 ||
+		len(c.child.entries) != 3 {
 		t.Errorf("Insert failed to split evenly")
 	}
 }
 
 func TestInsertSplitSecondLevel(t *testing.T) {
-	rt := NewTree(3, 3)
+	rt := NewTree(2, 3)
 	things := []*Rect{
 		mustRect(Point{0, 0}, [Dim]float64{2, 1}),
 		mustRect(Point{3, 1}, [Dim]float64{1, 2}),
@@ -477,7 +516,7 @@ func TestInsertSplitSecondLevel(t *testing.T) {
 }
 
 func TestFindLeaf(t *testing.T) {
-	rt := NewTree(3, 3)
+	rt := NewTree(2, 3)
 	things := []*Rect{
 		mustRect(Point{0, 0}, [Dim]float64{2, 1}),
 		mustRect(Point{3, 1}, [Dim]float64{1, 2}),
@@ -495,7 +534,7 @@ func TestFindLeaf(t *testing.T) {
 	}
 	verify(t, rt.root)
 	for _, thing := range things {
-		leaf := rt.findLeaf(rt.root, thing)
+		leaf := rt.findLeaf(rt.root, thing, defaultComparator)
 		if leaf == nil {
 			printNode(rt.root, 0)
 			t.Errorf("Unable to find leaf containing an entry after insertion!")
@@ -516,7 +555,7 @@ func TestFindLeaf(t *testing.T) {
 }
 
 func TestFindLeafDoesNotExist(t *testing.T) {
-	rt := NewTree(3, 3)
+	rt := NewTree(2, 3)
 	things := []*Rect{
 		mustRect(Point{0, 0}, [Dim]float64{2, 1}),
 		mustRect(Point{3, 1}, [Dim]float64{1, 2}),
@@ -534,14 +573,14 @@ func TestFindLeafDoesNotExist(t *testing.T) {
 	}
 
 	obj := mustRect(Point{99, 99}, [Dim]float64{99, 99})
-	leaf := rt.findLeaf(rt.root, obj)
+	leaf := rt.findLeaf(rt.root, obj, defaultComparator)
 	if leaf != nil {
 		t.Errorf("findLeaf failed to return nil for non-existent object")
 	}
 }
 
 func TestCondenseTreeEliminate(t *testing.T) {
-	rt := NewTree(3, 3)
+	rt := NewTree(2, 3)
 	things := []*Rect{
 		mustRect(Point{0, 0}, [Dim]float64{2, 1}),
 		mustRect(Point{3, 1}, [Dim]float64{1, 2}),
@@ -558,9 +597,25 @@ func TestCondenseTreeEliminate(t *testing.T) {
 		rt.Insert(thing)
 	}
 
-	// delete entry 2 from parent entries
-	parent := rt.root.entries[0].child.entries[1].child
-	parent.entries = append(parent.entries[:2], parent.entries[3:]...)
+	// delete an entry from a leaf holding exactly MinChildren entries
+	var parent *node
+	var find func(n *node)
+	find = func(n *node) {
+		if n.leaf {
+			if parent == nil && n != rt.root && len(n.entries) == rt.MinChildren {
+				parent = n
+			}
+			return
+		}
+		for _, e := range n.entries {
+			find(e.child)
+		}
+	}
+	find(rt.root)
+	if parent == nil {
+		t.Fatal("no leaf with MinChildren entries")
+	}
+	parent.entries = parent.entries[1:]
 	rt.condenseTree(parent)
 
 	retrieved := []Spatial{}
@@ -576,7 +631,7 @@ func TestCondenseTreeEliminate(t *testing.T) {
 }
 
 func TestChooseNodeNonLeaf(t *testing.T) {
-	rt := NewTree(3, 3)
+	rt := NewTree(2, 3)
 	things := []*Rect{
 		mustRect(Point{0, 0}, [Dim]float64{2, 1}),
 		mustRect(Point{3, 1}, [Dim]float64{1, 2}),
@@ -602,7 +657,7 @@ func TestChooseNodeNonLeaf(t *testing.T) {
 }
 
 func TestInsertNonLeaf(t *testing.T) {
-	rt := NewTree(3, 3)
+	rt := NewTree(2, 3)
 	things := []*Rect{
 		mustRect(Point{0, 0}, [Dim]float64{2, 1}),
 		mustRect(Point{3, 1}, [Dim]float64{1, 2}),
@@ -623,14 +678,14 @@ func TestInsertNonLeaf(t *testing.T) {
 	e := entry{obj, nil, obj}
 	rt.insert(e, 2)
 
-	expected := rt.root.entries[1].child
-	if expected.entries[1].obj != obj {
+	expected := rt.root.entries[2].child
+	if expected.level != 2 || expected.entries[0].obj != obj {
 		t.Errorf("insert failed to insert entry at correct level")
 	}
 }
 
 func TestDeleteFlatten(t *testing.T) {
-	rt := NewTree(3, 3)
+	rt := NewTree(2, 3)
 	things := []*Rect{
 		mustRect(Point{0, 0}, [Dim]float64{2, 1}),
 		mustRect(Point{3, 1}, [Dim]float64{1, 2}),
@@ -645,7 +700,7 @@ func TestDeleteFlatten(t *testing.T) {
 }
 
 func TestDelete(t *testing.T) {
-	rt := NewTree(3, 3)
+	rt := NewTree(2, 3)
 	things := []*Rect{
 		mustRect(Point{0, 0}, [Dim]float64{2, 1}),
 		mustRect(Point{3, 1}, [Dim]float64{1, 2}),
@@ -687,7 +742,7 @@ func TestDelete(t *testing.T) {
 }
 
 func TestSearchIntersect(t *testing.T) {
-	rt := NewTree(3, 3)
+	rt := NewTree(2, 3)
 	things := []*Rect{
 		mustRect(Point{0, 0}, [Dim]float64{2, 1}),
 		mustRect(Point{3, 1}, [Dim]float64{1, 2}),
@@ -719,7 +774,7 @@ func TestSearchIntersect(t *testing.T) {
 }
 
 func TestSearchIntersectNoResults(t *testing.T) {
-	rt := NewTree(3, 3)
+	rt := NewTree(2, 3)
 	things := []*Rect{
 		mustRect(Point{0, 0}, [Dim]float64{2, 1}),
 		mustRect(Point{3, 1}, [Dim]float64{1, 2}),
@@ -764,7 +819,7 @@ func TestSortEntries(t *testing.T) {
 }
 
 func TestNearestNeighbor(t *testing.T) {
-	rt := NewTree(3, 3)
+	rt := NewTree(2, 3)
 	things := []*Rect{
 		mustRect(Point{1, 1}, [Dim]float64{1, 1}),
 		mustRect(Point{1, 3}, [Dim]float64{1, 1}),
@@ -788,7 +843,7 @@ func TestNearestNeighbor(t *testing.T) {
 }
 
 func TestNearestNeighbors(t *testing.T) {
-	rt := NewTree(3, 3)
+	rt := NewTree(2, 3)
 	things := []*Rect{
 		mustRect(Point{1, 1}, [Dim]float64{1, 1}),
 		mustRect(Point{-7, -7}, [Dim]float64{1, 1}),
@@ -809,7 +864,7 @@ func TestNearestNeighbors(t *testing.T) {
 
 func BenchmarkSearchIntersect(b *testing.B) {
 	b.StopTimer()
-	rt := NewTree(3, 3)
+	rt := NewTree(2, 3)
 	things := []*Rect{
 		mustRect(Point{0, 0, 0}, [Dim]float64{2, 1, 1}),
 		mustRect(Point{3, 1, 0}, [Dim]float64{1, 2, 1}),
@@ -834,7 +889,7 @@ func BenchmarkSearchIntersect(b *testing.B) {
 
 func BenchmarkInsert(b *testing.B) {
 	for i := 0; i < b.N; i++ {
-		rt := NewTree(3, 3)
+		rt := NewTree(2, 3)
 		things := []*Rect{
 			mustRect(Point{0, 0, 0}, [Dim]float64{2, 1, 1}),
 			mustRect(Point{3, 1, 0}, [Dim]float64{1, 2, 1}),
@@ -852,3 +907,2901 @@ func BenchmarkInsert(b *testing.B) {
 		}
 	}
 }
+
+func BenchmarkNearestNeighbor(b *testing.B) {
+	rt := NewTree(3, 6)
+	for _, obj := range randomRects(5000, 40) {
+		rt.Insert(obj)
+	}
+	p := Point{50, 50, 50}
+	b.Run("NearestNeighbor", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			rt.NearestNeighbor(p)
+		}
+	})
+	b.Run("NNSearcher", func(b *testing.B) {
+		s := rt.NewNNSearcher()
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			s.Nearest(p)
+		}
+	})
+	b.Run("NearestNeighbors", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			rt.NearestNeighbors(10, p)
+		}
+	})
+	b.Run("NearestNeighborsInto", func(b *testing.B) {
+		dst := make([]Spatial, 10)
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			rt.NearestNeighborsInto(dst, p)
+		}
+	})
+}
+
+func TestSmallTreeStaysFlat(t *testing.T) {
+	objs := randomRects(4, 51)
+	rt := NewTree(1, 3)
+	for _, obj := range objs[:3] {
+		rt.Insert(obj)
+	}
+	for i := 0; i < 10; i++ {
+		rt.Insert(objs[3])
+		if rt.Depth() != 2 {
+			t.Fatalf("tree of 4 objects has depth %d; expected 2", rt.Depth())
+		}
+		rt.Delete(objs[i%4])
+		if rt.Depth() != 1 || !rt.root.leaf || len(rt.root.entries) != 3 {
+			t.Fatalf("tree of 3 objects after cycle %d has depth %d; expected a single leaf", i, rt.Depth())
+		}
+		if err := rt.Validate(); err != nil {
+			t.Fatalf("tree invalid after cycle %d: %v", i, err)
+		}
+		objs[i%4], objs[3] = objs[3], objs[i%4]
+	}
+
+	// deletions pending compaction flatten the tree once compacted
+	lazy := NewTree(1, 3, WithLazyCondense())
+	for _, obj := range objs {
+		lazy.Insert(obj)
+	}
+	lazy.Delete(objs[0])
+	lazy.Compact()
+	if lazy.Depth() != 1 || lazy.Size() != 3 {
+		t.Errorf("compacted tree of 3 objects has depth %d and size %d; expected a single leaf", lazy.Depth(), lazy.Size())
+	}
+}
+
+func TestNodeReuse(t *testing.T) {
+	rt := NewTree(2, 4)
+	objs := randomRects(500, 42)
+	for _, obj := range objs {
+		rt.Insert(obj)
+	}
+	for _, obj := range objs[:400] {
+		rt.Delete(obj)
+	}
+	if len(rt.free) == 0 || len(rt.free) > maxFreeNodes {
+		t.Errorf("tree kept %d freed nodes; expected between 1 and %d", len(rt.free), maxFreeNodes)
+	}
+	reachable := map[*node]bool{}
+	var walk func(n *node)
+	walk = func(n *node) {
+		reachable[n] = true
+		for _, e := range n.entries {
+			if e.child != nil {
+				walk(e.child)
+			}
+		}
+	}
+	walk(rt.root)
+	for _, n := range rt.free {
+		if reachable[n] || len(n.entries) != 0 {
+			t.Errorf("freed node %v is still in use", n)
+		}
+	}
+
+	for _, obj := range objs[:400] {
+		rt.Insert(obj)
+	}
+	verify(t, rt.root)
+	if rt.Size() != len(objs) {
+		t.Errorf("tree has size %d; expected %d", rt.Size(), len(objs))
+	}
+	for _, obj := range objs {
+		if rt.findLeaf(rt.root, obj, defaultComparator) == nil {
+			t.Errorf("failed to find %v after reusing nodes", obj)
+		}
+	}
+}
+
+func BenchmarkInsertDelete(b *testing.B) {
+	rt := NewTree(3, 6)
+	objs := randomRects(2000, 41)
+	for _, obj := range objs {
+		rt.Insert(obj)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		obj := objs[i*7919%len(objs)]
+		rt.Delete(obj)
+		rt.Insert(obj)
+	}
+}
+
+func TestSmallestCovering(t *testing.T) {
+	rt := NewTree(2, 3)
+	things := []*Rect{
+		mustRect(Point{0, 0}, [Dim]float64{10, 10}),
+		mustRect(Point{1, 1}, [Dim]float64{4, 4}),
+		mustRect(Point{2, 2}, [Dim]float64{2, 2}),
+		mustRect(Point{6, 6}, [Dim]float64{1, 1}),
+		mustRect(Point{20, 20}, [Dim]float64{1, 1}),
+		mustRect(Point{-5, -5}, [Dim]float64{30, 30}),
+	}
+	for _, thing := range things {
+		rt.Insert(thing)
+	}
+
+	tests := []struct {
+		bb  *Rect
+		exp Spatial
+	}{
+		{mustRect(Point{2.5, 2.5}, [Dim]float64{1, 1}), things[2]},
+		{mustRect(Point{1.5, 1.5}, [Dim]float64{3, 1}), things[1]},
+		{mustRect(Point{6, 6}, [Dim]float64{2, 2}), things[0]},
+		{mustRect(Point{15, 15}, [Dim]float64{1, 1}), things[5]},
+	}
+	for _, test := range tests {
+		if obj := rt.SmallestCovering(test.bb); obj != test.exp {
+			t.Errorf("SmallestCovering(%v) = %v; expected %v", test.bb, obj, test.exp)
+		}
+		if obj := rt.SmallestContaining(test.bb); obj != test.exp {
+			t.Errorf("SmallestContaining(%v) = %v; expected %v", test.bb, obj, test.exp)
+		}
+	}
+
+	if obj := rt.SmallestCovering(mustRect(Point{50, 50}, [Dim]float64{1, 1})); obj != nil {
+		t.Errorf("SmallestCovering returned %v for an uncovered rect", obj)
+	}
+}
+
+func TestBounds(t *testing.T) {
+	rt := NewTree(2, 3)
+	if bb := rt.Bounds(); bb != nil {
+		t.Errorf("Bounds() = %v for an empty tree; expected nil", bb)
+	}
+
+	things := []*Rect{
+		mustRect(Point{0, 0}, [Dim]float64{2, 1}),
+		mustRect(Point{3, 1}, [Dim]float64{1, 2}),
+		mustRect(Point{8, 6}, [Dim]float64{1, 1}),
+		mustRect(Point{-1, 2}, [Dim]float64{1, 2}),
+	}
+	for _, thing := range things {
+		rt.Insert(thing)
+	}
+
+	expected := mustRect(Point{-1, 0}, [Dim]float64{10, 7})
+	if bb := rt.Bounds(); !bb.Equal(expected) {
+		t.Errorf("Bounds() = %v; expected %v", bb, expected)
+	}
+}
+
+func TestOverlapsTree(t *testing.T) {
+	rt1, rt2, rt3 := NewTree(2, 3), NewTree(2, 3), NewTree(2, 3)
+	rt1.Insert(mustRect(Point{0, 0}, [Dim]float64{2, 2}))
+	rt1.Insert(mustRect(Point{4, 4}, [Dim]float64{2, 2}))
+	rt2.Insert(mustRect(Point{3, 3}, [Dim]float64{0.5, 0.5}))
+	rt3.Insert(mustRect(Point{10, 10}, [Dim]float64{1, 1}))
+
+	if !rt1.OverlapsTree(rt2) || !rt2.OverlapsTree(rt1) {
+		t.Errorf("OverlapsTree failed to detect overlapping extents")
+	}
+	if rt1.OverlapsTree(rt3) {
+		t.Errorf("OverlapsTree reported disjoint extents as overlapping")
+	}
+	if empty := NewTree(2, 3); rt1.OverlapsTree(empty) || empty.OverlapsTree(rt1) {
+		t.Errorf("OverlapsTree reported an overlap with an empty tree")
+	}
+}
+
+func TestInsertWithCallback(t *testing.T) {
+	leaves := func(rt *Rtree) map[Spatial]*node {
+		m := map[Spatial]*node{}
+		rt.root.walkNodes(func(n *node) {
+			if n.leaf {
+				for _, e := range n.entries {
+					m[e.obj] = n
+				}
+			}
+		})
+		return m
+	}
+
+	objs := randomRects(300, 54)
+	for name, rt := range map[string]*Rtree{
+		"quadratic":     NewTree(3, 6),
+		"reinsertion":   NewTree(3, 6, WithReinsertPercentage(0.3)),
+		"lazy":          NewTree(3, 6, WithLazyCondense()),
+		"auto-optimize": NewTree(3, 6, WithAutoOptimize(1e-9)),
+	} {
+		splits, rebuilds := 0, 0
+		for i, obj := range objs {
+			// deletions leave underfull leaves for the lazy tree to
+			// condense on the next insertion
+			if i%3 == 2 {
+				rt.Delete(objs[i/2])
+			}
+			before := leaves(rt)
+			var moved []Spatial
+			rt.InsertWithCallback(obj, func(m []Spatial) { moved = m })
+			if moved == nil {
+				t.Fatalf("%s tree: InsertWithCallback reported nil", name)
+			}
+			if len(moved) > 0 {
+				splits++
+			}
+			reported := map[Spatial]bool{}
+			for _, m := range moved {
+				if m == obj || reported[m] {
+					t.Errorf("%s tree: InsertWithCallback reported %v twice or as moved", name, m)
+				}
+				reported[m] = true
+			}
+			changed := 0
+			for m, n := range leaves(rt) {
+				if m == obj || before[m] == n {
+					continue
+				}
+				changed++
+				if !reported[m] {
+					t.Errorf("%s tree: InsertWithCallback didn't report %v, which changed leaves", name, m)
+				}
+			}
+			// without reinsertion only the objects that changed leaves are
+			// reported; the leaves freed by condensing may be reused
+			if rt.reinsert == 0 && !rt.lazyCondense && changed != len(moved) {
+				t.Errorf("%s tree: InsertWithCallback reported %d objects; %d changed leaves", name, len(moved), changed)
+			}
+			if len(moved) == rt.Size()-1 {
+				rebuilds++
+			}
+		}
+		if splits == 0 {
+			t.Errorf("%s tree: no insertion moved any object", name)
+		}
+		if rt.optimizeAbove > 0 && rebuilds == 0 {
+			t.Errorf("%s tree: no insertion reported a rebuild of the tree", name)
+		}
+		verify(t, rt.root)
+	}
+}
+
+func TestInsertChecked(t *testing.T) {
+	rt := NewTree(3, 6)
+	obj := mustRect(Point{1, 2, 3}, [Dim]float64{1, 1, 1})
+	if err := rt.InsertChecked(obj); err != nil || !rt.Contains(obj, nil) {
+		t.Errorf("InsertChecked(%v) = %v", obj, err)
+	}
+
+	inverted := &Rect{Point{0, 5, 0}, Point{1, 4, 1}}
+	nan := &Rect{Point{0, math.NaN(), 0}, Point{1, 1, 1}}
+	for _, tt := range []struct {
+		obj    Spatial
+		target error
+	}{
+		{nilBounds{}, nil},
+		{inverted, ErrZeroLength},
+		{nan, ErrNaNCoordinate},
+	} {
+		err := rt.InsertChecked(tt.obj)
+		if err == nil || tt.target != nil && !errors.Is(err, tt.target) {
+			t.Errorf("InsertChecked(%v) returned %v; expected %v", tt.obj, err, tt.target)
+		}
+	}
+	if rt.Size() != 1 {
+		t.Errorf("InsertChecked inserted invalid objects; tree has size %d", rt.Size())
+	}
+}
+
+func TestInsertUnique(t *testing.T) {
+	rt := NewTree(2, 3)
+	thing := mustRect(Point{1, 1}, [Dim]float64{1, 1})
+	if !rt.InsertUnique(thing, nil) {
+		t.Errorf("InsertUnique refused to insert into an empty tree")
+	}
+	if rt.InsertUnique(thing, nil) {
+		t.Errorf("InsertUnique inserted a duplicate object")
+	}
+
+	sameBox := func(obj1, obj2 Spatial) bool {
+		return obj1.Bounds().Equal(obj2.Bounds())
+	}
+	if rt.InsertUnique(mustRect(Point{1, 1}, [Dim]float64{1, 1}), sameBox) {
+		t.Errorf("InsertUnique ignored the comparator")
+	}
+	if rt.Size() != 1 {
+		t.Errorf("InsertUnique changed size to %d; expected 1", rt.Size())
+	}
+}
+
+func TestInsertUniqueEqualBounds(t *testing.T) {
+	bb := mustRect(Point{1, 1, 1}, [Dim]float64{1, 1, 1})
+	rt := NewTree(2, 3)
+	for _, obj := range randomRects(100, 52) {
+		rt.Insert(obj)
+	}
+	stored := tagged{bb, []string{"a"}}
+	rt.Insert(stored)
+
+	// equal objects reporting equal bounds are found wherever they are stored
+	if rt.InsertUnique(tagged{bb, []string{"a"}}, nil) {
+		t.Errorf("InsertUnique inserted an object equal to a stored one")
+	}
+	if !rt.InsertUnique(tagged{bb, []string{"b"}}, nil) {
+		t.Errorf("InsertUnique refused an object with equal bounds that isn't equal")
+	}
+
+	// the contract is broken by an equal object with bounds elsewhere, which
+	// isn't searched for in the subtree holding the stored object
+	far := mustRect(Point{200, 200, 200}, [Dim]float64{1, 1, 1})
+	if !rt.InsertUnique(tagged{far, []string{"a"}}, nil) {
+		t.Errorf("InsertUnique searched outside the object's bounds")
+	}
+	if rt.Size() != 103 {
+		t.Errorf("InsertUnique left size %d; expected 103", rt.Size())
+	}
+	verify(t, rt.root)
+}
+
+func TestInsertAllUnique(t *testing.T) {
+	rt := NewTree(2, 3)
+	things := []Spatial{
+		mustRect(Point{0, 0}, [Dim]float64{2, 1}),
+		mustRect(Point{3, 1}, [Dim]float64{1, 2}),
+		mustRect(Point{1, 2}, [Dim]float64{2, 2}),
+		mustRect(Point{8, 6}, [Dim]float64{1, 1}),
+		mustRect(Point{10, 3}, [Dim]float64{1, 2}),
+	}
+	rt.Insert(things[3])
+
+	objs := append(things, things[0], things[4])
+	skipped := rt.InsertAllUnique(objs, nil)
+	expected := []int{3, 5, 6}
+	if len(skipped) != len(expected) {
+		t.Fatalf("InsertAllUnique skipped %v; expected %v", skipped, expected)
+	}
+	for i := range expected {
+		if skipped[i] != expected[i] {
+			t.Errorf("InsertAllUnique skipped %v; expected %v", skipped, expected)
+		}
+	}
+	if rt.Size() != len(things) {
+		t.Errorf("InsertAllUnique left size %d; expected %d", rt.Size(), len(things))
+	}
+	verify(t, rt.root)
+}
+
+func TestSizeHistogram(t *testing.T) {
+	rt := NewTree(2, 3)
+	for _, w := range []float64{1, 1, 1, 1, 10, 10, 100} {
+		rt.Insert(mustRect(Point{0, 0, 0}, [Dim]float64{w, 1, 1}))
+	}
+
+	hist := rt.SizeHistogram(2)
+	if len(hist) != 2 || hist[0] != 4 || hist[1] != 3 {
+		t.Errorf("SizeHistogram(2) = %v; expected [4 3]", hist)
+	}
+	hist = rt.SizeHistogram(4)
+	if len(hist) != 4 || hist[0] != 4 || hist[1] != 0 || hist[2] != 2 || hist[3] != 1 {
+		t.Errorf("SizeHistogram(4) = %v; expected [4 0 2 1]", hist)
+	}
+	if hist := rt.SizeHistogram(0); hist != nil {
+		t.Errorf("SizeHistogram(0) = %v; expected nil", hist)
+	}
+
+	empty := NewTree(2, 3)
+	if hist := empty.SizeHistogram(3); len(hist) != 3 || hist[0] != 0 {
+		t.Errorf("SizeHistogram on an empty tree = %v; expected [0 0 0]", hist)
+	}
+}
+
+func TestSearchIntersectCentered(t *testing.T) {
+	rt := NewTree(2, 3)
+	things := []*Rect{
+		mustRect(Point{0, 0}, [Dim]float64{2, 1}),
+		mustRect(Point{3, 1}, [Dim]float64{1, 2}),
+		mustRect(Point{1, 2}, [Dim]float64{2, 2}),
+		mustRect(Point{8, 6}, [Dim]float64{1, 1}),
+		mustRect(Point{10, 3}, [Dim]float64{1, 2}),
+		mustRect(Point{11, 7}, [Dim]float64{1, 1}),
+		mustRect(Point{2, 6}, [Dim]float64{1, 2}),
+		mustRect(Point{3, 6}, [Dim]float64{1, 2}),
+	}
+	for _, thing := range things {
+		rt.Insert(thing)
+	}
+
+	bb := mustRect(Point{2, 1.5, 0}, [Dim]float64{10, 5.5, 1})
+	expected := rt.SearchIntersect(bb)
+	q := rt.SearchIntersectCentered(Point{7, 4.25, 0.5}, Point{5, 2.75, 0.5})
+	if len(q) != len(expected) {
+		t.Fatalf("SearchIntersectCentered found %d objects; expected %d", len(q), len(expected))
+	}
+	for _, obj := range expected {
+		if indexOf(q, obj) < 0 {
+			t.Errorf("SearchIntersectCentered failed to find %v", obj)
+		}
+	}
+}
+
+func TestFarthestInRect(t *testing.T) {
+	rt := NewTree(2, 3)
+	things := []*Rect{
+		mustRect(Point{1, 1}, [Dim]float64{1, 1}),
+		mustRect(Point{1, 3}, [Dim]float64{1, 1}),
+		mustRect(Point{3, 2}, [Dim]float64{1, 1}),
+		mustRect(Point{-7, -7}, [Dim]float64{1, 1}),
+		mustRect(Point{7, 7}, [Dim]float64{1, 1}),
+		mustRect(Point{10, 2}, [Dim]float64{1, 1}),
+	}
+	for _, thing := range things {
+		rt.Insert(thing)
+	}
+
+	bb := mustRect(Point{0, 0}, [Dim]float64{5, 5})
+	if obj := rt.FarthestInRect(bb, Point{0, 0}); obj != things[2] {
+		t.Errorf("FarthestInRect(%v) = %v; expected %v", bb, obj, things[2])
+	}
+	if obj := rt.FarthestInRect(bb, Point{5, 1}); obj != things[1] {
+		t.Errorf("FarthestInRect(%v) = %v; expected %v", bb, obj, things[1])
+	}
+	all := mustRect(Point{-10, -10}, [Dim]float64{30, 30})
+	if obj := rt.FarthestInRect(all, Point{0, 0}); obj != things[5] {
+		t.Errorf("FarthestInRect(%v) = %v; expected %v", all, obj, things[5])
+	}
+	none := mustRect(Point{50, 50}, [Dim]float64{1, 1})
+	if obj := rt.FarthestInRect(none, Point{0, 0}); obj != nil {
+		t.Errorf("FarthestInRect(%v) = %v; expected nil", none, obj)
+	}
+}
+
+func TestAllOrderedByBounds(t *testing.T) {
+	things := []*Rect{
+		mustRect(Point{0, 0}, [Dim]float64{2, 1}),
+		mustRect(Point{0, 0}, [Dim]float64{1, 1}),
+		mustRect(Point{0, 1}, [Dim]float64{1, 1}),
+		mustRect(Point{1, -5}, [Dim]float64{1, 1}),
+		mustRect(Point{3, 1}, [Dim]float64{1, 2}),
+		mustRect(Point{8, 6}, [Dim]float64{1, 1}),
+		mustRect(Point{-2, 3}, [Dim]float64{1, 2}),
+	}
+	expected := []*Rect{things[6], things[1], things[0], things[2], things[3], things[4], things[5]}
+
+	for _, order := range [][]int{{0, 1, 2, 3, 4, 5, 6}, {6, 5, 4, 3, 2, 1, 0}, {3, 0, 5, 1, 6, 2, 4}} {
+		rt := NewTree(2, 3)
+		for _, i := range order {
+			rt.Insert(things[i])
+		}
+		var got []Spatial
+		for obj := range rt.AllOrderedByBounds() {
+			got = append(got, obj)
+		}
+		if len(got) != len(expected) {
+			t.Fatalf("AllOrderedByBounds yielded %d objects; expected %d", len(got), len(expected))
+		}
+		for i := range expected {
+			if got[i] != expected[i] {
+				t.Errorf("AllOrderedByBounds yielded %v at %d for insertion order %v; expected %v", got[i], i, order, expected[i])
+			}
+		}
+	}
+
+	rt := NewTree(2, 3)
+	for _, thing := range things {
+		rt.Insert(thing)
+	}
+	n := 0
+	for range rt.AllOrderedByBounds() {
+		n++
+		if n == 2 {
+			break
+		}
+	}
+	if n != 2 {
+		t.Errorf("AllOrderedByBounds failed to stop early")
+	}
+}
+
+func TestExtent(t *testing.T) {
+	rt := NewTree(2, 3)
+	if _, _, err := rt.Extent(); err != ErrEmptyTree {
+		t.Errorf("Extent() on an empty tree returned error %v; expected ErrEmptyTree", err)
+	}
+
+	rt.Insert(mustRect(Point{0, 0, 0}, [Dim]float64{2, 1, 1}))
+	rt.Insert(mustRect(Point{-3, 1, 5}, [Dim]float64{1, 2, 1}))
+	min, max, err := rt.Extent()
+	if err != nil {
+		t.Fatalf("Extent() returned error %v", err)
+	}
+	if min != (Point{-3, 0, 0}) || max != (Point{2, 3, 6}) {
+		t.Errorf("Extent() = %v, %v; expected [-3 0 0], [2 3 6]", min, max)
+	}
+
+	min[0] = 99
+	if bb := rt.Bounds(); bb.p[0] != -3 {
+		t.Errorf("modifying the result of Extent() changed the tree")
+	}
+}
+
+func TestNearestChain(t *testing.T) {
+	rt := NewTree(2, 3)
+	things := []*Rect{
+		mustRect(Point{0, 0}, [Dim]float64{1, 1}),
+		mustRect(Point{10, 0}, [Dim]float64{1, 1}),
+		mustRect(Point{2, 0}, [Dim]float64{1, 1}),
+		mustRect(Point{20, 0}, [Dim]float64{1, 1}),
+		mustRect(Point{4, 0}, [Dim]float64{1, 1}),
+		mustRect(Point{-3, 0}, [Dim]float64{1, 1}),
+	}
+	for _, thing := range things {
+		rt.Insert(thing)
+	}
+
+	chain := rt.NearestChain(Point{0.5, 0.5, 0.5}, 10)
+	expected := []*Rect{things[0], things[2], things[4], things[1], things[3], things[5]}
+	if len(chain) != len(expected) {
+		t.Fatalf("NearestChain returned %d objects; expected %d", len(chain), len(expected))
+	}
+	for i := range expected {
+		if chain[i] != expected[i] {
+			t.Errorf("NearestChain()[%d] = %v; expected %v", i, chain[i], expected[i])
+		}
+	}
+
+	if chain := rt.NearestChain(Point{0, 0, 0}, 2); len(chain) != 2 || chain[1] != things[2] {
+		t.Errorf("NearestChain with limit 2 = %v", chain)
+	}
+	if chain := NewTree(2, 3).NearestChain(Point{}, 3); len(chain) != 0 {
+		t.Errorf("NearestChain on an empty tree = %v; expected no objects", chain)
+	}
+
+	// objects that can't be compared with == are chained too
+	tags := NewTree(2, 3)
+	for i, thing := range things {
+		tags.Insert(tagged{thing, []string{fmt.Sprint(i)}})
+	}
+	chain = tags.NearestChain(Point{0.5, 0.5, 0.5}, 10)
+	if len(chain) != len(expected) {
+		t.Fatalf("NearestChain of tagged objects returned %d objects; expected %d", len(chain), len(expected))
+	}
+	for i := range expected {
+		if chain[i].Bounds() != expected[i] {
+			t.Errorf("NearestChain of tagged objects()[%d] = %v; expected %v", i, chain[i], expected[i])
+		}
+	}
+}
+
+func TestSearchIntersectTransformed(t *testing.T) {
+	rt := NewTree(2, 3)
+	things := []*Rect{
+		mustRect(Point{0, 0}, [Dim]float64{2, 1}),
+		mustRect(Point{3, 1}, [Dim]float64{1, 2}),
+		mustRect(Point{1, 2}, [Dim]float64{2, 2}),
+		mustRect(Point{8, 6}, [Dim]float64{1, 1}),
+		mustRect(Point{10, 3}, [Dim]float64{1, 2}),
+		mustRect(Point{11, 7}, [Dim]float64{1, 1}),
+		mustRect(Point{2, 6}, [Dim]float64{1, 2}),
+		mustRect(Point{3, 6}, [Dim]float64{1, 2}),
+	}
+	for _, thing := range things {
+		rt.Insert(thing)
+	}
+
+	// the query frame is the tree's frame translated by (-100, -100)
+	bb := mustRect(Point{2, 1.5}, [Dim]float64{10, 5.5})
+	local := mustRect(Point{-98, -98.5}, [Dim]float64{10, 5.5})
+	inverse := func(p Point) Point { return Point{p[0] - 100, p[1] - 100, p[2]} }
+
+	expected := rt.SearchIntersect(bb)
+	q := rt.SearchIntersectTransformed(local, inverse)
+	if len(q) != len(expected) {
+		t.Fatalf("SearchIntersectTransformed found %d objects; expected %d", len(q), len(expected))
+	}
+	for _, obj := range expected {
+		if indexOf(q, obj) < 0 {
+			t.Errorf("SearchIntersectTransformed failed to find %v", obj)
+		}
+	}
+}
+
+func TestSearchIntersectGrouped(t *testing.T) {
+	rt := NewTree(2, 3)
+	things := []*Rect{
+		mustRect(Point{0, 0}, [Dim]float64{2, 1}),
+		mustRect(Point{3, 1}, [Dim]float64{1, 2}),
+		mustRect(Point{1, 2}, [Dim]float64{2, 2}),
+		mustRect(Point{8, 6}, [Dim]float64{1, 1}),
+		mustRect(Point{10, 3}, [Dim]float64{1, 2}),
+		mustRect(Point{11, 7}, [Dim]float64{1, 1}),
+		mustRect(Point{2, 6}, [Dim]float64{1, 2}),
+		mustRect(Point{3, 6}, [Dim]float64{1, 2}),
+		mustRect(Point{2, 8}, [Dim]float64{1, 2}),
+		mustRect(Point{3, 8}, [Dim]float64{1, 2}),
+	}
+	for _, thing := range things {
+		rt.Insert(thing)
+	}
+
+	bb := mustRect(Point{2, 1.5}, [Dim]float64{10, 5.5})
+	expected := rt.SearchIntersect(bb)
+	groups := rt.SearchIntersectGrouped(bb)
+	found := 0
+	for _, group := range groups {
+		if len(group) == 0 {
+			t.Errorf("SearchIntersectGrouped returned an empty group")
+		}
+		leaf := rt.findLeaf(rt.root, group[0], defaultComparator)
+		for _, obj := range group {
+			if indexOf(expected, obj) < 0 {
+				t.Errorf("SearchIntersectGrouped returned non-matching object %v", obj)
+			}
+			if rt.findLeaf(rt.root, obj, defaultComparator) != leaf {
+				t.Errorf("SearchIntersectGrouped grouped objects from different leaves")
+			}
+			found++
+		}
+	}
+	if found != len(expected) {
+		t.Errorf("SearchIntersectGrouped found %d objects; expected %d", found, len(expected))
+	}
+}
+
+func TestWalkBFS(t *testing.T) {
+	rt := NewTree(2, 3)
+	for _, obj := range randomRects(40, 8) {
+		rt.Insert(obj)
+	}
+
+	var levels []int
+	leaves := 0
+	rt.WalkBFS(func(bb *Rect, level int, isLeaf bool) bool {
+		if len(levels) > 0 && level < levels[len(levels)-1] {
+			t.Errorf("WalkBFS visited level %d after level %d", level, levels[len(levels)-1])
+		}
+		if isLeaf != (level == rt.Depth()-1) {
+			t.Errorf("WalkBFS reported isLeaf=%v at level %d of a tree of depth %d", isLeaf, level, rt.Depth())
+		}
+		if isLeaf {
+			leaves++
+		}
+		levels = append(levels, level)
+		bb.p[0] = 1e9
+		return true
+	})
+	if levels[0] != 0 || leaves != len(leafSizes(rt.root)) {
+		t.Errorf("WalkBFS failed to visit every node")
+	}
+	if bb := rt.Bounds(); bb.p[0] == 1e9 {
+		t.Errorf("WalkBFS passed a bounding box that aliases the tree")
+	}
+
+	visited := 0
+	rt.WalkBFS(func(bb *Rect, level int, isLeaf bool) bool {
+		visited++
+		return level < 1
+	})
+	if visited != 1+len(rt.root.entries) {
+		t.Errorf("WalkBFS failed to prune below level 1; visited %d nodes", visited)
+	}
+
+	NewTree(2, 3).WalkBFS(func(bb *Rect, level int, isLeaf bool) bool {
+		t.Errorf("WalkBFS visited a node of an empty tree")
+		return true
+	})
+}
+
+// recordingVisitor records what Traverse visits, descending no deeper than
+// maxDepth.
+type recordingVisitor struct {
+	maxDepth int
+	nodes    []*Rect
+	depths   []int
+	objs     []Spatial
+	inNode   []bool // whether each object lies within the last node visited
+}
+
+func (v *recordingVisitor) VisitNode(bb *Rect, depth int) bool {
+	v.nodes = append(v.nodes, bb)
+	v.depths = append(v.depths, depth)
+	return depth < v.maxDepth
+}
+
+func (v *recordingVisitor) VisitLeaf(obj Spatial, bb *Rect) {
+	v.objs = append(v.objs, obj)
+	v.inNode = append(v.inNode, v.nodes[len(v.nodes)-1].containsRect(bb))
+	bb.p[0] = 1e9
+}
+
+func TestTraverse(t *testing.T) {
+	rt := NewTree(2, 3)
+	objs := randomRects(40, 8)
+	for _, obj := range objs {
+		rt.Insert(obj)
+	}
+
+	v := &recordingVisitor{maxDepth: rt.Depth()}
+	rt.Traverse(v)
+	nodes := 0
+	rt.root.walkNodes(func(n *node) { nodes++ })
+	if len(v.nodes) != nodes || len(v.objs) != len(objs) {
+		t.Fatalf("Traverse visited %d nodes and %d objects; expected %d and %d", len(v.nodes), len(v.objs), nodes, len(objs))
+	}
+	for _, obj := range objs {
+		if indexOf(v.objs, obj) < 0 {
+			t.Errorf("Traverse failed to visit %v", obj)
+		}
+	}
+	for i, ok := range v.inNode {
+		if !ok {
+			t.Errorf("Traverse visited %v outside the node before it", v.objs[i])
+		}
+	}
+	if v.depths[0] != 0 || *v.nodes[0] != *rt.Bounds() {
+		t.Errorf("Traverse started at depth %d with %v; expected the root", v.depths[0], v.nodes[0])
+	}
+	for i := 1; i < len(v.depths); i++ {
+		if v.depths[i] < 1 || v.depths[i] > v.depths[i-1]+1 {
+			t.Errorf("Traverse visited depth %d after depth %d", v.depths[i], v.depths[i-1])
+		}
+	}
+	for _, obj := range objs {
+		if obj.Bounds().p[0] == 1e9 {
+			t.Fatalf("Traverse passed a bounding box that aliases the tree")
+		}
+	}
+
+	v = &recordingVisitor{maxDepth: 1}
+	rt.Traverse(v)
+	if len(v.nodes) != 1+len(rt.root.entries) || len(v.objs) != 0 {
+		t.Errorf("Traverse failed to prune below depth 1; visited %d nodes and %d objects", len(v.nodes), len(v.objs))
+	}
+
+	v = &recordingVisitor{maxDepth: 1}
+	NewTree(2, 3).Traverse(v)
+	if len(v.nodes) != 0 {
+		t.Errorf("Traverse visited a node of an empty tree")
+	}
+}
+
+func TestBoundingBoxesAtDepth(t *testing.T) {
+	rt := NewTree(2, 3)
+	for _, obj := range randomRects(40, 9) {
+		rt.Insert(obj)
+	}
+
+	all := rt.GetAllBoundingBoxes()
+	withDepth := rt.GetAllBoundingBoxesWithDepth()
+	if len(withDepth) != len(all) {
+		t.Fatalf("GetAllBoundingBoxesWithDepth returned %d boxes; expected %d", len(withDepth), len(all))
+	}
+	counts := make([]int, rt.Depth())
+	for i, b := range withDepth {
+		if *b.Rect != *all[i] {
+			t.Errorf("GetAllBoundingBoxesWithDepth()[%d] = %v; expected %v", i, b.Rect, all[i])
+		}
+		counts[b.Depth]++
+	}
+
+	if boxes := rt.BoundingBoxesAtDepth(0); len(boxes) != 1 || *boxes[0] != *rt.Bounds() {
+		t.Errorf("BoundingBoxesAtDepth(0) = %v; expected the root's box %v", boxes, rt.Bounds())
+	}
+	total := 0
+	for depth := 1; depth < rt.Depth(); depth++ {
+		boxes := rt.BoundingBoxesAtDepth(depth)
+		if len(boxes) != counts[depth] {
+			t.Errorf("BoundingBoxesAtDepth(%d) returned %d boxes; expected %d", depth, len(boxes), counts[depth])
+		}
+		total += len(boxes)
+	}
+	if total != len(all) || len(rt.BoundingBoxesAtDepth(rt.Depth()-1)) != len(leafSizes(rt.root)) {
+		t.Errorf("BoundingBoxesAtDepth returned %d boxes below the root; expected %d", total, len(all))
+	}
+	if rt.BoundingBoxesAtDepth(-1) != nil || rt.BoundingBoxesAtDepth(rt.Depth()) != nil {
+		t.Errorf("BoundingBoxesAtDepth returned boxes outside the tree")
+	}
+	if NewTree(2, 3).BoundingBoxesAtDepth(0) != nil {
+		t.Errorf("BoundingBoxesAtDepth returned boxes of an empty tree")
+	}
+}
+
+func TestInsertOrMerge(t *testing.T) {
+	rt := NewTree(2, 3)
+	things := []*Rect{
+		mustRect(Point{0, 0}, [Dim]float64{2, 2}),
+		mustRect(Point{3, 0}, [Dim]float64{2, 2}),
+		mustRect(Point{10, 10}, [Dim]float64{1, 1}),
+	}
+	for _, thing := range things {
+		rt.Insert(thing)
+	}
+
+	union := func(existing []Spatial, obj Spatial) Spatial {
+		bb := obj.Bounds()
+		for _, e := range existing {
+			bb = boundingBox(bb, e.Bounds())
+		}
+		return bb
+	}
+
+	lone := mustRect(Point{20, 20}, [Dim]float64{1, 1})
+	if obj := rt.InsertOrMerge(lone, union); obj != lone {
+		t.Errorf("InsertOrMerge(%v) = %v; expected the object itself", lone, obj)
+	}
+	if rt.Size() != 4 {
+		t.Errorf("InsertOrMerge left size %d; expected 4", rt.Size())
+	}
+
+	bridge := mustRect(Point{1, 1}, [Dim]float64{3, 0.5})
+	merged := rt.InsertOrMerge(bridge, union)
+	expected := mustRect(Point{0, 0}, [Dim]float64{5, 2})
+	if !merged.Bounds().Equal(expected) {
+		t.Errorf("InsertOrMerge(%v) = %v; expected %v", bridge, merged, expected)
+	}
+	if rt.Size() != 3 {
+		t.Errorf("InsertOrMerge left size %d; expected 3", rt.Size())
+	}
+	if q := rt.SearchIntersect(expected); len(q) != 1 || q[0] != merged {
+		t.Errorf("InsertOrMerge failed to replace the merged objects; found %v", q)
+	}
+	verify(t, rt.root)
+}
+
+func TestInsertIdenticalBounds(t *testing.T) {
+	rt := NewTree(2, 5)
+	things := make([]*Rect, 50)
+	for i := range things {
+		things[i] = mustRect(Point{1, 1, 1}, [Dim]float64{1, 1, 1})
+		rt.Insert(things[i])
+	}
+	if rt.Depth() != 1 || len(rt.root.entries) != len(things) {
+		t.Errorf("identical objects were split into a tree of depth %d", rt.Depth())
+	}
+	if q := rt.SearchIntersect(mustRect(Point{0, 0, 0}, [Dim]float64{2, 2, 2})); len(q) != len(things) {
+		t.Errorf("SearchIntersect found %d of %d identical objects", len(q), len(things))
+	}
+
+	others := randomRects(30, 9)
+	for _, obj := range others {
+		rt.Insert(obj)
+	}
+	verify(t, rt.root)
+	if rt.Size() != len(things)+len(others) {
+		t.Errorf("tree has size %d; expected %d", rt.Size(), len(things)+len(others))
+	}
+	for _, thing := range things {
+		if !rt.Delete(thing) {
+			t.Fatalf("failed to delete an identical object")
+		}
+	}
+	for _, obj := range others {
+		if rt.findLeaf(rt.root, obj, defaultComparator) == nil {
+			t.Errorf("lost %v after deleting the identical objects", obj)
+		}
+	}
+	verify(t, rt.root)
+}
+
+func TestInsertManySharingOneRect(t *testing.T) {
+	// no split can separate objects with the same box, so their leaf must
+	// overflow rather than the split loop forever or give up
+	bb := mustRect(Point{1, 1, 1}, [Dim]float64{1, 1, 1})
+	for name, rt := range map[string]*Rtree{
+		"quadratic":     NewTree(3, 6),
+		"(1, 2)":        NewTree(1, 2),
+		"R*":            NewTree(3, 8, WithSplitStrategy(RStarSplit{}), WithReinsertPercentage(0.3)),
+		"least overlap": NewTree(3, 8, WithInsertHeuristic(LeastOverlap)),
+	} {
+		rt.Insert(mustRect(Point{50, 50, 50}, [Dim]float64{1, 1, 1}))
+		for i := 0; i < 1000; i++ {
+			rt.Insert(namedRect{bb, fmt.Sprint(i)})
+		}
+		rt.Insert(mustRect(Point{5, 5, 5}, [Dim]float64{1, 1, 1}))
+		if err := rt.Validate(); err != nil {
+			t.Errorf("%s tree invalid: %v", name, err)
+		}
+		if q := rt.SearchIntersect(bb); len(q) != 1000 {
+			t.Errorf("%s tree: SearchIntersect found %d of 1000 objects sharing a box", name, len(q))
+		}
+		for i := 0; i < 1000; i += 2 {
+			if !rt.Delete(namedRect{bb, fmt.Sprint(i)}) {
+				t.Fatalf("%s tree: failed to delete object %d", name, i)
+			}
+		}
+		if err := rt.Validate(); err != nil || rt.Size() != 502 {
+			t.Errorf("%s tree invalid after deletions, with size %d: %v", name, rt.Size(), err)
+		}
+
+		// distinct boxes within the shared one land in the overflowing
+		// bucket
+		for i := 0; i < 10; i++ {
+			rt.Insert(mustRect(Point{1.1 + 0.05*float64(i), 1.1, 1.1}, [Dim]float64{0.1, 0.1, 0.1}))
+			if err := rt.Validate(); err != nil {
+				t.Fatalf("%s tree invalid after inserting distinct box %d: %v", name, i, err)
+			}
+		}
+		if !rt.Update(namedRect{bb, "1"}, mustRect(Point{1.5, 1.5, 1.5}, [Dim]float64{0.2, 0.2, 0.2})) {
+			t.Errorf("%s tree: failed to update a bucket member", name)
+		}
+		if err := rt.Validate(); err != nil || rt.Size() != 512 {
+			t.Errorf("%s tree invalid after updating a bucket member, with size %d: %v", name, rt.Size(), err)
+		}
+		if q := rt.SearchIntersect(bb); len(q) != 510 {
+			t.Errorf("%s tree: SearchIntersect found %d of 510 objects within the shared box", name, len(q))
+		}
+	}
+}
+
+func TestBucketSplit(t *testing.T) {
+	bb := mustRect(Point{1, 1, 1}, [Dim]float64{1, 1, 1})
+	for _, n := range []int{3, 5, 20, 100} {
+		for name, rt := range map[string]*Rtree{
+			"(1, 2)": NewTree(1, 2),
+			"(2, 4)": NewTree(2, 4),
+			"R*":     NewTree(2, 4, WithSplitStrategy(RStarSplit{}), WithReinsertPercentage(0.3)),
+		} {
+			for i := range n {
+				rt.Insert(namedRect{bb, fmt.Sprint(i)})
+			}
+			// distinct boxes landing in the overflowing bucket
+			for i := range 5 {
+				rt.Insert(mustRect(Point{1.1 + 0.1*float64(i), 1.1, 1.1}, [Dim]float64{0.1, 0.1, 0.1}))
+				if err := rt.Validate(); err != nil {
+					t.Fatalf("%s tree of %d identical boxes invalid after %d more: %v", name, n, i+1, err)
+				}
+			}
+			if q := rt.SearchIntersect(bb); len(q) != n+5 || rt.Size() != n+5 {
+				t.Errorf("%s tree of %d identical boxes: SearchIntersect found %d objects and size is %d", name, n, len(q), rt.Size())
+			}
+		}
+	}
+}
+
+func TestRebuildRegion(t *testing.T) {
+	objs := randomRects(400, 8)
+	rt := NewTree(3, 6)
+	for _, obj := range objs {
+		rt.Insert(obj)
+	}
+	bb := mustRect(Point{10, 10, 10}, [Dim]float64{50, 50, 50})
+	expected := rt.SearchIntersect(bb)
+
+	rt.RebuildRegion(bb)
+	verify(t, rt.root)
+	if rt.Size() != len(objs) {
+		t.Errorf("RebuildRegion left size %d; expected %d", rt.Size(), len(objs))
+	}
+	for i, obj := range objs {
+		if rt.findLeaf(rt.root, obj, defaultComparator) == nil {
+			t.Errorf("RebuildRegion lost object %d", i)
+		}
+	}
+	q := rt.SearchIntersect(bb)
+	if len(q) != len(expected) {
+		t.Errorf("SearchIntersect after RebuildRegion found %d objects; expected %d", len(q), len(expected))
+	}
+	for _, obj := range expected {
+		if indexOf(q, obj) < 0 {
+			t.Errorf("SearchIntersect after RebuildRegion failed to find %v", obj)
+		}
+	}
+
+	// a moved object is rebuilt with the box it is stored with
+	m := &movable{bb: mustRect(Point{20, 20, 20}, [Dim]float64{1, 1, 1})}
+	rt.Insert(m)
+	m.bb = mustRect(Point{90, 90, 90}, [Dim]float64{1, 1, 1})
+	rt.RebuildRegion(bb)
+	verify(t, rt.root)
+	if rt.Size() != len(objs)+1 {
+		t.Errorf("RebuildRegion with a moved object left size %d; expected %d", rt.Size(), len(objs)+1)
+	}
+	if q := rt.SearchIntersect(mustRect(Point{20, 20, 20}, [Dim]float64{1, 1, 1})); indexOf(q, m) < 0 {
+		t.Errorf("RebuildRegion didn't keep the moved object at its stored box")
+	}
+	if n := rt.UpdateAll([]UpdateOp{{m, mustRect(Point{20, 20, 20}, [Dim]float64{1, 1, 1})}}); n != 1 {
+		t.Errorf("UpdateAll moved %d objects after RebuildRegion; expected 1", n)
+	}
+	verify(t, rt.root)
+
+	small := NewTree(2, 3)
+	small.Insert(mustRect(Point{1, 1}, [Dim]float64{1, 1}))
+	small.RebuildRegion(mustRect(Point{0, 0}, [Dim]float64{5, 5}))
+	if small.Size() != 1 || len(small.root.entries) != 1 {
+		t.Errorf("RebuildRegion of a single object left size %d", small.Size())
+	}
+}
+
+func TestPairsWithin(t *testing.T) {
+	objs := randomRects(300, 11)
+	rt := NewTree(3, 6)
+	for _, obj := range objs {
+		rt.Insert(obj)
+	}
+
+	const d = 3.0
+	type pair struct{ a, b Spatial }
+	expected := map[pair]float64{}
+	for i, a := range objs {
+		for _, b := range objs[i+1:] {
+			if dist := math.Sqrt(rectDist(a.Bounds(), b.Bounds())); dist <= d {
+				expected[pair{a, b}] = dist
+			}
+		}
+	}
+
+	seen := map[pair]bool{}
+	rt.PairsWithin(d, func(a, b Spatial, dist float64) {
+		p := pair{a, b}
+		if indexOf(objs, a) > indexOf(objs, b) {
+			p = pair{b, a}
+		}
+		if seen[p] {
+			t.Errorf("PairsWithin reported %v twice", p)
+		}
+		seen[p] = true
+		if want, ok := expected[p]; !ok {
+			t.Errorf("PairsWithin reported %v at distance %v", p, dist)
+		} else if dist != want {
+			t.Errorf("PairsWithin reported distance %v for %v; expected %v", dist, p, want)
+		}
+	})
+	if len(seen) != len(expected) {
+		t.Errorf("PairsWithin reported %d pairs; expected %d", len(seen), len(expected))
+	}
+}
+
+func TestDeleteCollapsesRoot(t *testing.T) {
+	for _, tc := range []struct{ min, max int }{{1, 3}, {2, 4}, {3, 6}} {
+		objs := randomRects(200, 12)
+		rt := NewTree(tc.min, tc.max)
+		for _, obj := range objs {
+			rt.Insert(obj)
+		}
+		depth := rt.Depth()
+		if depth < 3 {
+			t.Fatalf("tree of %d objects has depth %d; expected at least 3", len(objs), depth)
+		}
+
+		for i, obj := range objs {
+			if !rt.Delete(obj) {
+				t.Fatalf("failed to delete object %d", i)
+			}
+			if !rt.root.leaf && len(rt.root.entries) < 2 {
+				t.Fatalf("Delete left a root with %d entries", len(rt.root.entries))
+			}
+			if rt.root.parent != nil {
+				t.Fatalf("Delete left a root with a parent")
+			}
+			if rt.Depth() != rt.root.level {
+				t.Fatalf("Delete left depth %d with a root at level %d", rt.Depth(), rt.root.level)
+			}
+		}
+		if rt.Depth() != 1 {
+			t.Errorf("emptied tree of depth %d has depth %d; expected 1", depth, rt.Depth())
+		}
+	}
+}
+
+func TestSearchIntersectByOverlap(t *testing.T) {
+	rt := NewTree(2, 3)
+	things := []*Rect{
+		mustRect(Point{0, 0}, [Dim]float64{1.5, 1.5}),
+		mustRect(Point{-5, -5}, [Dim]float64{20, 20}),
+		mustRect(Point{2, 2}, [Dim]float64{4, 4}),
+		mustRect(Point{7, 7}, [Dim]float64{5, 5}),
+		mustRect(Point{20, 20}, [Dim]float64{1, 1}),
+		mustRect(Point{4, 0}, [Dim]float64{2, 1}),
+	}
+	for _, thing := range things {
+		rt.Insert(thing)
+	}
+
+	bb := mustRect(Point{0, 0}, [Dim]float64{8, 8})
+	objs := rt.SearchIntersectByOverlap(bb)
+	expected := []Spatial{things[1], things[2], things[0], things[5], things[3]}
+	if len(objs) != len(expected) {
+		t.Fatalf("SearchIntersectByOverlap returned %v; expected %v", objs, expected)
+	}
+	for i := range expected {
+		if objs[i] != expected[i] {
+			t.Errorf("SearchIntersectByOverlap()[%d] = %v; expected %v", i, objs[i], expected[i])
+		}
+	}
+}
+
+// countingRect is a rectangle that counts the calls to its Bounds method.
+type countingRect struct {
+	bb    *Rect
+	calls *int
+}
+
+func (r countingRect) Bounds() *Rect {
+	*r.calls++
+	return r.bb
+}
+
+func TestSearchIntersectBounds(t *testing.T) {
+	rt := NewTree(3, 6)
+	calls := 0
+	for _, obj := range randomRects(300, 52) {
+		rt.Insert(countingRect{obj.Bounds(), &calls})
+	}
+	bb := mustRect(Point{10, 10, 10}, [Dim]float64{50, 50, 50})
+	expected := rt.SearchIntersect(bb)
+
+	calls = 0
+	found, mbr := rt.SearchIntersectBounds(bb)
+	if calls != 0 {
+		t.Errorf("SearchIntersectBounds called Bounds %d times", calls)
+	}
+	if len(found) != len(expected) {
+		t.Fatalf("SearchIntersectBounds found %d objects; expected %d", len(found), len(expected))
+	}
+	var want *Rect
+	for i, obj := range found {
+		if obj != expected[i] {
+			t.Errorf("SearchIntersectBounds()[%d] = %v; expected %v", i, obj, expected[i])
+		}
+		if want == nil {
+			want = obj.(countingRect).bb
+		} else {
+			want = boundingBox(want, obj.(countingRect).bb)
+		}
+	}
+	if !mbr.Equal(want) {
+		t.Errorf("SearchIntersectBounds returned bounds %v; expected %v", mbr, want)
+	}
+
+	found, mbr = rt.SearchIntersectBounds(mustRect(Point{-10, -10, -10}, [Dim]float64{1, 1, 1}))
+	if found == nil || len(found) != 0 || mbr != nil {
+		t.Errorf("SearchIntersectBounds away from every object returned %v, %v", found, mbr)
+	}
+}
+
+func TestSearchIntersectSorted(t *testing.T) {
+	objs := randomRects(400, 53)
+	rt := NewTree(3, 6)
+	for _, obj := range objs {
+		rt.Insert(obj)
+	}
+	bb := mustRect(Point{10, 10, 10}, [Dim]float64{50, 50, 50})
+	focus := Point{0, 80, 30}
+	expected := rt.SearchIntersect(bb)
+
+	found := rt.SearchIntersectSorted(bb, focus)
+	if len(found) != len(expected) {
+		t.Fatalf("SearchIntersectSorted found %d objects; expected %d", len(found), len(expected))
+	}
+	for _, obj := range expected {
+		if indexOf(found, obj) < 0 {
+			t.Errorf("SearchIntersectSorted missed %v", obj)
+		}
+	}
+	for i := 1; i < len(found); i++ {
+		if d0, d1 := focus.minDist(found[i-1].Bounds()), focus.minDist(found[i].Bounds()); d0 > d1 {
+			t.Errorf("SearchIntersectSorted returned an object at %v after one at %v", math.Sqrt(d1), math.Sqrt(d0))
+		}
+	}
+
+	if got := NewTree(3, 6).SearchIntersectSorted(bb, focus); got == nil || len(got) != 0 {
+		t.Errorf("SearchIntersectSorted of an empty tree = %v; expected an empty slice", got)
+	}
+}
+
+func TestSearchIntersectBoxes(t *testing.T) {
+	rt := NewTree(3, 6)
+	calls := 0
+	for _, obj := range randomRects(300, 50) {
+		rt.Insert(countingRect{obj.Bounds(), &calls})
+	}
+	bb := mustRect(Point{10, 10, 10}, [Dim]float64{50, 50, 50})
+	expected := rt.SearchIntersect(bb)
+
+	calls = 0
+	results := rt.SearchIntersectBoxes(bb)
+	if calls != 0 {
+		t.Errorf("SearchIntersectBoxes called Bounds %d times", calls)
+	}
+	if len(results) != len(expected) {
+		t.Fatalf("SearchIntersectBoxes found %d objects; expected %d", len(results), len(expected))
+	}
+	for i, r := range results {
+		obj := r.Object.(countingRect)
+		if r.Object != expected[i] || !r.Box.Equal(obj.bb) || r.Box == obj.bb {
+			t.Errorf("SearchIntersectBoxes()[%d] = %v with box %v; expected %v with a copy of its box", i, r.Object, r.Box, expected[i])
+		}
+	}
+
+	// the boxes are the stored ones, even once an object has moved
+	moved := results[0].Object.(countingRect)
+	newBounds := mustRect(Point{30, 30, 30}, [Dim]float64{1, 1, 1})
+	rt.Update(moved, newBounds)
+	for _, r := range rt.SearchIntersectBoxes(bb) {
+		if r.Object == Spatial(moved) && !r.Box.Equal(newBounds) {
+			t.Errorf("SearchIntersectBoxes returned %v for a moved object; expected %v", r.Box, newBounds)
+		}
+	}
+	stored := *results[1].Box
+	*results[1].Box = *newBounds.Expand(100)
+	found := false
+	for _, r := range rt.SearchIntersectBoxes(&stored) {
+		if r.Object == results[1].Object {
+			found = r.Box.Equal(&stored)
+		}
+	}
+	if !found {
+		t.Errorf("modifying a returned box changed the tree")
+	}
+	if got := NewTree(3, 6).SearchIntersectBoxes(bb); got == nil || len(got) != 0 {
+		t.Errorf("SearchIntersectBoxes of an empty tree = %v; expected an empty slice", got)
+	}
+}
+
+func TestSearchIntersectRanked(t *testing.T) {
+	rt := NewTree(2, 3)
+	things := []*Rect{
+		mustRect(Point{0, 0}, [Dim]float64{1.5, 1.5}),
+		mustRect(Point{-5, -5}, [Dim]float64{20, 20}),
+		mustRect(Point{2, 2}, [Dim]float64{4, 4}),
+		mustRect(Point{7, 7}, [Dim]float64{5, 5}),
+		mustRect(Point{20, 20}, [Dim]float64{1, 1}),
+		mustRect(Point{4, 0}, [Dim]float64{2, 1}),
+	}
+	for _, thing := range things {
+		rt.Insert(thing)
+	}
+
+	bb := mustRect(Point{0, 0}, [Dim]float64{8, 8})
+	results := rt.SearchIntersectRanked(bb)
+	expected := []RankedResult{{things[1], 64}, {things[2], 16}, {things[0], 2.25}, {things[5], 2}, {things[3], 1}}
+	if len(results) != len(expected) {
+		t.Fatalf("SearchIntersectRanked returned %v; expected %v", results, expected)
+	}
+	for i := range expected {
+		if results[i] != expected[i] {
+			t.Errorf("SearchIntersectRanked()[%d] = %v; expected %v", i, results[i], expected[i])
+		}
+	}
+}
+
+func TestSearchRay(t *testing.T) {
+	rt := NewTree(3, 6)
+	objs := randomRects(5000, 42)
+	for _, obj := range objs {
+		rt.Insert(obj)
+	}
+	origin, dir := Point{-10, 20, 30}, Point{3, 1, 0.5}
+
+	hits := rt.SearchRay(origin, dir)
+	expected := 0
+	for _, obj := range objs {
+		if _, ok := obj.Bounds().rayEntry(origin, dir); ok {
+			expected++
+			if indexOf(hits, obj) < 0 {
+				t.Errorf("SearchRay missed %v", obj)
+			}
+		}
+	}
+	if len(hits) != expected || expected == 0 {
+		t.Fatalf("SearchRay returned %d objects; expected %d", len(hits), expected)
+	}
+	last := 0.0
+	for _, obj := range hits {
+		entry, _ := obj.Bounds().rayEntry(origin, dir)
+		if entry < last {
+			t.Errorf("SearchRay returned %v, entered at %v, after an object entered at %v", obj, entry, last)
+		}
+		last = entry
+	}
+
+	if hits := rt.SearchRay(origin, Point{-1, 0, 0}); len(hits) != 0 {
+		t.Errorf("SearchRay pointing away from the tree returned %v", hits)
+	}
+}
+
+func TestPrefetch(t *testing.T) {
+	objs := randomRects(200, 13)
+	rt := NewTree(3, 6)
+	if touched := rt.Prefetch(mustRect(Point{0, 0, 0}, [Dim]float64{10, 10, 10})); touched != 0 {
+		t.Errorf("Prefetch of an empty tree read %d entries", touched)
+	}
+	for _, obj := range objs {
+		rt.Insert(obj)
+	}
+	bb := mustRect(Point{10, 20, 30}, [Dim]float64{30, 30, 30})
+	expected := rt.SearchIntersect(bb)
+
+	if touched := rt.Prefetch(bb); touched < len(expected) || touched >= rt.Size() {
+		t.Errorf("Prefetch read %d entries; expected at least %d and fewer than %d", touched, len(expected), rt.Size())
+	}
+	verify(t, rt.root)
+	q := rt.SearchIntersect(bb)
+	if len(q) != len(expected) {
+		t.Errorf("SearchIntersect after Prefetch found %d objects; expected %d", len(q), len(expected))
+	}
+	for i := range expected {
+		if q[i] != expected[i] {
+			t.Errorf("SearchIntersect after Prefetch changed result %d", i)
+		}
+	}
+}
+
+func TestContains(t *testing.T) {
+	objs := randomRects(100, 16)
+	rt := NewTree(3, 6)
+	for _, obj := range objs[:50] {
+		rt.Insert(obj)
+	}
+	for i, obj := range objs {
+		if got := rt.Contains(obj, nil); got != (i < 50) {
+			t.Errorf("Contains(object %d) = %v", i, got)
+		}
+	}
+
+	sameBounds := func(obj1, obj2 Spatial) bool {
+		return obj1.Bounds().Equal(obj2.Bounds())
+	}
+	copied := *objs[10].(*Rect)
+	if rt.Contains(&copied, nil) {
+		t.Errorf("Contains with the default comparator matched a copy")
+	}
+	if !rt.Contains(&copied, sameBounds) {
+		t.Errorf("Contains with a custom comparator failed to match a copy")
+	}
+	rt.Delete(objs[10])
+	if rt.Contains(&copied, sameBounds) {
+		t.Errorf("Contains matched a deleted object")
+	}
+}
+
+func TestNaNBounds(t *testing.T) {
+	nan := math.NaN()
+	objs := randomRects(100, 18)
+	bad := []*Rect{
+		{Point{nan, 10, 10}, Point{20, 20, 20}},
+		{Point{nan, nan, nan}, Point{nan, nan, nan}},
+		{Point{30, 30, 30}, Point{40, nan, 40}},
+	}
+	rt := NewTree(3, 6)
+	for i, obj := range objs {
+		rt.Insert(obj)
+		if i%30 == 10 {
+			rt.Insert(bad[i/30])
+		}
+	}
+	verify(t, rt.root)
+
+	bb := mustRect(Point{-10, -10, -10}, [Dim]float64{200, 200, 200})
+	if q := rt.SearchIntersect(bb); len(q) != len(objs) {
+		t.Errorf("SearchIntersect found %d objects; expected the %d valid ones", len(q), len(objs))
+	}
+	if q := rt.SearchIntersect(&Rect{Point{nan, 0, 0}, Point{100, 100, 100}}); len(q) != 0 {
+		t.Errorf("SearchIntersect with a NaN rect found %v", q)
+	}
+	for _, p := range []Point{{0, 0, 0}, {50, 50, 50}, {35, 35, 35}} {
+		for _, obj := range rt.NearestNeighbors(len(objs)+len(bad), p) {
+			if obj != nil && indexOf(objs, obj) < 0 {
+				t.Errorf("NearestNeighbors(%v) returned %v", p, obj)
+			}
+		}
+	}
+	if obj := rt.NearestNeighbor(Point{nan, 0, 0}); obj != nil {
+		t.Errorf("NearestNeighbor of a NaN point = %v; expected nil", obj)
+	}
+
+	for _, obj := range bad {
+		if !rt.Delete(obj) {
+			t.Errorf("failed to delete %v", obj)
+		}
+	}
+	verify(t, rt.root)
+	if rt.Size() != len(objs) {
+		t.Errorf("tree has size %d; expected %d", rt.Size(), len(objs))
+	}
+}
+
+func TestLastInsertSplit(t *testing.T) {
+	rt := NewTree(2, 3)
+	if split, level := rt.LastInsertSplit(); split || level != 0 {
+		t.Errorf("LastInsertSplit on a new tree = %v, %d", split, level)
+	}
+	seenRootSplit, seenLeafSplit := false, false
+	for i, obj := range randomRects(100, 19) {
+		depth := rt.Depth()
+		leaves := len(leafSizes(rt.root))
+		rt.Insert(obj)
+		split, level := rt.LastInsertSplit()
+		if grew := len(leafSizes(rt.root)) > leaves; split != grew {
+			t.Fatalf("insert %d: LastInsertSplit = %v, but the number of leaves grew = %v", i, split, grew)
+		}
+		if !split {
+			if level != 0 {
+				t.Errorf("insert %d: LastInsertSplit reported level %d without a split", i, level)
+			}
+			continue
+		}
+		if rt.Depth() > depth {
+			seenRootSplit = true
+			if level != depth {
+				t.Errorf("insert %d: root split reported at level %d; expected %d", i, level, depth)
+			}
+		} else if level < 1 || level >= rt.Depth() {
+			t.Errorf("insert %d: split reported at level %d in a tree of depth %d", i, level, rt.Depth())
+		}
+		if level == 1 {
+			seenLeafSplit = true
+		}
+	}
+	if !seenRootSplit || !seenLeafSplit {
+		t.Errorf("expected both root and leaf-only splits; got %v, %v", seenRootSplit, seenLeafSplit)
+	}
+}
+
+func TestLargestInRect(t *testing.T) {
+	objs := randomRects(300, 21)
+	rt := NewTree(3, 6)
+	for _, obj := range objs {
+		rt.Insert(obj)
+	}
+	bb := mustRect(Point{10, 10, 10}, [Dim]float64{50, 50, 50})
+	matches := rt.SearchIntersect(bb)
+	sort.Slice(matches, func(i, j int) bool {
+		return matches[i].Bounds().size() > matches[j].Bounds().size()
+	})
+
+	for _, n := range []int{1, 5, 20, len(matches) + 10} {
+		largest := rt.LargestInRect(bb, n)
+		expected := matches
+		if n < len(expected) {
+			expected = expected[:n]
+		}
+		if len(largest) != len(expected) {
+			t.Fatalf("LargestInRect(%d) returned %d objects; expected %d", n, len(largest), len(expected))
+		}
+		for i := range expected {
+			if largest[i].Bounds().size() != expected[i].Bounds().size() {
+				t.Errorf("LargestInRect(%d)[%d] has size %v; expected %v", n, i, largest[i].Bounds().size(), expected[i].Bounds().size())
+			}
+		}
+	}
+	if objs := rt.LargestInRect(bb, 0); len(objs) != 0 {
+		t.Errorf("LargestInRect(0) = %v; expected no objects", objs)
+	}
+}
+
+func TestFloodSelect(t *testing.T) {
+	rt := NewTree(2, 3)
+	things := []*Rect{
+		mustRect(Point{0, 0}, [Dim]float64{2, 2}),
+		mustRect(Point{1, 1}, [Dim]float64{2, 2}),
+		mustRect(Point{2.5, 2.5}, [Dim]float64{2, 1}),
+		mustRect(Point{4.5, 2.5}, [Dim]float64{1, 1}),
+		mustRect(Point{10, 10}, [Dim]float64{1, 1}),
+		mustRect(Point{10.5, 10.5}, [Dim]float64{1, 1}),
+		mustRect(Point{-1, 3}, [Dim]float64{1, 1}),
+	}
+	for _, thing := range things {
+		rt.Insert(thing)
+	}
+
+	tests := []struct {
+		seed            Spatial
+		includeBoundary bool
+		expected        []int
+	}{
+		{things[0], false, []int{0, 1, 2}},
+		{things[0], true, []int{0, 1, 2, 3}},
+		{things[5], false, []int{4, 5}},
+		{mustRect(Point{-1.5, 2.5}, [Dim]float64{1, 1}), false, []int{6}},
+		{mustRect(Point{3.5, 3.2}, [Dim]float64{0.1, 0.1}), false, []int{2, 1, 0}},
+		{mustRect(Point{50, 50}, [Dim]float64{1, 1}), true, []int{}},
+	}
+	for _, test := range tests {
+		objs := rt.FloodSelect(test.seed, test.includeBoundary)
+		if len(objs) != len(test.expected) {
+			t.Errorf("FloodSelect(%v, %v) = %v; expected %d objects", test.seed, test.includeBoundary, objs, len(test.expected))
+			continue
+		}
+		for _, i := range test.expected {
+			if indexOf(objs, things[i]) < 0 {
+				t.Errorf("FloodSelect(%v, %v) failed to find %v", test.seed, test.includeBoundary, things[i])
+			}
+		}
+	}
+
+	// the first ring comes first
+	objs := rt.FloodSelect(mustRect(Point{3.5, 3.2}, [Dim]float64{0.1, 0.1}), false)
+	if len(objs) == 3 && (objs[0] != things[2] || objs[1] != things[1] || objs[2] != things[0]) {
+		t.Errorf("FloodSelect returned %v out of breadth-first order", objs)
+	}
+}
+
+type namedRect struct {
+	*Rect
+	name string
+}
+
+func (r namedRect) ID() string { return r.name }
+
+func TestAllOrderedByBoundsIdentifiable(t *testing.T) {
+	bb := mustRect(Point{1, 1, 1}, [Dim]float64{1, 1, 1})
+	names := []string{"d", "b", "e", "a", "c", "f", "g"}
+	for _, order := range [][]int{{0, 1, 2, 3, 4, 5, 6}, {6, 5, 4, 3, 2, 1, 0}, {3, 0, 6, 1, 5, 2, 4}} {
+		rt := NewTree(2, 3)
+		rt.Insert(mustRect(Point{0, 0, 0}, [Dim]float64{1, 1, 1}))
+		for _, i := range order {
+			rt.Insert(namedRect{bb, names[i]})
+		}
+		var got []string
+		for obj := range rt.AllOrderedByBounds() {
+			if named, ok := obj.(namedRect); ok {
+				got = append(got, named.name)
+			} else if len(got) > 0 {
+				t.Errorf("AllOrderedByBounds returned the unnamed object after a named one")
+			}
+		}
+		if strings.Join(got, "") != "abcdefg" {
+			t.Errorf("AllOrderedByBounds returned IDs in order %v", got)
+		}
+	}
+
+	// objects without IDs sharing the box come before those with IDs
+	plain := []*Rect{bb, mustRect(Point{1, 1, 1}, [Dim]float64{1, 1, 1})}
+	for _, order := range [][]int{{0, 1, 2, 3}, {3, 2, 1, 0}, {2, 0, 3, 1}} {
+		rt := NewTree(2, 3)
+		for _, i := range order {
+			if i < len(plain) {
+				rt.Insert(plain[i])
+			} else {
+				rt.Insert(namedRect{bb, names[i]})
+			}
+		}
+		var got []string
+		for obj := range rt.AllOrderedByBounds() {
+			if named, ok := obj.(namedRect); ok {
+				got = append(got, named.name)
+			} else {
+				got = append(got, "-")
+			}
+		}
+		if strings.Join(got, "") != "--ae" {
+			t.Errorf("AllOrderedByBounds returned mixed objects in order %v", got)
+		}
+	}
+}
+
+func TestNearestInCone(t *testing.T) {
+	objs := randomRects(400, 25)
+	rt := NewTree(3, 6)
+	for _, obj := range objs {
+		rt.Insert(obj)
+	}
+	tests := []struct {
+		apex, dir Point
+		halfAngle float64
+	}{
+		{Point{50, 50, 50}, Point{1, 0, 0}, 0.2},
+		{Point{50, 50, 50}, Point{-1, 1, 0}, 0.1},
+		{Point{0, 0, 0}, Point{1, 1, 1}, 0.05},
+		{Point{-50, 50, 50}, Point{-1, 0, 0}, 0.3},
+	}
+	for _, test := range tests {
+		var expected Spatial
+		best := math.Inf(1)
+		for _, obj := range objs {
+			if d := test.apex.minDist(obj.Bounds()); d < best && obj.Bounds().inCone(test.apex, test.dir, test.halfAngle) {
+				best, expected = d, obj
+			}
+		}
+		if expected == nil && test.apex[0] >= 0 {
+			t.Fatalf("no object in the cone at %v along %v", test.apex, test.dir)
+		}
+		if obj := rt.NearestInCone(test.apex, test.dir, test.halfAngle); obj != expected {
+			t.Errorf("NearestInCone(%v, %v, %v) = %v; expected %v", test.apex, test.dir, test.halfAngle, obj, expected)
+		}
+	}
+	if obj := rt.NearestInCone(Point{-50, 50, 50}, Point{-1, 0, 0}, 0.3); obj != nil {
+		t.Errorf("NearestInCone pointing away from every object = %v", obj)
+	}
+
+	// a box outside the cone but within its circumscribing sphere's reach
+	// doesn't hide a farther box inside the cone
+	rt = NewTree(3, 6)
+	outside := mustRect(Point{10, 5, -10}, [Dim]float64{1, 0.1, 20})
+	inside := mustRect(Point{15, 0, 0}, [Dim]float64{1, 1, 1})
+	rt.Insert(outside)
+	rt.Insert(inside)
+	if obj := rt.NearestInCone(Point{0, 0, 0}, Point{1, 0, 0}, math.Pi/8); obj != inside {
+		t.Errorf("NearestInCone = %v; expected %v", obj, inside)
+	}
+}
+
+func TestSearchIntersectWithLimit(t *testing.T) {
+	rt := NewTree(3, 6)
+	for _, obj := range randomRects(500, 44) {
+		rt.Insert(obj)
+	}
+	bb := mustRect(Point{10, 10, 10}, [Dim]float64{60, 60, 60})
+	all := rt.SearchIntersect(bb)
+
+	for _, k := range []int{-1, 0, 1, 10, len(all), len(all) + 5} {
+		got := rt.SearchIntersectWithLimit(k, bb)
+		if expected := max(min(k, len(all)), 0); len(got) != expected {
+			t.Errorf("SearchIntersectWithLimit(%d) returned %d objects; expected %d", k, len(got), expected)
+		}
+		seen := map[Spatial]bool{}
+		for _, obj := range got {
+			if indexOf(all, obj) < 0 || seen[obj] {
+				t.Errorf("SearchIntersectWithLimit(%d) returned %v, which isn't a distinct match", k, obj)
+			}
+			seen[obj] = true
+		}
+	}
+}
+
+func TestSearchIntersectParallel(t *testing.T) {
+	rt := NewTree(3, 6)
+	for _, obj := range randomRects(2000, 52) {
+		rt.Insert(obj)
+	}
+	for _, bb := range []*Rect{
+		mustRect(Point{10, 10, 10}, [Dim]float64{60, 60, 60}),
+		mustRect(Point{-10, -10, -10}, [Dim]float64{200, 200, 200}),
+		mustRect(Point{200, 200, 200}, [Dim]float64{1, 1, 1}),
+	} {
+		expected := rt.SearchIntersect(bb)
+		for _, workers := range []int{-1, 1, 2, 4, 100} {
+			got := rt.SearchIntersectParallel(bb, workers)
+			if len(got) != len(expected) {
+				t.Fatalf("SearchIntersectParallel(%v, %d) found %d objects; expected %d", bb, workers, len(got), len(expected))
+			}
+			for i := range got {
+				if got[i] != expected[i] {
+					t.Errorf("SearchIntersectParallel(%v, %d)[%d] = %v; expected %v", bb, workers, i, got[i], expected[i])
+				}
+			}
+		}
+	}
+
+	// a tree whose root is a leaf
+	small := NewTree(3, 6)
+	obj := mustRect(Point{1, 1, 1}, [Dim]float64{1, 1, 1})
+	small.Insert(obj)
+	if got := small.SearchIntersectParallel(obj, 4); len(got) != 1 || got[0] != obj {
+		t.Errorf("SearchIntersectParallel on a single leaf = %v; expected [%v]", got, obj)
+	}
+}
+
+func TestCountIntersect(t *testing.T) {
+	rt := NewTree(3, 6)
+	for _, obj := range randomRects(1000, 43) {
+		rt.Insert(obj)
+	}
+	// flat objects lying on the faces of the queries below
+	for i := 0; i < 20; i++ {
+		rt.Insert(mustRect(Point{20, float64(i), float64(i)}, [Dim]float64{0, 5, 5}))
+	}
+
+	for _, bb := range []*Rect{
+		mustRect(Point{0, 0, 0}, [Dim]float64{20, 30, 30}),
+		mustRect(Point{20, 20, 20}, [Dim]float64{50, 50, 50}),
+		mustRect(Point{-10, -10, -10}, [Dim]float64{200, 200, 200}),
+		mustRect(Point{200, 200, 200}, [Dim]float64{1, 1, 1}),
+	} {
+		if got, expected := rt.CountIntersect(bb), len(rt.SearchIntersect(bb)); got != expected {
+			t.Errorf("CountIntersect(%v) = %d; expected %d", bb, got, expected)
+		}
+		if allocs := testing.AllocsPerRun(10, func() { rt.CountIntersect(bb) }); allocs != 0 {
+			t.Errorf("CountIntersect(%v) made %v allocations; expected none", bb, allocs)
+		}
+	}
+	if got := NewTree(3, 6).CountIntersect(mustRect(Point{}, [Dim]float64{1, 1, 1})); got != 0 {
+		t.Errorf("CountIntersect on an empty tree = %d; expected 0", got)
+	}
+}
+
+func TestEstimateCount(t *testing.T) {
+	rt := NewTree(5, 10)
+	rnd := rand.New(rand.NewSource(28))
+	for i := 0; i < 5000; i++ {
+		p := Point{rnd.Float64() * 1000, rnd.Float64() * 1000, rnd.Float64() * 1000}
+		rt.Insert(mustRect(p, [Dim]float64{1, 1, 1}))
+	}
+
+	bb := mustRect(Point{100, 100, 100}, [Dim]float64{600, 600, 600})
+	exact := len(rt.SearchIntersect(bb))
+	if got := rt.EstimateCount(bb, math.MaxInt); got != exact {
+		t.Errorf("EstimateCount with unlimited budget = %d; expected %d", got, exact)
+	}
+	got := rt.EstimateCount(bb, 20)
+	if math.Abs(float64(got-exact)) > 0.2*float64(exact) {
+		t.Errorf("EstimateCount with 20 nodes = %d; expected about %d", got, exact)
+	}
+	if got := rt.EstimateCount(mustRect(Point{2000, 2000, 2000}, [Dim]float64{1, 1, 1}), 20); got != 0 {
+		t.Errorf("EstimateCount outside the tree = %d; expected 0", got)
+	}
+	if got := NewTree(3, 6).EstimateCount(bb, 20); got != 0 {
+		t.Errorf("EstimateCount on an empty tree = %d; expected 0", got)
+	}
+}
+
+func TestNearestNeighborsTies(t *testing.T) {
+	p := Point{10, 10, 0}
+	// eight points at distance 5 from p, one nearer and one farther
+	var objs []Spatial
+	for _, d := range [][2]float64{{5, 0}, {-5, 0}, {0, 5}, {0, -5}, {3, 4}, {-3, 4}, {3, -4}, {-3, -4}, {1, 0}, {6, 6}} {
+		objs = append(objs, Point{p[0] + d[0], p[1] + d[1], 0}.ToRect(0))
+	}
+	expected := []Spatial{objs[8], objs[1], objs[7], objs[5], objs[3], objs[2], objs[6], objs[4], objs[0], objs[9]}
+
+	rnd := rand.New(rand.NewSource(35))
+	for trial := 0; trial < 10; trial++ {
+		rt := NewTree(2, 3)
+		for _, i := range rnd.Perm(len(objs)) {
+			rt.Insert(objs[i])
+		}
+		for _, k := range []int{1, 4, 9, len(objs)} {
+			nearest := rt.NearestNeighbors(k, p)
+			for i, obj := range nearest {
+				if obj != expected[i] {
+					t.Errorf("trial %d: NearestNeighbors(%d)[%d] = %v; expected %v", trial, k, i, obj, expected[i])
+				}
+			}
+		}
+	}
+}
+
+func TestNearestNeighborsDist(t *testing.T) {
+	objs := randomRects(500, 30)
+	rt := NewTree(3, 6)
+	for _, obj := range objs {
+		rt.Insert(obj)
+	}
+	p := Point{50, 40, 30}
+	all := make([]float64, len(objs))
+	for i, obj := range objs {
+		all[i] = math.Sqrt(p.minDist(obj.Bounds()))
+	}
+	sort.Float64s(all)
+
+	for _, k := range []int{1, 2, 7, 30} {
+		nearest, dists := rt.NearestNeighborsDist(k, p)
+		if len(nearest) != k || len(dists) != k {
+			t.Fatalf("NearestNeighborsDist(%d) returned %d objects and %d distances", k, len(nearest), len(dists))
+		}
+		for i, obj := range nearest {
+			if d := math.Sqrt(p.minDist(obj.Bounds())); d != dists[i] {
+				t.Errorf("NearestNeighborsDist(%d) returned distance %v for an object at %v", k, dists[i], d)
+			}
+			if dists[i] != all[i] {
+				t.Errorf("NearestNeighborsDist(%d) returned %v as distance %d; expected %v", k, dists[i], i, all[i])
+			}
+		}
+	}
+
+	if nearest, dists := rt.NearestNeighborsDist(0, p); nearest != nil || dists != nil {
+		t.Errorf("NearestNeighborsDist(0) returned %v, %v", nearest, dists)
+	}
+	small := NewTree(3, 6)
+	small.Insert(objs[0])
+	if nearest, _ := small.NearestNeighborsDist(3, p); len(nearest) != 1 {
+		t.Errorf("NearestNeighborsDist(3) on a one-object tree returned %d objects", len(nearest))
+	}
+}
+
+func TestNearestNeighborsFilter(t *testing.T) {
+	objs := randomRects(500, 33)
+	rt := NewTree(3, 6)
+	for _, obj := range objs {
+		rt.Insert(obj)
+	}
+	p := Point{50, 40, 30}
+	accept := func(obj Spatial) bool { return obj.Bounds().p[0] > 70 }
+	var accepted []float64
+	for _, obj := range objs {
+		if accept(obj) {
+			accepted = append(accepted, math.Sqrt(p.minDist(obj.Bounds())))
+		}
+	}
+	sort.Float64s(accepted)
+
+	for _, k := range []int{1, 5, 20, len(accepted) + 10} {
+		nearest := rt.NearestNeighborsFilter(k, p, accept)
+		if len(nearest) != min(k, len(accepted)) {
+			t.Fatalf("NearestNeighborsFilter(%d) returned %d objects", k, len(nearest))
+		}
+		for i, obj := range nearest {
+			if !accept(obj) {
+				t.Errorf("NearestNeighborsFilter(%d) returned rejected object %v", k, obj)
+			}
+			if d := math.Sqrt(p.minDist(obj.Bounds())); d != accepted[i] {
+				t.Errorf("NearestNeighborsFilter(%d) returned an object at %v as neighbor %d; expected %v", k, d, i, accepted[i])
+			}
+		}
+	}
+
+	if nearest := rt.NearestNeighborsFilter(3, p, func(Spatial) bool { return false }); len(nearest) != 0 {
+		t.Errorf("NearestNeighborsFilter rejecting everything returned %v", nearest)
+	}
+}
+
+func TestFarthestNeighbors(t *testing.T) {
+	objs := randomRects(500, 37)
+	rt := NewTree(3, 6)
+	for _, obj := range objs {
+		rt.Insert(obj)
+	}
+	for _, p := range []Point{{0, 0, 0}, {50, 50, 50}, {-100, 30, 200}} {
+		var expected []float64
+		for _, obj := range objs {
+			expected = append(expected, p.minDist(obj.Bounds()))
+		}
+		sort.Sort(sort.Reverse(sort.Float64Slice(expected)))
+		for _, k := range []int{1, 10, 600} {
+			far := rt.FarthestNeighbors(k, p)
+			if len(far) != min(k, len(objs)) {
+				t.Fatalf("FarthestNeighbors(%d, %v) returned %d objects", k, p, len(far))
+			}
+			for i, obj := range far {
+				if d := p.minDist(obj.Bounds()); d != expected[i] {
+					t.Errorf("FarthestNeighbors(%d, %v) returned an object at %v as neighbor %d; expected %v", k, p, math.Sqrt(d), i, math.Sqrt(expected[i]))
+				}
+			}
+		}
+	}
+
+	if far := rt.FarthestNeighbors(0, Point{}); far == nil || len(far) != 0 {
+		t.Errorf("FarthestNeighbors(0) = %v", far)
+	}
+	if far := NewTree(3, 6).FarthestNeighbors(3, Point{}); far == nil || len(far) != 0 {
+		t.Errorf("FarthestNeighbors on an empty tree = %v", far)
+	}
+}
+
+func TestNearestNeighborsInto(t *testing.T) {
+	rt := NewTree(3, 6)
+	for _, obj := range randomRects(300, 36) {
+		rt.Insert(obj)
+	}
+	dst := make([]Spatial, 400)
+	for _, k := range []int{1, 7, 50, 400} {
+		for _, p := range []Point{{0, 0, 0}, {50, 40, 30}, {100, 10, 70}} {
+			got := rt.NearestNeighborsInto(dst[:k], p)
+			expected := rt.NearestNeighbors(k, p)
+			if len(got) != len(expected) {
+				t.Fatalf("NearestNeighborsInto(%d, %v) returned %d objects; expected %d", k, p, len(got), len(expected))
+			}
+			if &got[0] != &dst[0] {
+				t.Errorf("NearestNeighborsInto didn't store the objects in dst")
+			}
+			for i := range got {
+				if got[i] != expected[i] {
+					t.Errorf("NearestNeighborsInto(%d, %v)[%d] = %v; expected %v", k, p, i, got[i], expected[i])
+				}
+			}
+		}
+	}
+	if got := rt.NearestNeighborsInto(nil, Point{}); len(got) != 0 {
+		t.Errorf("NearestNeighborsInto of no objects returned %v", got)
+	}
+}
+
+func TestNearestNeighborExcept(t *testing.T) {
+	objs := randomRects(300, 35)
+	rt := NewTree(3, 6)
+	for _, obj := range objs {
+		rt.Insert(obj)
+	}
+	for _, obj := range objs[:50] {
+		c := obj.Bounds().center()
+		var others []Spatial
+		for _, other := range objs {
+			if other != obj {
+				others = append(others, other)
+			}
+		}
+		expected := nearestByScan(others, 3, c, Euclidean)
+		if got := rt.NearestNeighborExcept(c, obj); got == obj || c.minDist(got.Bounds()) != c.minDist(expected[0].Bounds()) {
+			t.Errorf("NearestNeighborExcept(%v) = %v; expected %v", obj, got, expected[0])
+		}
+		got := rt.NearestNeighborsExcept(3, c, obj)
+		if len(got) != 3 {
+			t.Fatalf("NearestNeighborsExcept(3) returned %d objects", len(got))
+		}
+		for i := range got {
+			if indexOf(got, obj) >= 0 || c.minDist(got[i].Bounds()) != c.minDist(expected[i].Bounds()) {
+				t.Errorf("NearestNeighborsExcept(3, %v) = %v; expected %v", obj, got, expected)
+				break
+			}
+		}
+	}
+
+	// every copy of the excluded object is skipped, comparing by Equal
+	bb := mustRect(Point{1, 1, 1}, [Dim]float64{1, 1, 1})
+	rt = NewTree(3, 6)
+	rt.Insert(tagged{bb, []string{"a"}})
+	rt.Insert(tagged{bb, []string{"a"}})
+	if got := rt.NearestNeighborExcept(Point{1, 1, 1}, tagged{bb, []string{"a"}}); got != nil {
+		t.Errorf("NearestNeighborExcept returned excluded object %v", got)
+	}
+	other := tagged{mustRect(Point{5, 5, 5}, [Dim]float64{1, 1, 1}), []string{"b"}}
+	rt.Insert(other)
+	if got := rt.NearestNeighborExcept(Point{1, 1, 1}, tagged{bb, []string{"a"}}); got == nil || got.(tagged).tags[0] != "b" {
+		t.Errorf("NearestNeighborExcept = %v; expected %v", got, other)
+	}
+}
+
+func TestNearestNeighborsDistinct(t *testing.T) {
+	// 500 parts of 60 entities
+	rt := NewTree(3, 6)
+	nearestPart := map[string]float64{}
+	p := Point{50, 40, 30}
+	for i, obj := range randomRects(500, 34) {
+		part := namedRect{obj.Bounds(), fmt.Sprint(i % 60)}
+		rt.Insert(part)
+		d := math.Sqrt(p.minDist(part.Rect))
+		if best, ok := nearestPart[part.name]; !ok || d < best {
+			nearestPart[part.name] = d
+		}
+	}
+	var expected []float64
+	for _, d := range nearestPart {
+		expected = append(expected, d)
+	}
+	sort.Float64s(expected)
+	key := func(obj Spatial) any { return obj.(namedRect).name }
+
+	for _, k := range []int{1, 5, 20, 100} {
+		nearest := rt.NearestNeighborsDistinct(k, p, key)
+		if len(nearest) != min(k, len(expected)) {
+			t.Fatalf("NearestNeighborsDistinct(%d) returned %d objects", k, len(nearest))
+		}
+		seen := map[string]bool{}
+		for i, obj := range nearest {
+			name := obj.(namedRect).name
+			if seen[name] {
+				t.Errorf("NearestNeighborsDistinct(%d) returned entity %s twice", k, name)
+			}
+			seen[name] = true
+			if d := math.Sqrt(p.minDist(obj.Bounds())); d != expected[i] || d != nearestPart[name] {
+				t.Errorf("NearestNeighborsDistinct(%d) returned a part of %s at %v as neighbor %d; expected %v", k, name, d, i, expected[i])
+			}
+		}
+	}
+
+	if nearest := rt.NearestNeighborsDistinct(0, p, key); nearest == nil || len(nearest) != 0 {
+		t.Errorf("NearestNeighborsDistinct(0) returned %v", nearest)
+	}
+}
+
+func TestNearestNeighborIterator(t *testing.T) {
+	objs := randomRects(500, 34)
+	rt := NewTree(3, 6)
+	for _, obj := range objs {
+		rt.Insert(obj)
+	}
+	p := Point{50, 40, 30}
+	all := make([]float64, len(objs))
+	for i, obj := range objs {
+		all[i] = math.Sqrt(p.minDist(obj.Bounds()))
+	}
+	sort.Float64s(all)
+
+	next := rt.NearestNeighborIterator(p)
+	seen := make(map[Spatial]bool)
+	for i := range objs {
+		obj, d, ok := next()
+		if !ok {
+			t.Fatalf("iterator ran out after %d objects of %d", i, len(objs))
+		}
+		if d != all[i] || d != math.Sqrt(p.minDist(obj.Bounds())) {
+			t.Errorf("iterator yielded %v at distance %v as neighbor %d; expected %v", obj, d, i, all[i])
+		}
+		if seen[obj] {
+			t.Errorf("iterator yielded %v twice", obj)
+		}
+		seen[obj] = true
+	}
+	if obj, _, ok := next(); ok {
+		t.Errorf("iterator yielded %v after every object", obj)
+	}
+
+	if _, _, ok := NewTree(3, 6).NearestNeighborIterator(p)(); ok {
+		t.Errorf("iterator over an empty tree yielded an object")
+	}
+}
+
+func TestNNSearcher(t *testing.T) {
+	objs := randomRects(500, 38)
+	rt := NewTree(3, 6)
+	s := rt.NewNNSearcher()
+	if obj := s.Nearest(Point{}); obj != nil {
+		t.Errorf("Nearest in an empty tree = %v", obj)
+	}
+	for _, obj := range objs {
+		rt.Insert(obj)
+	}
+
+	rnd := rand.New(rand.NewSource(39))
+	for i := 0; i < 50; i++ {
+		p := Point{rnd.Float64() * 100, rnd.Float64() * 100, rnd.Float64() * 100}
+		got, expected := s.Nearest(p), rt.NearestNeighbor(p)
+		if p.minDist(got.Bounds()) != p.minDist(expected.Bounds()) {
+			t.Errorf("Nearest(%v) = %v; expected %v", p, got, expected)
+		}
+	}
+
+	p := Point{50, 50, 50}
+	if allocs := testing.AllocsPerRun(100, func() { s.Nearest(p) }); allocs != 0 {
+		t.Errorf("Nearest made %v allocations per call", allocs)
+	}
+}
+
+func TestNearestNeighborsWithin(t *testing.T) {
+	objs := randomRects(500, 31)
+	rt := NewTree(3, 6)
+	for _, obj := range objs {
+		rt.Insert(obj)
+	}
+	p := Point{40, 50, 60}
+	radius := 15.0
+	expected := 0
+	for _, obj := range objs {
+		if p.minDist(obj.Bounds()) <= radius*radius {
+			expected++
+		}
+	}
+
+	within := rt.NearestNeighborsWithin(p, radius)
+	if len(within) != expected {
+		t.Fatalf("NearestNeighborsWithin returned %d objects; expected %d", len(within), expected)
+	}
+	for i := 1; i < len(within); i++ {
+		if p.minDist(within[i-1].Bounds()) > p.minDist(within[i].Bounds()) {
+			t.Errorf("NearestNeighborsWithin returned objects out of order at %d", i)
+		}
+	}
+
+	// an object exactly at the radius is included
+	edge := mustRect(Point{40, 50, 80}, [Dim]float64{1, 1, 1})
+	rt.Insert(edge)
+	if within := rt.NearestNeighborsWithin(p, 20); indexOf(within, edge) < 0 {
+		t.Errorf("NearestNeighborsWithin excluded an object at the radius")
+	}
+}
+
+func TestSearchSphere(t *testing.T) {
+	objs := randomRects(500, 32)
+	rt := NewTree(3, 6)
+	for _, obj := range objs {
+		rt.Insert(obj)
+	}
+	center := Point{40, 50, 60}
+	radius := 15.0
+
+	found := rt.SearchSphere(center, radius)
+	expected := 0
+	for _, obj := range objs {
+		if center.minDist(obj.Bounds()) <= radius*radius {
+			expected++
+			if indexOf(found, obj) < 0 {
+				t.Errorf("SearchSphere missed %v", obj)
+			}
+		}
+	}
+	if len(found) != expected {
+		t.Errorf("SearchSphere returned %d objects; expected %d", len(found), expected)
+	}
+
+	// an object touching the sphere is included
+	edge := mustRect(Point{40, 50, 80}, [Dim]float64{1, 1, 1})
+	rt.Insert(edge)
+	if found := rt.SearchSphere(center, 20); indexOf(found, edge) < 0 {
+		t.Errorf("SearchSphere excluded an object touching the sphere")
+	}
+}
+
+func TestSearchSwept(t *testing.T) {
+	objs := randomRects(500, 33)
+	rt := NewTree(3, 6)
+	for _, obj := range objs {
+		rt.Insert(obj)
+	}
+
+	// standing still is an intersection search
+	bb := mustRect(Point{30, 30, 30}, [Dim]float64{20, 20, 20})
+	if found, expected := rt.SearchSwept(bb, bb), rt.SearchIntersect(bb); len(found) != len(expected) {
+		t.Errorf("SearchSwept of a box standing still returned %d objects; expected %d", len(found), len(expected))
+	}
+
+	// every object hit at some point of the motion is found, and nothing
+	// far from the path is
+	start := mustRect(Point{10, 10, 10}, [Dim]float64{5, 5, 5})
+	end := mustRect(Point{80, 70, 60}, [Dim]float64{10, 10, 10})
+	found := rt.SearchSwept(start, end)
+	for _, obj := range objs {
+		hit, near := false, false
+		for i := 0; i <= 1000; i++ {
+			r := lerpRect(start, end, float64(i)/1000)
+			hit = hit || intersect(&r, obj.Bounds())
+			near = near || intersect(r.Expand(0.2), obj.Bounds())
+		}
+		if got := indexOf(found, obj) >= 0; hit && !got {
+			t.Errorf("SearchSwept missed %v", obj)
+		} else if got && !near {
+			t.Errorf("SearchSwept returned %v, which is off the path", obj)
+		}
+	}
+
+	// an object inside the bounding box of the motion but beside the
+	// diagonal is left out
+	rt = NewTree(3, 6)
+	beside := mustRect(Point{8, 0, 0}, [Dim]float64{1, 1, 1})
+	onPath := mustRect(Point{4.5, 4.5, 0}, [Dim]float64{1, 1, 1})
+	rt.Insert(beside)
+	rt.Insert(onPath)
+	start = mustRect(Point{0, 0, 0}, [Dim]float64{1, 1, 1})
+	end = mustRect(Point{9, 9, 0}, [Dim]float64{1, 1, 1})
+	found = rt.SearchSwept(start, end)
+	if len(found) != 1 || found[0] != onPath {
+		t.Errorf("SearchSwept along the diagonal returned %v; expected only %v", found, onPath)
+	}
+
+	if found := NewTree(3, 6).SearchSwept(start, end); found == nil || len(found) != 0 {
+		t.Errorf("SearchSwept on an empty tree returned %v", found)
+	}
+}
+
+func TestSearchHalfInfinite(t *testing.T) {
+	objs := randomRects(500, 38)
+	rt := NewTree(3, 6)
+	for _, obj := range objs {
+		rt.Insert(obj)
+	}
+	for _, bb := range []*Rect{
+		UnboundedRect(),
+		UnboundedRect().WithBounds(0, 50, math.Inf(1)),
+		UnboundedRect().WithBounds(1, math.Inf(-1), 20).WithBounds(2, 30, 60),
+	} {
+		found, contained := rt.SearchIntersect(bb), rt.SearchContained(bb)
+		intersecting, inside := 0, 0
+		for _, obj := range objs {
+			if intersect(bb, obj.Bounds()) {
+				intersecting++
+				if indexOf(found, obj) < 0 {
+					t.Errorf("SearchIntersect(%v) missed %v", bb, obj)
+				}
+			}
+			if bb.containsRect(obj.Bounds()) {
+				inside++
+			}
+		}
+		if len(found) != intersecting || rt.CountIntersect(bb) != intersecting {
+			t.Errorf("SearchIntersect(%v) found %d objects and CountIntersect %d; expected %d", bb, len(found), rt.CountIntersect(bb), intersecting)
+		}
+		if len(contained) != inside {
+			t.Errorf("SearchContained(%v) found %d objects; expected %d", bb, len(contained), inside)
+		}
+	}
+	if found := rt.SearchIntersect(UnboundedRect()); len(found) != len(objs) {
+		t.Errorf("SearchIntersect of all of space found %d of %d objects", len(found), len(objs))
+	}
+}
+
+func TestSearchIntersectFlatOnNodeBoundary(t *testing.T) {
+	// the flat object lies on the face of its leaf's box, which the query
+	// only touches
+	flat := &Rect{Point{5, 0, 0}, Point{5, 1, 1}}
+	objs := []Spatial{
+		flat,
+		mustRect(Point{4, 0, 0}, [Dim]float64{1, 1, 1}),
+		mustRect(Point{3, 0.5, 0}, [Dim]float64{1, 1, 1}),
+		mustRect(Point{50, 20, 0}, [Dim]float64{1, 1, 1}),
+		mustRect(Point{50, 25, 0}, [Dim]float64{1, 1, 1}),
+		mustRect(Point{50, 29, 0}, [Dim]float64{1, 1, 1}),
+	}
+	rt, err := BulkLoad(2, 3, 1, objs)
+	if err != nil {
+		t.Fatalf("BulkLoad failed: %v", err)
+	}
+	q := &Rect{Point{5, 0, 0}, Point{8, 1, 1}}
+	if !intersect(flat, q) {
+		t.Fatalf("%v doesn't intersect %v", flat, q)
+	}
+	if found := rt.SearchIntersect(q); len(found) != 1 || found[0] != flat {
+		t.Errorf("SearchIntersect(%v) = %v; expected %v", q, found, flat)
+	}
+	if found := rt.SearchIntersect(q, FilterLimit(5)); len(found) != 1 {
+		t.Errorf("SearchIntersect(%v) with a filter = %v; expected %v", q, found, flat)
+	}
+	if found := rt.FloodSelect(q, false); indexOf(found, flat) < 0 {
+		t.Errorf("FloodSelect(%v) = %v; expected it to include %v", q, found, flat)
+	}
+}
+
+func TestSpatialJoin(t *testing.T) {
+	roads, buildings := randomRects(300, 36), randomRects(20, 37)
+	a, b := NewTree(2, 3), NewTree(3, 6)
+	for _, obj := range roads {
+		a.Insert(obj)
+	}
+	for _, obj := range buildings {
+		b.Insert(obj)
+	}
+	if a.Depth() == b.Depth() {
+		t.Fatalf("trees have the same depth %d", a.Depth())
+	}
+
+	expected := make(map[[2]Spatial]bool)
+	for _, r := range roads {
+		for _, s := range buildings {
+			if intersect(r.Bounds(), s.Bounds()) {
+				expected[[2]Spatial{r, s}] = true
+			}
+		}
+	}
+	for _, join := range []struct {
+		name  string
+		pairs [][2]Spatial
+		swap  bool
+	}{
+		{"SpatialJoin(a, b)", SpatialJoin(a, b), false},
+		{"SpatialJoin(b, a)", SpatialJoin(b, a), true},
+	} {
+		if len(join.pairs) != len(expected) || len(expected) == 0 {
+			t.Errorf("%s returned %d pairs; expected %d", join.name, len(join.pairs), len(expected))
+		}
+		for _, pair := range join.pairs {
+			if join.swap {
+				pair[0], pair[1] = pair[1], pair[0]
+			}
+			if !expected[pair] {
+				t.Errorf("%s returned %v, which don't intersect", join.name, pair)
+			}
+		}
+	}
+
+	if pairs := SpatialJoin(a, NewTree(2, 3)); len(pairs) != 0 {
+		t.Errorf("SpatialJoin with an empty tree returned %v", pairs)
+	}
+}
+
+func TestEmptyTreeQueries(t *testing.T) {
+	rt := NewTree(3, 6)
+	bb := mustRect(Point{0, 0, 0}, [Dim]float64{10, 10, 10})
+	p := Point{1, 2, 3}
+	obj := mustRect(p, [Dim]float64{1, 1, 1})
+
+	// searches returning slices return empty, non-nil ones
+	for name, objs := range map[string][]Spatial{
+		"SearchIntersect":            rt.SearchIntersect(bb),
+		"SearchIntersect filtered":   rt.SearchIntersect(bb, FilterLimit(3)),
+		"SearchContained":            rt.SearchContained(bb),
+		"SearchIntersectCentered":    rt.SearchIntersectCentered(p, Point{1, 1, 1}),
+		"SearchIntersectTransformed": rt.SearchIntersectTransformed(bb, func(p Point) Point { return p }),
+		"SearchIntersectByOverlap":   rt.SearchIntersectByOverlap(bb),
+		"SearchRay":                  rt.SearchRay(p, Point{1, 0, 0}),
+		"SearchSphere":               rt.SearchSphere(p, 5),
+		"FloodSelect":                rt.FloodSelect(obj, true),
+		"LargestInRect":              rt.LargestInRect(bb, 3),
+		"NearestChain":               rt.NearestChain(p, 3),
+		"NearestNeighbors":           rt.NearestNeighbors(3, p),
+		"NearestNeighbors(0)":        rt.NearestNeighbors(0, p),
+		"NearestNeighborsFilter":     rt.NearestNeighborsFilter(3, p, func(Spatial) bool { return true }),
+		"NearestNeighborsWithin":     rt.NearestNeighborsWithin(p, 5),
+		"NearestNeighborsMetric":     rt.NearestNeighborsMetric(3, p, Manhattan),
+		"All":                        rt.All(),
+	} {
+		if objs == nil || len(objs) != 0 {
+			t.Errorf("%s of an empty tree = %#v; expected an empty slice", name, objs)
+		}
+	}
+	if objs, err := rt.SearchIntersectContext(context.Background(), bb); objs == nil || len(objs) != 0 || err != nil {
+		t.Errorf("SearchIntersectContext of an empty tree = %v, %v", objs, err)
+	}
+	if ranked := rt.SearchIntersectRanked(bb); ranked == nil || len(ranked) != 0 {
+		t.Errorf("SearchIntersectRanked of an empty tree = %v", ranked)
+	}
+	if groups := rt.SearchIntersectGrouped(bb); len(groups) != 0 {
+		t.Errorf("SearchIntersectGrouped of an empty tree = %v", groups)
+	}
+	if objs, dists := rt.NearestNeighborsDist(3, p); len(objs) != 0 || len(dists) != 0 {
+		t.Errorf("NearestNeighborsDist of an empty tree = %v, %v", objs, dists)
+	}
+
+	// searches for a single object find none
+	for name, found := range map[string]Spatial{
+		"NearestNeighbor":       rt.NearestNeighbor(p),
+		"NearestNeighborMetric": rt.NearestNeighborMetric(p, Chebyshev),
+		"NNSearcher":            rt.NewNNSearcher().Nearest(p),
+		"NearestInCone":         rt.NearestInCone(p, Point{1, 0, 0}, math.Pi/4),
+		"FarthestInRect":        rt.FarthestInRect(bb, p),
+		"SmallestCovering":      rt.SmallestCovering(bb),
+	} {
+		if found != nil {
+			t.Errorf("%s of an empty tree = %v; expected nil", name, found)
+		}
+	}
+	if _, _, ok := rt.NearestNeighborIterator(p)(); ok {
+		t.Errorf("NearestNeighborIterator of an empty tree yielded an object")
+	}
+
+	rt.SearchIntersectFunc(bb, func(obj Spatial) bool {
+		t.Errorf("SearchIntersectFunc of an empty tree visited %v", obj)
+		return true
+	})
+	rt.PairsWithin(5, func(a, b Spatial, dist float64) {
+		t.Errorf("PairsWithin of an empty tree visited %v and %v", a, b)
+	})
+	rt.Prefetch(bb)
+	if n := rt.EstimateCount(bb, 10); n != 0 {
+		t.Errorf("EstimateCount of an empty tree = %d", n)
+	}
+	if rt.Contains(obj, nil) || rt.Delete(obj) || rt.Update(obj, bb) || rt.DeleteWithFunc(func(Spatial) bool { return true }) != 0 {
+		t.Errorf("an empty tree reported holding %v", obj)
+	}
+	if rt.Bounds() != nil || rt.OverlapsTree(rt) {
+		t.Errorf("an empty tree has bounds %v", rt.Bounds())
+	}
+	if _, _, err := rt.Extent(); err != ErrEmptyTree {
+		t.Errorf("Extent of an empty tree returned %v; expected ErrEmptyTree", err)
+	}
+	verify(t, rt.root)
+}
+
+func TestDegeneratePoints(t *testing.T) {
+	rt := NewTree(3, 6)
+	rnd := rand.New(rand.NewSource(33))
+	var points []*Rect
+	for i := 0; i < 200; i++ {
+		r, err := NewRect(Point{float64(rnd.Intn(20)), float64(rnd.Intn(20)), 0}, [Dim]float64{})
+		if err != nil {
+			t.Fatalf("NewRect of a point failed: %v", err)
+		}
+		points = append(points, &r)
+		rt.Insert(&r)
+	}
+	verify(t, rt.root)
+
+	for _, p := range points[:20] {
+		if q := rt.SearchIntersect(p); indexOf(q, p) < 0 {
+			t.Errorf("SearchIntersect(%v) didn't find the point itself", p)
+		}
+	}
+	// points on the faces of the query box are found
+	bb := mustRect(Point{5, 5, -1}, [Dim]float64{5, 5, 1})
+	expected := 0
+	for _, p := range points {
+		if bb.containsRect(p) {
+			expected++
+		}
+	}
+	if q := rt.SearchIntersect(bb); len(q) != expected {
+		t.Errorf("SearchIntersect(%v) found %d points; expected %d", bb, len(q), expected)
+	}
+	if nn := rt.NearestNeighbor(Point{points[7].p[0], points[7].p[1], 0}); nn.Bounds().p != points[7].p {
+		t.Errorf("NearestNeighbor returned %v; expected a point at %v", nn, points[7])
+	}
+	for _, p := range points {
+		if !rt.Delete(p) {
+			t.Fatalf("Delete(%v) failed", p)
+		}
+	}
+	if rt.Size() != 0 {
+		t.Errorf("tree has size %d after deleting every point", rt.Size())
+	}
+}
+
+func TestUpdateBucket(t *testing.T) {
+	bb := mustRect(Point{1, 1, 1}, [Dim]float64{1, 1, 1})
+	for _, n := range []int{6, 30} {
+		rt := NewTree(2, 4, WithAutoOptimize(1e9))
+		var objs []*movable
+		for range n {
+			m := &movable{bb}
+			objs = append(objs, m)
+			rt.Insert(m)
+		}
+		before := rt.changes
+		// a box within the bucket's own
+		inner := mustRect(Point{1.2, 1.2, 1.2}, [Dim]float64{0.5, 0.5, 0.5})
+		if !rt.Update(objs[0], inner) {
+			t.Fatalf("Update failed to find a bucket member")
+		}
+		objs[0].bb = inner
+		if err := rt.Validate(); err != nil {
+			t.Errorf("tree of %d identical boxes invalid after Update: %v", n, err)
+		}
+		if !rt.Contains(objs[0], nil) || rt.Size() != n {
+			t.Errorf("tree of %d identical boxes lost the updated object", n)
+		}
+		if rt.changes != before+1 {
+			t.Errorf("Update counted %d changes; expected 1", rt.changes-before)
+		}
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	rnd := rand.New(rand.NewSource(34))
+	rt := NewTree(3, 6)
+	var objs []*movable
+	for _, obj := range randomRects(300, 35) {
+		m := &movable{obj.Bounds()}
+		objs = append(objs, m)
+		rt.Insert(m)
+	}
+
+	for i, m := range objs {
+		var nb Rect
+		if i%2 == 0 {
+			// a small move, which usually stays within the leaf
+			nb = *m.bb
+			for j := range nb.p {
+				nb.p[j] += 0.01
+				nb.q[j] -= 0.01
+			}
+		} else {
+			nb = *mustRect(Point{rnd.Float64() * 100, rnd.Float64() * 100, rnd.Float64() * 100}, [Dim]float64{1, 1, 1})
+		}
+		if !rt.Update(m, &nb) {
+			t.Fatalf("Update(%v) failed", m)
+		}
+		m.bb = &nb
+	}
+	verify(t, rt.root)
+	verifyTight(t, rt.root)
+	if rt.Size() != len(objs) {
+		t.Errorf("Update changed the size to %d; expected %d", rt.Size(), len(objs))
+	}
+	for i, m := range objs {
+		if !rt.Contains(m, nil) {
+			t.Errorf("object %d not found at its new bounds", i)
+		}
+	}
+
+	stray := &movable{mustRect(Point{1, 1, 1}, [Dim]float64{1, 1, 1})}
+	if rt.Update(stray, stray.bb) {
+		t.Errorf("Update of an object not in the tree succeeded")
+	}
+}
+
+func TestSearchContained(t *testing.T) {
+	objs := randomRects(400, 39)
+	rt := NewTree(3, 6)
+	for _, obj := range objs {
+		rt.Insert(obj)
+	}
+	bb := mustRect(Point{10, 10, 10}, [Dim]float64{50, 50, 50})
+	flush := mustRect(Point{10, 20, 20}, [Dim]float64{5, 5, 5})
+	flat, _ := NewRect(Point{60, 30, 30}, [Dim]float64{0, 1, 1})
+	straddling := mustRect(Point{58, 30, 30}, [Dim]float64{5, 1, 1})
+	for _, obj := range []*Rect{flush, &flat, straddling} {
+		rt.Insert(obj)
+		objs = append(objs, obj)
+	}
+
+	q := rt.SearchContained(bb)
+	expected := 0
+	for _, obj := range objs {
+		if bb.containsRect(obj.Bounds()) {
+			expected++
+			if indexOf(q, obj) < 0 {
+				t.Errorf("SearchContained failed to find %v", obj)
+			}
+		}
+	}
+	if len(q) != expected {
+		t.Errorf("SearchContained found %d objects; expected %d", len(q), expected)
+	}
+	if indexOf(q, straddling) >= 0 {
+		t.Errorf("SearchContained returned %v, which only intersects %v", straddling, bb)
+	}
+}
+
+func TestSearchIntersectFunc(t *testing.T) {
+	rt := NewTree(3, 6)
+	for _, obj := range randomRects(300, 40) {
+		rt.Insert(obj)
+	}
+	bb := mustRect(Point{0, 0, 0}, [Dim]float64{60, 60, 60})
+	expected := rt.SearchIntersect(bb)
+
+	var got []Spatial
+	rt.SearchIntersectFunc(bb, func(obj Spatial) bool {
+		got = append(got, obj)
+		return true
+	})
+	if len(got) != len(expected) {
+		t.Fatalf("SearchIntersectFunc visited %d objects; expected %d", len(got), len(expected))
+	}
+	for i := range got {
+		if got[i] != expected[i] {
+			t.Errorf("SearchIntersectFunc visited %v at %d; expected %v", got[i], i, expected[i])
+		}
+	}
+
+	calls := 0
+	rt.SearchIntersectFunc(bb, func(obj Spatial) bool {
+		calls++
+		return calls < 5
+	})
+	if calls != min(5, len(expected)) {
+		t.Errorf("SearchIntersectFunc continued for %d calls after fn returned false", calls)
+	}
+}
+
+func TestSearchIntersectContext(t *testing.T) {
+	rt := NewTree(3, 6)
+	for _, obj := range randomRects(2000, 41) {
+		rt.Insert(obj)
+	}
+	bb := mustRect(Point{0, 0, 0}, [Dim]float64{100, 100, 100})
+	expected := rt.SearchIntersect(bb)
+
+	got, err := rt.SearchIntersectContext(context.Background(), bb)
+	if err != nil {
+		t.Fatalf("SearchIntersectContext failed: %v", err)
+	}
+	if len(got) != len(expected) {
+		t.Fatalf("SearchIntersectContext returned %d objects; expected %d", len(got), len(expected))
+	}
+	for i := range got {
+		if got[i] != expected[i] {
+			t.Errorf("SearchIntersectContext returned %v at %d; expected %v", got[i], i, expected[i])
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	got, err = rt.SearchIntersectContext(ctx, bb)
+	if err != context.Canceled {
+		t.Errorf("SearchIntersectContext with a canceled context returned error %v", err)
+	}
+	if len(got) >= len(expected) {
+		t.Errorf("SearchIntersectContext with a canceled context returned all %d objects", len(got))
+	}
+	for i := range got {
+		if got[i] != expected[i] {
+			t.Errorf("SearchIntersectContext returned partial result %v at %d; expected %v", got[i], i, expected[i])
+		}
+	}
+}
+
+func TestStats(t *testing.T) {
+	rt := NewTree(3, 6)
+	if stats := rt.Stats(); stats.Leaves != 1 || stats.Internal != 0 || len(stats.Fill) != 1 || stats.Fill[0] != 0 {
+		t.Errorf("Stats of an empty tree = %+v", stats)
+	}
+	for _, obj := range randomRects(300, 41) {
+		rt.Insert(obj)
+	}
+
+	stats := rt.Stats()
+	leaves, internal := 0, 0
+	rt.root.walkNodes(func(n *node) {
+		if n.leaf {
+			leaves++
+		} else {
+			internal++
+		}
+	})
+	if stats.Leaves != leaves || stats.Internal != internal {
+		t.Errorf("Stats counted %d leaves and %d internal nodes; expected %d and %d", stats.Leaves, stats.Internal, leaves, internal)
+	}
+	if len(stats.Fill) != rt.Depth() {
+		t.Fatalf("Stats has %d fill factors for a tree of depth %d", len(stats.Fill), rt.Depth())
+	}
+	if expected := float64(rt.Size()) / float64(leaves*6); math.Abs(stats.Fill[0]-expected) > 1e-9 {
+		t.Errorf("Stats has leaf fill %v; expected %v", stats.Fill[0], expected)
+	}
+	if expected := float64(len(rt.root.entries)) / 6; stats.Fill[rt.Depth()-1] != expected {
+		t.Errorf("Stats has root fill %v; expected %v", stats.Fill[rt.Depth()-1], expected)
+	}
+	for i, f := range stats.Fill[:len(stats.Fill)-1] {
+		if f < 0.5 || f > 1 {
+			t.Errorf("Stats has fill %v at level %d", f, i+1)
+		}
+	}
+}
+
+func TestTotalOverlap(t *testing.T) {
+	rt := NewTree(3, 6)
+	if o := rt.TotalOverlap(); o != 0 {
+		t.Errorf("TotalOverlap of an empty tree = %v", o)
+	}
+	for _, obj := range randomRects(300, 43) {
+		rt.Insert(obj)
+	}
+	expected := 0.0
+	rt.root.walkNodes(func(n *node) {
+		if !n.leaf {
+			var boxes []*Rect
+			for _, e := range n.entries {
+				boxes = append(boxes, e.bb)
+			}
+			expected += totalOverlap(boxes)
+		}
+	})
+	if o := rt.TotalOverlap(); math.Abs(o-expected) > EPS*expected || o == 0 {
+		t.Errorf("TotalOverlap() = %v; expected %v", o, expected)
+	}
+
+	// leaves that are apart don't overlap
+	rt = NewTree(1, 2)
+	for _, p := range []Point{{0, 0, 0}, {1, 0, 0}, {5, 0, 0}, {6, 0, 0}} {
+		rt.Insert(mustRect(p, [Dim]float64{1, 1, 1}))
+	}
+	if o := rt.TotalOverlap(); o != 0 {
+		t.Errorf("TotalOverlap of disjoint leaves = %v", o)
+	}
+}
+
+func TestClear(t *testing.T) {
+	rt := NewTree(3, 6, WithLazyCondense())
+	objs := randomRects(200, 42)
+	for _, obj := range objs {
+		rt.Insert(obj)
+	}
+	rt.Delete(objs[0])
+	rt.Clear()
+
+	if rt.Size() != 0 || rt.Depth() != 1 || rt.loose() {
+		t.Errorf("cleared tree has size %d, depth %d and loose %v", rt.Size(), rt.Depth(), rt.loose())
+	}
+	if rt.MinChildren != 3 || rt.MaxChildren != 6 || !rt.lazyCondense {
+		t.Errorf("Clear changed the tree's configuration")
+	}
+	if q := rt.SearchIntersect(mustRect(Point{0, 0, 0}, [Dim]float64{100, 100, 100})); len(q) != 0 {
+		t.Errorf("cleared tree found %d objects", len(q))
+	}
+	if nn := rt.NearestNeighbor(Point{1, 2, 3}); nn != nil {
+		t.Errorf("cleared tree found nearest neighbor %v", nn)
+	}
+
+	for _, obj := range objs[:100] {
+		rt.Insert(obj)
+	}
+	verify(t, rt.root)
+	if rt.Size() != 100 {
+		t.Errorf("refilled tree has size %d; expected 100", rt.Size())
+	}
+}
+
+func TestClone(t *testing.T) {
+	objs := randomRects(300, 43)
+	rt := NewTree(3, 6, WithLazyCondense())
+	for _, obj := range objs[:200] {
+		rt.Insert(obj)
+	}
+	rt.Delete(objs[0])
+	bb := mustRect(Point{10, 10, 10}, [Dim]float64{50, 50, 50})
+	expected := rt.SearchIntersect(bb)
+
+	c := rt.Clone()
+	if c.Size() != rt.Size() || c.Depth() != rt.Depth() || !c.lazyCondense || !c.loose() {
+		t.Errorf("clone has size %d, depth %d; expected %d, %d and the same options", c.Size(), c.Depth(), rt.Size(), rt.Depth())
+	}
+	original := map[*node]bool{}
+	rt.root.walkNodes(func(n *node) { original[n] = true })
+	c.root.walkNodes(func(n *node) {
+		if original[n] {
+			t.Fatalf("clone shares node %v with the original", n)
+		}
+	})
+
+	for _, obj := range objs[200:] {
+		c.Insert(obj)
+	}
+	for _, obj := range objs[1:100] {
+		c.Delete(obj)
+	}
+	c.Compact()
+	verify(t, c.root)
+	verify(t, rt.root)
+	if rt.Size() != 199 || c.Size() != 200 {
+		t.Errorf("original has size %d and clone %d; expected 199 and 200", rt.Size(), c.Size())
+	}
+	q := rt.SearchIntersect(bb)
+	if len(q) != len(expected) {
+		t.Errorf("original found %d objects after changes to the clone; expected %d", len(q), len(expected))
+	}
+	for i := range q {
+		if q[i] != expected[i] {
+			t.Errorf("original returned %v at %d; expected %v", q[i], i, expected[i])
+		}
+	}
+}
+
+func TestWalkAll(t *testing.T) {
+	objs := randomRects(300, 46)
+	rt := NewTree(3, 6)
+	for _, obj := range objs {
+		rt.Insert(obj)
+	}
+
+	seen := map[Spatial]int{}
+	rt.Walk(func(obj Spatial) bool {
+		seen[obj]++
+		return true
+	})
+	all := rt.All()
+	if len(all) != len(objs) || len(seen) != len(objs) {
+		t.Errorf("All returned %d objects and Walk visited %d; expected %d", len(all), len(seen), len(objs))
+	}
+	for _, obj := range objs {
+		if seen[obj] != 1 || indexOf(all, obj) < 0 {
+			t.Errorf("Walk visited %v %d times", obj, seen[obj])
+		}
+	}
+
+	calls := 0
+	rt.Walk(func(obj Spatial) bool {
+		calls++
+		return calls < 10
+	})
+	if calls != 10 {
+		t.Errorf("Walk continued for %d calls after fn returned false", calls)
+	}
+	if all := NewTree(3, 6).All(); len(all) != 0 {
+		t.Errorf("All on an empty tree returned %v", all)
+	}
+}
+
+func TestDeleteWithFunc(t *testing.T) {
+	for _, opts := range [][]Option{nil, {WithLazyCondense()}} {
+		objs := randomRects(500, 49)
+		rt := NewTree(3, 6, opts...)
+		for _, obj := range objs {
+			rt.Insert(obj)
+		}
+
+		evict := func(obj Spatial) bool { return obj.Bounds().p[0] < 60 }
+		expected := 0
+		for _, obj := range objs {
+			if evict(obj) {
+				expected++
+			}
+		}
+		if removed := rt.DeleteWithFunc(evict); removed != expected {
+			t.Errorf("DeleteWithFunc removed %d objects; expected %d", removed, expected)
+		}
+		rt.Compact()
+		verify(t, rt.root)
+		verifyTight(t, rt.root)
+		rt.root.walkNodes(func(n *node) {
+			if n != rt.root && len(n.entries) < rt.MinChildren {
+				t.Errorf("DeleteWithFunc left a node with %d entries", len(n.entries))
+			}
+		})
+		if rt.Size() != len(objs)-expected {
+			t.Errorf("tree has size %d; expected %d", rt.Size(), len(objs)-expected)
+		}
+		for _, obj := range objs {
+			if rt.Contains(obj, nil) == evict(obj) {
+				t.Errorf("object %v found = %v after DeleteWithFunc", obj, !evict(obj))
+			}
+		}
+		size := rt.Size()
+		if removed := rt.DeleteWithFunc(func(Spatial) bool { return true }); removed != size {
+			t.Errorf("DeleteWithFunc of everything removed %d objects; expected %d", removed, size)
+		}
+		rt.Compact()
+		if rt.Size() != 0 || rt.Depth() != 1 || len(rt.root.entries) != 0 {
+			t.Errorf("DeleteWithFunc of everything left size %d and depth %d", rt.Size(), rt.Depth())
+		}
+		rt.Insert(objs[0])
+		verify(t, rt.root)
+	}
+}
+
+// tagged is a Spatial that can't be compared with ==.
+type tagged struct {
+	bb   *Rect
+	tags []string
+}
+
+func (t tagged) Bounds() *Rect { return t.bb }
+
+func (t tagged) Equal(other Spatial) bool {
+	o, ok := other.(tagged)
+	return ok && strings.Join(t.tags, ",") == strings.Join(o.tags, ",")
+}
+
+func TestDeleteComparable(t *testing.T) {
+	bb := mustRect(Point{1, 1, 1}, [Dim]float64{1, 1, 1})
+	a := tagged{bb, []string{"a"}}
+	b := tagged{bb, []string{"b"}}
+	rt := NewTree(3, 6)
+	for _, obj := range randomRects(50, 50) {
+		rt.Insert(obj)
+	}
+	rt.Insert(a)
+	rt.Insert(b)
+
+	if !rt.Delete(tagged{bb, []string{"b"}}) {
+		t.Fatalf("Delete failed to find the Comparable object")
+	}
+	if !rt.Contains(a, nil) || rt.Contains(b, nil) {
+		t.Errorf("Delete removed the wrong object of two with equal bounds")
+	}
+	if rt.Delete(b) {
+		t.Errorf("Delete removed an object twice")
+	}
+	if !rt.Delete(a) || rt.Size() != 50 {
+		t.Errorf("Delete failed to remove the remaining Comparable object")
+	}
+}
+
+func TestDeleteAndGet(t *testing.T) {
+	bb := mustRect(Point{1, 1, 1}, [Dim]float64{1, 1, 1})
+	stored := tagged{bb, []string{"a"}}
+	rt := NewTree(3, 6)
+	for _, obj := range randomRects(50, 51) {
+		rt.Insert(obj)
+	}
+	rt.Insert(stored)
+
+	// the object returned is the stored one, not the equal one passed in
+	obj, ok := rt.DeleteAndGet(tagged{bb, []string{"a"}})
+	if !ok {
+		t.Fatalf("DeleteAndGet failed to find the Comparable object")
+	}
+	if got, isTagged := obj.(tagged); !isTagged || &got.tags[0] != &stored.tags[0] {
+		t.Errorf("DeleteAndGet returned %v; expected the stored object", obj)
+	}
+	if rt.Size() != 50 || rt.Contains(stored, nil) {
+		t.Errorf("DeleteAndGet didn't remove the object")
+	}
+	if obj, ok := rt.DeleteAndGet(stored); ok || obj != nil {
+		t.Errorf("DeleteAndGet of a missing object returned %v, %v", obj, ok)
+	}
+	verify(t, rt.root)
+}
+
+func TestDeleteDuplicateBounds(t *testing.T) {
+	bb := mustRect(Point{5, 5, 5}, [Dim]float64{1, 1, 1})
+	rt := NewTree(2, 3)
+	var dups []*movable
+	for i, obj := range randomRects(20, 51) {
+		rt.Insert(obj)
+		if i%4 == 0 {
+			dup := &movable{bb}
+			dups = append(dups, dup)
+			rt.Insert(dup)
+		}
+	}
+
+	for i, dup := range dups {
+		if !rt.Delete(dup) {
+			t.Fatalf("Delete failed to find duplicate %d", i)
+		}
+		verify(t, rt.root)
+		if rt.Size() != 20+len(dups)-i-1 {
+			t.Errorf("tree has size %d after deleting %d duplicates", rt.Size(), i+1)
+		}
+		for j, other := range dups {
+			if rt.Contains(other, nil) != (j > i) {
+				t.Errorf("after deleting duplicate %d, duplicate %d found = %v", i, j, j <= i)
+			}
+		}
+		if rt.Delete(dup) {
+			t.Errorf("Delete removed duplicate %d twice", i)
+		}
+	}
+}
@@ -0,0 +1,244 @@
+// Copyright 2012 Daniel Connelly.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rtreego
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+)
+
+// A SplitStrategy decides how the entries of an overflowing node are divided
+// between the two nodes that replace it.  Split is passed the bounding boxes
+// of the entries, which it must not modify, and returns the indices of the
+// entries for each of the two nodes.  Every index must appear in exactly one
+// of the groups, and each group should hold at least minGroupSize entries.
+// The node holds MaxChildren+1 entries, so both groups must be nonempty for
+// each to fit in a node; a leaf overflowing as a bucket of identical boxes is
+// divided by the tree itself before its entries are split.
+//
+// By default trees split nodes with the quadratic algorithm of Guttman's
+// original paper; see WithSplitStrategy.
+type SplitStrategy interface {
+	Split(boxes []*Rect, minGroupSize int) (left, right []int)
+}
+
+// RStarSplit is the node split of the R*-tree.  It first chooses the axis
+// along which the entries are split, as the one that minimizes the total
+// margin of the candidate groups formed by sorting the entries along it.  It
+// then chooses the candidate along that axis whose groups overlap least,
+// breaking ties by their total area.  Compared to the quadratic split it
+// produces squarer nodes that overlap less, especially on skewed data, at the
+// cost of sorting the entries of each split node.
+//
+// Implemented per Section 4.2 of "The R*-tree: An Efficient and Robust Access
+// Method for Points and Rectangles" by N. Beckmann, H.-P. Kriegel,
+// R. Schneider and B. Seeger, Proceedings of ACM SIGMOD, p. 322-331, 1990.
+type RStarSplit struct{}
+
+// Split implements SplitStrategy.
+func (RStarSplit) Split(boxes []*Rect, minGroupSize int) (left, right []int) {
+	n := len(boxes)
+	m := min(max(minGroupSize, 1), n/2)
+
+	// choose the split axis
+	axis, minMargin := 0, math.Inf(1)
+	for i := range boxes[0].p {
+		margin := 0.0
+		for _, order := range rstarOrders(boxes, i) {
+			pre, suf := prefixBoxes(boxes, order)
+			for k := m; k <= n-m; k++ {
+				margin += pre[k-1].margin() + suf[k].margin()
+			}
+		}
+		if margin < minMargin {
+			axis, minMargin = i, margin
+		}
+	}
+
+	// choose the split index along it
+	minOverlap, minArea := math.Inf(1), math.Inf(1)
+	for _, order := range rstarOrders(boxes, axis) {
+		pre, suf := prefixBoxes(boxes, order)
+		for k := m; k <= n-m; k++ {
+			overlap := OverlapVolume(pre[k-1], suf[k])
+			area := pre[k-1].size() + suf[k].size()
+			if overlap < minOverlap || overlap == minOverlap && area < minArea {
+				minOverlap, minArea = overlap, area
+				left, right = order[:k], order[k:]
+			}
+		}
+	}
+	return left, right
+}
+
+// rstarOrders returns the indices of boxes sorted by their lower and by
+// their upper coordinate along axis.
+func rstarOrders(boxes []*Rect, axis int) [2][]int {
+	var orders [2][]int
+	for o := range orders {
+		order := make([]int, len(boxes))
+		for i := range order {
+			order[i] = i
+		}
+		sort.SliceStable(order, func(i, j int) bool {
+			bi, bj := boxes[order[i]], boxes[order[j]]
+			if o == 0 {
+				return bi.p[axis] < bj.p[axis]
+			}
+			return bi.q[axis] < bj.q[axis]
+		})
+		orders[o] = order
+	}
+	return orders
+}
+
+// prefixBoxes computes the bounding boxes of the first k+1 and of the last
+// len(order)-k boxes in the given order, for each k.
+func prefixBoxes(boxes []*Rect, order []int) (pre, suf []*Rect) {
+	n := len(order)
+	pre, suf = make([]*Rect, n), make([]*Rect, n)
+	pre[0], suf[n-1] = boxes[order[0]], boxes[order[n-1]]
+	for k := 1; k < n; k++ {
+		pre[k] = boundingBox(pre[k-1], boxes[order[k]])
+		suf[n-1-k] = boundingBox(suf[n-k], boxes[order[n-1-k]])
+	}
+	return pre, suf
+}
+
+// splitNode splits n with the tree's split strategy, reusing n as the left
+// node like node.split.
+func (tree *Rtree) splitNode(n *node) (left, right *node) {
+	if tree.splitter == nil {
+		return n.split(tree.newNode(n.parent, n.leaf, n.level), tree.MinChildren)
+	}
+
+	boxes := make([]*Rect, len(n.entries))
+	for i, e := range n.entries {
+		boxes[i] = e.bb
+	}
+	l, r := tree.splitter.Split(boxes, tree.MinChildren)
+	if len(l) == 0 || len(r) == 0 || len(l) > tree.MaxChildren || len(r) > tree.MaxChildren || len(l)+len(r) != len(n.entries) {
+		panic(fmt.Errorf("rtreego: split strategy divided %d entries into groups of %d and %d", len(n.entries), len(l), len(r)))
+	}
+	seen := make([]bool, len(n.entries))
+	for _, i := range append(l[:len(l):len(l)], r...) {
+		if i < 0 || i >= len(seen) || seen[i] {
+			panic(fmt.Errorf("rtreego: split strategy returned index %d of %d entries out of range or twice", i, len(n.entries)))
+		}
+		seen[i] = true
+	}
+
+	entries := n.entries
+	left = n
+	left.entries = make([]entry, 0, tree.MaxChildren+1)
+	right = tree.newNode(n.parent, n.leaf, n.level)
+	for _, i := range l {
+		assign(entries[i], left)
+	}
+	for _, i := range r {
+		assign(entries[i], right)
+	}
+	return left, right
+}
+
+// overflow handles a node with too many entries, either by removing some of
+// them for reinsertion, so that n no longer overflows and right is nil, or by
+// splitting it.
+func (tree *Rtree) overflow(n *node) (left, right *node) {
+	if tree.reinsert > 0 && n != tree.root && n.level < 64 && tree.reinserted&(1<<n.level) == 0 {
+		tree.reinserted |= 1 << n.level
+		if k := min(int(tree.reinsert*float64(len(n.entries))), len(n.entries)-tree.MinChildren); k > 0 {
+			tree.removeFarthest(n, k)
+			if tree.moved != nil && n.leaf {
+				for _, p := range tree.pending[len(tree.pending)-k:] {
+					*tree.moved = append(*tree.moved, p.e.obj)
+				}
+			}
+			return n, nil
+		}
+	}
+	tree.splitLevel = max(tree.splitLevel, n.level)
+	var extra []*node
+	if len(n.entries) > tree.MaxChildren+1 {
+		left, right, extra = tree.splitBucket(n)
+	} else {
+		left, right = tree.splitNode(n)
+	}
+	// only one node can be added to the parent by the split, so any others
+	// are queued for insertion at its level
+	for _, x := range extra {
+		tree.pending = append(tree.pending, pendingEntry{entry{bb: x.computeBoundingBox(), child: x}, n.level + 1})
+	}
+	if tree.moved != nil && n.leaf {
+		for _, x := range append(extra, right) {
+			for _, e := range x.entries {
+				*tree.moved = append(*tree.moved, e.obj)
+			}
+		}
+	}
+	return left, right
+}
+
+// splitBucket splits a leaf that overflowed as a bucket and then received
+// entries with other bounding boxes, so that it holds more than
+// MaxChildren+1 entries.  The largest run of entries with identical boxes
+// stays in n as a bucket, keeping at least MinChildren entries for the rest,
+// which are split again as needed so that every resulting node holds at most
+// MaxChildren entries or is a bucket itself.  The nodes beyond left and
+// right are returned in extra.
+func (tree *Rtree) splitBucket(n *node) (left, right *node, extra []*node) {
+	sorted := slices.Clone(n.entries)
+	sort.SliceStable(sorted, func(i, j int) bool { return lessCorners(sorted[i].bb, sorted[j].bb) })
+	start, end := 0, 0
+	for i := 0; i < len(sorted); {
+		j := i + 1
+		for j < len(sorted) && sorted[j].bb.Equal(sorted[i].bb) {
+			j++
+		}
+		if j-i > end-start {
+			start, end = i, j
+		}
+		i = j
+	}
+	run := sorted[start:end]
+	rest := append(slices.Clone(sorted[:start]), sorted[end:]...)
+	if k := tree.MinChildren - len(rest); k > 0 {
+		rest = append(rest, run[len(run)-k:]...)
+		run = run[:len(run)-k]
+	}
+
+	left = n
+	left.entries = append(make([]entry, 0, max(len(run), tree.MaxChildren+1)), run...)
+	right = tree.newNode(n.parent, true, 1)
+	right.entries = append(right.entries, rest...)
+	if len(right.entries) <= tree.MaxChildren || right.isBucket() {
+		return left, right, nil
+	}
+	if len(right.entries) > tree.MaxChildren+1 {
+		l, r, x := tree.splitBucket(right)
+		return left, l, append(x, r)
+	}
+	l, r := tree.splitNode(right)
+	return left, l, []*node{r}
+}
+
+// removeFarthest removes from n the k entries whose centers are farthest
+// from the center of n, and queues them for reinsertion, nearest first.
+func (tree *Rtree) removeFarthest(n *node, k int) {
+	c := n.computeBoundingBox().center()
+	dists := make([]float64, len(n.entries))
+	for i, e := range n.entries {
+		dists[i] = c.dist(e.bb.center())
+	}
+	sort.Stable(entrySlice{n.entries, dists})
+
+	keep := len(n.entries) - k
+	for _, e := range n.entries[keep:] {
+		tree.pending = append(tree.pending, pendingEntry{e, n.level})
+	}
+	n.entries = n.entries[:keep]
+}
@@ -0,0 +1,228 @@
+// Copyright 2012 Daniel Connelly.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rtreego
+
+import (
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+func TestRStarSplit(t *testing.T) {
+	// two clusters along the y axis
+	boxes := []*Rect{
+		mustRect(Point{0, 0, 0}, [Dim]float64{1, 1, 1}),
+		mustRect(Point{0, 20, 0}, [Dim]float64{1, 1, 1}),
+		mustRect(Point{1, 1, 0}, [Dim]float64{1, 1, 1}),
+		mustRect(Point{1, 21, 0}, [Dim]float64{1, 1, 1}),
+		mustRect(Point{2, 0, 0}, [Dim]float64{1, 1, 1}),
+	}
+	left, right := RStarSplit{}.Split(boxes, 2)
+	sort.Ints(left)
+	sort.Ints(right)
+	if len(left) != 3 || left[0] != 0 || left[1] != 2 || left[2] != 4 || len(right) != 2 || right[0] != 1 || right[1] != 3 {
+		t.Errorf("RStarSplit split the clusters into %v and %v", left, right)
+	}
+}
+
+// splitFunc adapts a function to SplitStrategy.
+type splitFunc func(boxes []*Rect, minGroupSize int) (left, right []int)
+
+func (f splitFunc) Split(boxes []*Rect, minGroupSize int) (left, right []int) {
+	return f(boxes, minGroupSize)
+}
+
+func TestSplitStrategyChecked(t *testing.T) {
+	for name, split := range map[string]splitFunc{
+		"an empty group": func(boxes []*Rect, _ int) ([]int, []int) {
+			return nil, []int{0, 1, 2, 3, 4}
+		},
+		"an index twice": func(boxes []*Rect, _ int) ([]int, []int) {
+			return []int{0, 1, 1}, []int{3, 4}
+		},
+		"an index out of range": func(boxes []*Rect, _ int) ([]int, []int) {
+			return []int{0, 1, 5}, []int{2, 3}
+		},
+		"a group too large": func(boxes []*Rect, _ int) ([]int, []int) {
+			return []int{0, 1, 2, 3, 4}, []int{0}
+		},
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("split strategy returning %s didn't panic", name)
+				}
+			}()
+			rt := NewTree(2, 4, WithSplitStrategy(split))
+			for _, obj := range randomRects(5, 39) {
+				rt.Insert(obj)
+			}
+		}()
+	}
+
+	// every split strategy is passed MaxChildren+1 boxes, even for
+	// overflowing buckets
+	sizes := map[int]bool{}
+	rt := NewTree(2, 4, WithSplitStrategy(splitFunc(func(boxes []*Rect, m int) ([]int, []int) {
+		sizes[len(boxes)] = true
+		return RStarSplit{}.Split(boxes, m)
+	})))
+	bb := mustRect(Point{1, 1, 1}, [Dim]float64{1, 1, 1})
+	for range 18 {
+		rt.Insert(bb)
+	}
+	for _, obj := range randomRects(50, 40) {
+		rt.Insert(obj)
+		rt.Insert(mustRect(Point{1.5, 1.5, 1.5}, [Dim]float64{0.1, 0.1, 0.1}))
+	}
+	if err := rt.Validate(); err != nil {
+		t.Errorf("tree invalid: %v", err)
+	}
+	if len(sizes) != 1 || !sizes[5] {
+		t.Errorf("split strategy was passed %v boxes; expected only 5", sizes)
+	}
+}
+
+// totalOverlap sums the overlap of every pair of boxes.
+func totalOverlap(boxes []*Rect) float64 {
+	sum := 0.0
+	for i, b1 := range boxes {
+		for _, b2 := range boxes[i+1:] {
+			sum += OverlapVolume(b1, b2)
+		}
+	}
+	return sum
+}
+
+func TestRStarSplitTree(t *testing.T) {
+	rnd := rand.New(rand.NewSource(36))
+	var objs []*Rect
+	for i := 0; i < 1000; i++ {
+		// long thin boxes, spread far more along x than along y and z
+		p := Point{rnd.Float64() * 1000, rnd.Float64() * 10, rnd.Float64() * 10}
+		objs = append(objs, mustRect(p, [Dim]float64{1 + rnd.Float64()*20, 1, 1}))
+	}
+
+	quadratic := NewTree(3, 8)
+	rstar := NewTree(3, 8, WithSplitStrategy(RStarSplit{}))
+	for _, obj := range objs {
+		quadratic.Insert(obj)
+		rstar.Insert(obj)
+	}
+	verify(t, rstar.root)
+	verifyTight(t, rstar.root)
+	rstar.root.walkNodes(func(n *node) {
+		if n != rstar.root && (len(n.entries) < 3 || len(n.entries) > 8) {
+			t.Errorf("node has %d entries", len(n.entries))
+		}
+	})
+
+	bb := mustRect(Point{200, 2, 2}, [Dim]float64{300, 5, 5})
+	if q, expected := rstar.SearchIntersect(bb), quadratic.SearchIntersect(bb); len(q) != len(expected) {
+		t.Errorf("SearchIntersect found %d objects; expected %d", len(q), len(expected))
+	}
+	q, r := totalOverlap(quadratic.GetAllBoundingBoxes()), totalOverlap(rstar.GetAllBoundingBoxes())
+	if r >= q {
+		t.Errorf("R* split gave total overlap %v; quadratic split gave %v", r, q)
+	}
+}
+
+func TestReinsertPercentage(t *testing.T) {
+	rnd := rand.New(rand.NewSource(37))
+	var objs []*Rect
+	for c := 0; c < 20; c++ {
+		cx, cy, cz := rnd.Float64()*1000, rnd.Float64()*1000, rnd.Float64()*1000
+		for i := 0; i < 100; i++ {
+			p := Point{cx + rnd.NormFloat64()*10, cy + rnd.NormFloat64()*10, cz + rnd.NormFloat64()*10}
+			objs = append(objs, mustRect(p, [Dim]float64{1, 1, 1}))
+		}
+	}
+
+	plain := NewTree(3, 8)
+	rt := NewTree(3, 8, WithReinsertPercentage(0.3))
+	for _, obj := range objs {
+		plain.Insert(obj)
+		rt.Insert(obj)
+	}
+	verify(t, rt.root)
+	verifyTight(t, rt.root)
+	if rt.Size() != len(objs) {
+		t.Errorf("tree has size %d; expected %d", rt.Size(), len(objs))
+	}
+	count := func(tree *Rtree) int {
+		n := 0
+		tree.root.walkNodes(func(*node) { n++ })
+		return n
+	}
+	objects := 0
+	rt.root.walk(func(e entry) bool {
+		objects++
+		return true
+	})
+	if objects != len(objs) {
+		t.Errorf("tree holds %d objects; expected %d", objects, len(objs))
+	}
+	if n, m := count(rt), count(plain); n >= m {
+		t.Errorf("tree with reinsertion has %d nodes; expected fewer than %d", n, m)
+	}
+
+	bb := mustRect(Point{200, 200, 200}, [Dim]float64{500, 500, 500})
+	if q, expected := rt.SearchIntersect(bb), plain.SearchIntersect(bb); len(q) != len(expected) {
+		t.Errorf("SearchIntersect found %d objects; expected %d", len(q), len(expected))
+	}
+	for _, obj := range objs[:500] {
+		if !rt.Delete(obj) {
+			t.Fatalf("Delete(%v) failed", obj)
+		}
+	}
+	verify(t, rt.root)
+}
+
+func TestReinsertPercentagePanics(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Errorf("WithReinsertPercentage(1) didn't panic")
+		}
+	}()
+	WithReinsertPercentage(1)
+}
+
+func TestLeastOverlap(t *testing.T) {
+	objs := randomRects(2000, 38)
+	plain := NewTree(3, 8)
+	rt := NewTree(3, 8, WithInsertHeuristic(LeastOverlap))
+	for _, obj := range objs {
+		plain.Insert(obj)
+		rt.Insert(obj)
+	}
+	verify(t, rt.root)
+	verifyTight(t, rt.root)
+	if err := rt.Validate(); err != nil {
+		t.Errorf("tree invalid: %v", err)
+	}
+
+	bb := mustRect(Point{20, 20, 20}, [Dim]float64{30, 30, 30})
+	if q, expected := rt.SearchIntersect(bb), plain.SearchIntersect(bb); len(q) != len(expected) {
+		t.Errorf("SearchIntersect found %d objects; expected %d", len(q), len(expected))
+	}
+	if o, expected := rt.Stats().Overlap, plain.Stats().Overlap; o >= expected {
+		t.Errorf("least-overlap insertion gave overlap %v; least enlargement gave %v", o, expected)
+	}
+	for _, obj := range objs[:1000] {
+		if !rt.Delete(obj) {
+			t.Fatalf("Delete(%v) failed", obj)
+		}
+	}
+	verify(t, rt.root)
+}
+
+func TestInsertHeuristicPanics(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Errorf("WithInsertHeuristic(-1) didn't panic")
+		}
+	}()
+	WithInsertHeuristic(-1)
+}
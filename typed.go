@@ -0,0 +1,72 @@
+// Copyright 2012 Daniel Connelly.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rtreego
+
+// TypedRtree is an R-tree that stores objects of a single type T, so that its
+// searches return them as T rather than as Spatial.  It is a thin layer over
+// an Rtree; only objects of type T can be inserted into it, so the conversion
+// of the results can't fail.
+type TypedRtree[T Spatial] struct {
+	tree *Rtree
+}
+
+// NewTypedTree creates a new R-tree of objects of type T with the specified
+// minimum and maximum branching factors and options.
+func NewTypedTree[T Spatial](MinChildren, MaxChildren int, opts ...Option) *TypedRtree[T] {
+	return &TypedRtree[T]{tree: NewTree(MinChildren, MaxChildren, opts...)}
+}
+
+// Insert inserts an object into the tree.
+func (t *TypedRtree[T]) Insert(obj T) {
+	t.tree.Insert(obj)
+}
+
+// Delete removes an object from the tree.  If the object is not found, ok
+// is false; otherwise ok is true.
+func (t *TypedRtree[T]) Delete(obj T) (ok bool) {
+	return t.tree.Delete(obj)
+}
+
+// Size returns the number of objects currently stored in the tree.
+func (t *TypedRtree[T]) Size() int {
+	return t.tree.Size()
+}
+
+// Depth returns the maximum depth of the tree.
+func (t *TypedRtree[T]) Depth() int {
+	return t.tree.Depth()
+}
+
+// SearchIntersect returns all objects that intersect the specified
+// rectangle and are accepted by filters, as for Rtree.SearchIntersect.
+func (t *TypedRtree[T]) SearchIntersect(bb *Rect, filters ...Filter) []T {
+	return typed[T](t.tree.SearchIntersect(bb, filters...))
+}
+
+// NearestNeighbor returns the object in the tree closest to p, and false if
+// the tree is empty.
+func (t *TypedRtree[T]) NearestNeighbor(p Point) (T, bool) {
+	obj := t.tree.NearestNeighbor(p)
+	if obj == nil {
+		var zero T
+		return zero, false
+	}
+	return obj.(T), true
+}
+
+// NearestNeighbors returns the k objects in the tree closest to p, or all of
+// them if there are fewer than k, in order of increasing distance.
+func (t *TypedRtree[T]) NearestNeighbors(k int, p Point) []T {
+	return typed[T](t.tree.NearestNeighbors(k, p))
+}
+
+// typed converts objects known to be of type T.
+func typed[T Spatial](objs []Spatial) []T {
+	ts := make([]T, len(objs))
+	for i, obj := range objs {
+		ts[i] = obj.(T)
+	}
+	return ts
+}
@@ -0,0 +1,52 @@
+// Copyright 2012 Daniel Connelly.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rtreego
+
+import "testing"
+
+func TestTypedRtree(t *testing.T) {
+	tt := NewTypedTree[*movable](3, 6)
+	if _, ok := tt.NearestNeighbor(Point{}); ok {
+		t.Errorf("NearestNeighbor of an empty tree reported an object")
+	}
+
+	var objs []*movable
+	for _, obj := range randomRects(100, 54) {
+		m := &movable{obj.Bounds()}
+		objs = append(objs, m)
+		tt.Insert(m)
+	}
+	verify(t, tt.tree.root)
+	if tt.Size() != len(objs) || tt.Depth() != tt.tree.Depth() {
+		t.Errorf("tree has size %d; expected %d", tt.Size(), len(objs))
+	}
+
+	bb := mustRect(Point{20, 20, 20}, [Dim]float64{40, 40, 40})
+	found := tt.SearchIntersect(bb)
+	expected := tt.tree.SearchIntersect(bb)
+	if len(found) != len(expected) {
+		t.Fatalf("SearchIntersect returned %d objects; expected %d", len(found), len(expected))
+	}
+	for i, obj := range found {
+		if Spatial(obj) != expected[i] {
+			t.Errorf("SearchIntersect()[%d] = %v; expected %v", i, obj, expected[i])
+		}
+	}
+
+	p := Point{50, 50, 50}
+	if nearest, ok := tt.NearestNeighbor(p); !ok || Spatial(nearest) != tt.tree.NearestNeighbor(p) {
+		t.Errorf("NearestNeighbor(%v) = %v, %v", p, nearest, ok)
+	}
+	if nearest := tt.NearestNeighbors(5, p); len(nearest) != 5 {
+		t.Errorf("NearestNeighbors returned %d objects; expected 5", len(nearest))
+	}
+	if nearest := tt.NearestNeighbors(200, p); len(nearest) != len(objs) {
+		t.Errorf("NearestNeighbors returned %d objects of %d", len(nearest), len(objs))
+	}
+
+	if !tt.Delete(objs[0]) || tt.Delete(objs[0]) || tt.Size() != len(objs)-1 {
+		t.Errorf("Delete failed to remove %v exactly once", objs[0])
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright 2012 Daniel Connelly.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rtreego
+
+import "fmt"
+
+// Validate checks the structural invariants of tree and returns an error
+// describing the first violation it finds, or nil if there is none.  It is
+// meant as a post-condition in tests of code that modifies trees, and visits
+// every node.
+//
+// It checks that every leaf is at the same depth, that parent pointers and
+// levels are consistent, that the size of the tree matches the number of
+// objects in it, that the bounding box of every internal entry is exactly
+// the bounding box of its child's entries, and that every node except the
+// root holds between MinChildren and MaxChildren entries.  A MinChildren
+// greater than (MaxChildren+1)/2 can't be met by both halves of a split, so
+// nodes are then only required to hold the MaxChildren+1-MinChildren entries
+// left over after the other half is filled.  Leaves whose
+// entries all have the same bounding box may overflow, since no split could
+// separate them.  While deletions from a tree created with WithLazyCondense
+// wait for Compact, nodes may be underfull or empty and their boxes only
+// need to contain their entries.
+func (tree *Rtree) Validate() error {
+	root := tree.root
+	if root.parent != nil {
+		return fmt.Errorf("rtreego: root has a parent")
+	}
+	if root.level != tree.height {
+		return fmt.Errorf("rtreego: root is at level %d of a tree of depth %d", root.level, tree.height)
+	}
+	if !root.leaf && len(root.entries) < 2 && !tree.loose() {
+		return fmt.Errorf("rtreego: internal root has %d entries", len(root.entries))
+	}
+	size, err := tree.validate(root, 0)
+	if err != nil {
+		return err
+	}
+	if size != tree.size {
+		return fmt.Errorf("rtreego: tree holds %d objects but has size %d", size, tree.size)
+	}
+	return nil
+}
+
+// validate checks the subtree of n, at the given depth, and returns the
+// number of objects in it.
+func (tree *Rtree) validate(n *node, depth int) (int, error) {
+	if n.leaf != (n.level == 1) {
+		return 0, fmt.Errorf("rtreego: node at depth %d has level %d but leaf %v", depth, n.level, n.leaf)
+	}
+	if n != tree.root {
+		minFill := min(tree.MinChildren, tree.MaxChildren+1-tree.MinChildren)
+		if len(n.entries) < minFill && !tree.loose() {
+			return 0, fmt.Errorf("rtreego: node at depth %d has %d entries, fewer than %d", depth, len(n.entries), minFill)
+		}
+	}
+	if len(n.entries) > tree.MaxChildren && !n.isBucket() {
+		return 0, fmt.Errorf("rtreego: node at depth %d has %d entries, more than %d", depth, len(n.entries), tree.MaxChildren)
+	}
+	if n.leaf {
+		return len(n.entries), nil
+	}
+
+	size := 0
+	for i, e := range n.entries {
+		child := e.child
+		if child == nil {
+			return 0, fmt.Errorf("rtreego: entry %d of the node at depth %d has no child", i, depth)
+		}
+		if child.parent != n {
+			return 0, fmt.Errorf("rtreego: child of entry %d of the node at depth %d has another parent", i, depth)
+		}
+		if child.level != n.level-1 {
+			return 0, fmt.Errorf("rtreego: child of entry %d of the node at depth %d has level %d; expected %d", i, depth, child.level, n.level-1)
+		}
+		if len(child.entries) > 0 {
+			bb := child.computeBoundingBox()
+			if tree.loose() && !e.bb.containsRect(bb) || !tree.loose() && !e.bb.Equal(bb) {
+				return 0, fmt.Errorf("rtreego: entry %d of the node at depth %d has box %v; its children are bounded by %v", i, depth, e.bb, bb)
+			}
+		}
+		sub, err := tree.validate(child, depth+1)
+		if err != nil {
+			return 0, err
+		}
+		size += sub
+	}
+	return size, nil
+}
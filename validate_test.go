@@ -0,0 +1,109 @@
+// Copyright 2012 Daniel Connelly.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rtreego
+
+import "testing"
+
+func TestValidate(t *testing.T) {
+	objs := randomRects(400, 45)
+	for name, rt := range map[string]*Rtree{
+		"quadratic": NewTree(3, 6),
+		"(2, 3)":    NewTree(2, 3),
+		"R*":        NewTree(3, 8, WithSplitStrategy(RStarSplit{}), WithReinsertPercentage(0.3)),
+		"lazy":      NewTree(3, 6, WithLazyCondense()),
+	} {
+		for i, obj := range objs {
+			rt.Insert(obj)
+			if i%50 == 0 {
+				if err := rt.Validate(); err != nil {
+					t.Fatalf("%s tree invalid after %d insertions: %v", name, i+1, err)
+				}
+			}
+		}
+		for i, obj := range objs[:300] {
+			rt.Delete(obj)
+			if i%50 == 0 {
+				if err := rt.Validate(); err != nil {
+					t.Fatalf("%s tree invalid after %d deletions: %v", name, i+1, err)
+				}
+			}
+		}
+		rt.Compact()
+		if err := rt.Validate(); err != nil {
+			t.Errorf("%s tree invalid after compaction: %v", name, err)
+		}
+	}
+
+	bulk, err := BulkLoad(3, 7, 0.8, objs)
+	if err != nil {
+		t.Fatalf("BulkLoad failed: %v", err)
+	}
+	if err := bulk.Validate(); err != nil {
+		t.Errorf("bulk-loaded tree invalid: %v", err)
+	}
+	if err := NewTree(3, 6).Validate(); err != nil {
+		t.Errorf("empty tree invalid: %v", err)
+	}
+
+	// a bucket of identical boxes may overflow its leaf
+	buckets := NewTree(2, 4)
+	for i := 0; i < 10; i++ {
+		buckets.Insert(namedRect{mustRect(Point{1, 1, 1}, [Dim]float64{1, 1, 1}), string(rune('a' + i))})
+	}
+	if err := buckets.Validate(); err != nil {
+		t.Errorf("tree of identical boxes invalid: %v", err)
+	}
+}
+
+func TestValidateViolations(t *testing.T) {
+	build := func() *Rtree {
+		rt := NewTree(3, 6)
+		for _, obj := range randomRects(100, 46) {
+			rt.Insert(obj)
+		}
+		return rt
+	}
+	firstInternal := func(rt *Rtree) *node {
+		return rt.root.entries[0].child
+	}
+
+	for name, corrupt := range map[string]func(rt *Rtree){
+		"loose box": func(rt *Rtree) {
+			rt.root.entries[0].bb = rt.root.entries[0].bb.Expand(1)
+		},
+		"tight box": func(rt *Rtree) {
+			rt.root.entries[0].bb = rt.root.entries[0].bb.Expand(-0.5)
+		},
+		"underfull node": func(rt *Rtree) {
+			n := firstInternal(rt)
+			n.entries = n.entries[:1]
+		},
+		"overfull node": func(rt *Rtree) {
+			n := firstInternal(rt)
+			for len(n.entries) <= rt.MaxChildren {
+				n.entries = append(n.entries, n.entries[0])
+			}
+		},
+		"wrong parent": func(rt *Rtree) {
+			n := firstInternal(rt)
+			n.entries[0].child.parent = rt.root
+		},
+		"wrong size": func(rt *Rtree) {
+			rt.size++
+		},
+		"wrong level": func(rt *Rtree) {
+			firstInternal(rt).level++
+		},
+	} {
+		rt := build()
+		if err := rt.Validate(); err != nil {
+			t.Fatalf("tree invalid before corrupting it: %v", err)
+		}
+		corrupt(rt)
+		if err := rt.Validate(); err == nil {
+			t.Errorf("Validate accepted a tree with a %s", name)
+		}
+	}
+}